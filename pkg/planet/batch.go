@@ -0,0 +1,113 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MaxBatchSize = 500
+)
+
+var (
+	ErrBatchAborted = errors.New("not inserted, an earlier planet of the ordered batch failed")
+)
+
+// BatchResult is the outcome of inserting one planet of a batch. Err is nil
+// when the planet was inserted.
+type BatchResult struct {
+	Planet Planet
+	Err    error
+}
+
+// InsertBatch inserts planets in a single repository call and returns one
+// result per planet, in the same order. An ordered batch stops at the first
+// failure and reports the planets after it with ErrBatchAborted, an unordered
+// batch inserts every planet it can. Names repeated within the batch, ignoring
+// case, fail with an *AlreadyExistsError like names already taken. Films are
+// not resolved inline, the planets are left pending for RetryPendingEnrichment
+// instead of calling SWAPI hundreds of times. The returned error is only set
+// when the batch as a whole failed.
+func (s *Service) InsertBatch(ctx context.Context, planets []Planet, ordered bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(planets))
+	if len(planets) == 0 {
+		return results, nil
+	}
+
+	now := writeTime()
+	prepared := make([]Planet, len(planets))
+	for i, p := range planets {
+		p.ID = primitive.NewObjectID()
+		p.Version = 1
+		p.UpdatedAt = now
+		if s.films != nil {
+			p.Enrichment = EnrichmentPending
+		}
+		results[i].Planet = p
+		prepared[i] = p
+	}
+
+	// Names repeated within the batch are conflicts of their own, the
+	// repository is only given the first planet holding each name.
+	firsts := map[string]int{}
+	duplicateOf := map[int]int{}
+	var inserted []Planet
+	var positions []int
+	for i, p := range prepared {
+		name := strings.ToLower(p.Name)
+		if first, ok := firsts[name]; ok {
+			duplicateOf[i] = first
+			if ordered {
+				break
+			}
+			continue
+		}
+		firsts[name] = i
+		inserted = append(inserted, p)
+		positions = append(positions, i)
+	}
+
+	if len(inserted) > 0 {
+		errs, err := s.repo.Insert(ctx, inserted, ordered)
+		if err != nil {
+			return nil, err
+		}
+		for j, err := range errs {
+			results[positions[j]].Err = err
+		}
+	}
+
+	attempted := make(map[int]bool, len(positions))
+	for _, i := range positions {
+		attempted[i] = true
+	}
+	stopped := false
+	for i := range results {
+		switch {
+		case attempted[i]:
+			stopped = stopped || (ordered && results[i].Err != nil)
+		case stopped:
+			results[i].Err = ErrBatchAborted
+		default:
+			results[i].Err = batchConflict(results, i, duplicateOf[i])
+			stopped = ordered
+		}
+	}
+
+	return results, nil
+}
+
+// batchConflict reports a planet whose name is already held by an earlier
+// planet of the batch, pointing to the planet actually holding it: the
+// earlier one, or the stored one it conflicted with.
+func batchConflict(results []BatchResult, i, first int) error {
+	conflict := &AlreadyExistsError{Name: results[i].Planet.Name, ExistingID: results[first].Planet.ID}
+	var existing *AlreadyExistsError
+	if errors.As(results[first].Err, &existing) {
+		conflict.ExistingID = existing.ExistingID
+	}
+	return conflict
+}
@@ -0,0 +1,88 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_service_InsertBatch(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	if _, err := s.Insert(ctx, Planet{Name: "Tatooine"}); err != nil {
+		t.Fatalf("service.InsertBatch() an error occurred inserting a planet for test")
+	}
+
+	tests := []struct {
+		name        string
+		givenNames  []string
+		ordered     bool
+		wantErrType []error
+	}{
+		{
+			name:        "when the batch is unordered, then it should insert every planet it can",
+			givenNames:  []string{"Hoth", "tatooine", "Dagobah"},
+			wantErrType: []error{nil, ErrPlanetAlreadyExists, nil},
+		},
+		{
+			name:        "when the batch is ordered, then it should stop at the first failure",
+			givenNames:  []string{"Naboo", "TATOOINE", "Endor"},
+			ordered:     true,
+			wantErrType: []error{nil, ErrPlanetAlreadyExists, ErrBatchAborted},
+		},
+		{
+			name:        "when a name is repeated in an unordered batch, then it should report the repetition as a conflict",
+			givenNames:  []string{"Bespin", "Kamino", "BESPIN"},
+			wantErrType: []error{nil, nil, ErrPlanetAlreadyExists},
+		},
+		{
+			name:        "when a name is repeated in an ordered batch, then it should stop at the repetition",
+			givenNames:  []string{"Yavin", "Geonosis", "yavin", "Mustafar"},
+			ordered:     true,
+			wantErrType: []error{nil, nil, ErrPlanetAlreadyExists, ErrBatchAborted},
+		},
+		{
+			name:        "when a name taken by a stored planet is repeated, then it should report both as conflicts",
+			givenNames:  []string{"Tatooine", "Utapau", "tatooine"},
+			wantErrType: []error{ErrPlanetAlreadyExists, nil, ErrPlanetAlreadyExists},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planets := make([]Planet, len(tt.givenNames))
+			for i, name := range tt.givenNames {
+				planets[i] = Planet{Name: name}
+			}
+
+			results, err := s.InsertBatch(ctx, planets, tt.ordered)
+			if err != nil {
+				t.Fatalf("service.InsertBatch() unexpected error %v", err)
+			}
+			for i, result := range results {
+				if !errors.Is(result.Err, tt.wantErrType[i]) {
+					t.Errorf("service.InsertBatch() item %d errorType = %v, wantErrorType %v", i, result.Err, tt.wantErrType[i])
+				}
+				_, getErr := s.GetByID(ctx, result.Planet.ID.Hex())
+				assert.Equal(t, result.Err == nil, getErr == nil, "service.InsertBatch() item %d unexpected stored state", i)
+			}
+		})
+	}
+}
+
+func Test_service_InsertBatch_duplicates(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+
+	results, err := s.InsertBatch(context.Background(), []Planet{{Name: "Hoth"}, {Name: "hoth"}}, false)
+	if err != nil {
+		t.Fatalf("service.InsertBatch() unexpected error %v", err)
+	}
+
+	var conflict *AlreadyExistsError
+	if !errors.As(results[1].Err, &conflict) {
+		t.Fatalf("service.InsertBatch() errorType = %v, want an *AlreadyExistsError", results[1].Err)
+	}
+	assert.Equal(t, results[0].Planet.ID, conflict.ExistingID, "service.InsertBatch() the conflict should point to the first planet of the name")
+}
@@ -0,0 +1,19 @@
+package planet
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Delete moves a planet to the trash by stamping it with deleted_at. The
+// planet is only removed for good by Purge once the retention expires.
+func (s *Service) Delete(ctx context.Context, id string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+
+	at := writeTime()
+	change := Change{At: at, Set: map[string]interface{}{"deleted_at": at}}
+	_, err := s.repo.Update(ctx, objectID, change)
+
+	return err
+}
@@ -0,0 +1,63 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Test_service_Delete(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	id, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+
+	existingPlanet := Planet{
+		ID:   id,
+		Name: "Mars",
+	}
+
+	ctx := context.Background()
+	_, err := s.repo.Insert(ctx, []Planet{existingPlanet}, true)
+	if err != nil {
+		t.Fatalf("service.Delete() an error occurred inserting a planet for test")
+	}
+
+	tests := []struct {
+		name        string
+		givenID     string
+		wantErr     bool
+		wantErrType error
+	}{
+		{
+			name:    "when the planet exists, then it should delete with success",
+			givenID: existingPlanet.ID.Hex(),
+		},
+		{
+			name:        "when the planet was already deleted, then it should return planet not found err",
+			givenID:     existingPlanet.ID.Hex(),
+			wantErr:     true,
+			wantErrType: ErrPlanetNotFound,
+		},
+		{
+			name:        "when the planet does not exist, then it should return planet not found err",
+			givenID:     "5f165e2e4de9b442e60b3905",
+			wantErr:     true,
+			wantErrType: ErrPlanetNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Delete(ctx, tt.givenID)
+			if err != nil || tt.wantErr {
+				if !errors.Is(err, tt.wantErrType) {
+					t.Fatalf("service.Delete() errType = %v, wantErrType %v", err, tt.wantErrType)
+				}
+				return
+			}
+			if _, err := s.GetByID(ctx, tt.givenID); !errors.Is(err, ErrPlanetNotFound) {
+				t.Errorf("service.Delete() deleted planet still visible, err = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	EnrichmentDone    = "done"
+	EnrichmentPending = "pending"
+)
+
+// FilmsResolver finds the films a planet appeared in, by planet name.
+type FilmsResolver interface {
+	Films(ctx context.Context, planetName string) ([]string, error)
+}
+
+// enrich fills the films of a planet. When the upstream is unavailable or
+// slower than the enrichment timeout the planet is flagged as pending, so
+// RetryPendingEnrichment can finish it later without making the caller wait
+// or fail.
+func (s *Service) enrich(ctx context.Context, planetDocument *Planet) {
+	if s.films == nil {
+		return
+	}
+
+	if s.enrichTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.enrichTimeout)
+		defer cancel()
+	}
+	films, err := s.films.Films(ctx, planetDocument.Name)
+	if err != nil {
+		planetDocument.Enrichment = EnrichmentPending
+		return
+	}
+
+	planetDocument.Films = films
+	planetDocument.FilmCount = len(films)
+	planetDocument.Enrichment = EnrichmentDone
+}
+
+// reenrich adds the films of the new name to a change renaming a planet, as
+// the films of the old name no longer apply. Changes keeping the name, up to
+// its case, are left alone.
+func (s *Service) reenrich(ctx context.Context, id primitive.ObjectID, change *Change) {
+	name, ok := change.Set["name"].(string)
+	if s.films == nil || !ok {
+		return
+	}
+	current, err := s.repo.FindByID(ctx, id)
+	if err != nil || strings.EqualFold(current.Name, name) {
+		// A planet that can't be read fails the change itself.
+		return
+	}
+
+	renamed := Planet{Name: name}
+	s.enrich(ctx, &renamed)
+	films := renamed.Films
+	if films == nil {
+		films = []string{}
+	}
+	change.Set["films"] = films
+	change.Set["film_count"] = int64(len(films))
+	change.Set["enrichment"] = renamed.Enrichment
+}
+
+// RetryPendingEnrichment enriches up to limit pending planets and returns how
+// many were completed. It stops at the first upstream failure.
+func (s *Service) RetryPendingEnrichment(ctx context.Context, limit int64) (int, error) {
+	if s.films == nil {
+		return 0, nil
+	}
+
+	var pending []Planet
+	criteria := Criteria{
+		Query: Query{Filters: []Filter{{Field: "enrichment", Operator: OpEq, Value: EnrichmentPending}}},
+		Limit: limit,
+	}
+	err := s.repo.List(ctx, criteria, func(p Planet) error {
+		pending = append(pending, p)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	enriched := 0
+	for _, p := range pending {
+		films, err := s.films.Films(ctx, p.Name)
+		if err != nil {
+			return enriched, err
+		}
+
+		// The write is conditional on the version read, a planet changed in
+		// the meantime is left for the next run.
+		change := Change{
+			Version: p.Version,
+			At:      writeTime(),
+			Set: map[string]interface{}{
+				"films":      films,
+				"film_count": int64(len(films)),
+				"enrichment": EnrichmentDone,
+			},
+		}
+		_, err = s.repo.Update(ctx, p.ID, change)
+		if errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrPlanetNotFound) {
+			continue
+		}
+		if err != nil {
+			return enriched, err
+		}
+		enriched++
+	}
+
+	return enriched, nil
+}
@@ -0,0 +1,111 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type filmsResolverMock struct {
+	films []string
+	err   error
+	// slow makes the lookups last until their context is done.
+	slow  bool
+	names []string
+}
+
+func (f *filmsResolverMock) Films(ctx context.Context, planetName string) ([]string, error) {
+	f.names = append(f.names, planetName)
+	if f.slow {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return f.films, f.err
+}
+
+func Test_service_Enrichment(t *testing.T) {
+	resolver := &filmsResolverMock{films: []string{"https://swapi.dev/api/films/1/"}}
+	s := NewService(NewMemoryRepository()).WithFilmsResolver(resolver)
+	ctx := context.Background()
+
+	tatooine, err := s.Insert(ctx, Planet{Name: "Tatooine"})
+	if err != nil {
+		t.Fatalf("service.Insert() unexpected error %v", err)
+	}
+	assert.Equal(t, EnrichmentDone, tatooine.Enrichment, "service.Insert() unexpected enrichment status")
+	assert.Equal(t, 1, tatooine.FilmCount, "service.Insert() unexpected film count")
+
+	resolver.err = errors.New("swapi is down")
+	hoth, err := s.Insert(ctx, Planet{Name: "Hoth"})
+	if err != nil {
+		t.Fatalf("service.Insert() upstream failure should not block the insert, got %v", err)
+	}
+	assert.Equal(t, EnrichmentPending, hoth.Enrichment, "service.Insert() unexpected enrichment status")
+
+	if _, err := s.RetryPendingEnrichment(ctx, 10); err == nil {
+		t.Errorf("service.RetryPendingEnrichment() expected the upstream error")
+	}
+
+	resolver.err = nil
+	enriched, err := s.RetryPendingEnrichment(ctx, 10)
+	if err != nil {
+		t.Fatalf("service.RetryPendingEnrichment() unexpected error %v", err)
+	}
+	assert.Equal(t, 1, enriched, "service.RetryPendingEnrichment() unexpected enriched count")
+
+	got, err := s.GetByID(ctx, hoth.ID.Hex())
+	if err != nil {
+		t.Fatalf("service.RetryPendingEnrichment() an error occurred retrieving a planet for test")
+	}
+	assert.Equal(t, EnrichmentDone, got.Enrichment, "service.RetryPendingEnrichment() unexpected enrichment status")
+	assert.Equal(t, resolver.films, got.Films, "service.RetryPendingEnrichment() unexpected films")
+}
+
+func Test_service_Enrichment_timeout(t *testing.T) {
+	resolver := &filmsResolverMock{slow: true}
+	s := NewService(NewMemoryRepository()).WithFilmsResolver(resolver).WithEnrichmentTimeout(10 * time.Millisecond)
+
+	inserted, err := s.Insert(context.Background(), Planet{Name: "Tatooine"})
+	if err != nil {
+		t.Fatalf("service.Insert() a slow upstream should not fail the insert, got %v", err)
+	}
+	assert.Equal(t, EnrichmentPending, inserted.Enrichment, "service.Insert() unexpected enrichment status")
+}
+
+func Test_service_Enrichment_rename(t *testing.T) {
+	resolver := &filmsResolverMock{films: []string{"https://swapi.dev/api/films/1/"}}
+	s := NewService(NewMemoryRepository()).WithFilmsResolver(resolver)
+	ctx := context.Background()
+
+	planet, err := s.Insert(ctx, Planet{Name: "Tatooine"})
+	if err != nil {
+		t.Fatalf("service.Insert() unexpected error %v", err)
+	}
+
+	planet.Name = "tatooine"
+	if _, err := s.Update(ctx, planet); err != nil {
+		t.Fatalf("service.Update() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Tatooine"}, resolver.names, "service.Update() a change of case should not enrich the planet again")
+
+	resolver.films = []string{"https://swapi.dev/api/films/2/", "https://swapi.dev/api/films/3/"}
+	renamed, err := s.Patch(ctx, planet.ID.Hex(), Planet{Name: "Hoth"}, []string{"name"})
+	if err != nil {
+		t.Fatalf("service.Patch() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Tatooine", "Hoth"}, resolver.names, "service.Patch() unexpected lookups")
+	assert.Equal(t, resolver.films, renamed.Films, "service.Patch() the films of the new name should replace the old ones")
+	assert.Equal(t, 2, renamed.FilmCount, "service.Patch() unexpected film count")
+
+	resolver.err = errors.New("swapi is down")
+	renamed.Name = "Dagobah"
+	pending, err := s.Update(ctx, renamed)
+	if err != nil {
+		t.Fatalf("service.Update() upstream failure should not block the update, got %v", err)
+	}
+	assert.Equal(t, EnrichmentPending, pending.Enrichment, "service.Update() unexpected enrichment status")
+	assert.Empty(t, pending.Films, "service.Update() the films of the old name should be dropped")
+}
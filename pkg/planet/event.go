@@ -0,0 +1,149 @@
+package planet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EventType names what happened to a planet.
+type EventType string
+
+const (
+	PlanetCreated EventType = "PlanetCreated"
+	PlanetUpdated EventType = "PlanetUpdated"
+	// PlanetDeleted is raised when a planet is moved to the trash. Restoring
+	// it raises PlanetUpdated, and purging it raises nothing more.
+	PlanetDeleted EventType = "PlanetDeleted"
+)
+
+// Event is a domain event of a planet. Repositories write it to their outbox
+// along with the change it comes from, so it is published if and only if the
+// change is stored.
+type Event struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Type       EventType          `bson:"type" json:"type"`
+	PlanetID   primitive.ObjectID `bson:"planet_id" json:"planet_id"`
+	Version    int64              `bson:"version" json:"version"`
+	OccurredAt time.Time          `bson:"occurred_at" json:"occurred_at"`
+	RequestID  string             `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	Actor      string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	// Planet is the planet as stored after the change.
+	Planet Planet `bson:"planet" json:"planet"`
+}
+
+// newEvent tells downstream services about the change a revision records.
+func newEvent(revision Revision) Event {
+	eventType := PlanetUpdated
+	switch {
+	case revision.Before == nil:
+		eventType = PlanetCreated
+	case revision.Before.DeletedAt == nil && revision.After.DeletedAt != nil:
+		eventType = PlanetDeleted
+	}
+
+	return Event{
+		ID:         primitive.NewObjectID(),
+		Type:       eventType,
+		PlanetID:   revision.PlanetID,
+		Version:    revision.Version,
+		OccurredAt: revision.Timestamp,
+		RequestID:  revision.RequestID,
+		Actor:      revision.Actor,
+		Planet:     revision.After,
+	}
+}
+
+// EventPublisher delivers planet events to downstream services. Delivery is
+// at least once: an event whose publication failed, or whose success could
+// not be recorded, is published again, so consumers must be idempotent.
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// WithEventPublisher sets where RelayEvents publishes the outbox to.
+func (s *Service) WithEventPublisher(publisher EventPublisher) *Service {
+	s.publisher = publisher
+	return s
+}
+
+// RelayEvents publishes up to limit pending events of the outbox, oldest
+// first, and returns how many were published. It stops at the first failed
+// publication, so events are not published out of order and the failed one
+// is attempted again on the next run.
+func (s *Service) RelayEvents(ctx context.Context, limit int64) (int, error) {
+	if s.publisher == nil {
+		return 0, nil
+	}
+
+	pending, err := s.repo.PendingEvents(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, event := range pending {
+		if err := s.publisher.Publish(ctx, event); err != nil {
+			return published, err
+		}
+		if err := s.repo.AckEvent(ctx, event.ID); err != nil {
+			return published, err
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+// InProcessPublisher hands events to subscribers in the same process. It is
+// the publisher used when no message broker is configured, and in tests.
+type InProcessPublisher struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(context.Context, Event) error
+	next        int
+}
+
+func NewInProcessPublisher() *InProcessPublisher {
+	return &InProcessPublisher{
+		subscribers: map[int]func(context.Context, Event) error{},
+	}
+}
+
+// Subscribe calls handler for every event published until the returned
+// function is called.
+func (p *InProcessPublisher) Subscribe(handler func(context.Context, Event) error) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.next
+	p.next++
+	p.subscribers[id] = handler
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.subscribers, id)
+	}
+}
+
+// Publish calls every subscriber and returns the first error met. Every
+// subscriber is called even when one fails, so a retried event reaches the
+// others again.
+func (p *InProcessPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.RLock()
+	handlers := make([]func(context.Context, Event) error, 0, len(p.subscribers))
+	for _, handler := range p.subscribers {
+		handlers = append(handlers, handler)
+	}
+	p.mu.RUnlock()
+
+	var err error
+	for _, handler := range handlers {
+		if handlerErr := handler(ctx, event); handlerErr != nil && err == nil {
+			err = handlerErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_service_RelayEvents(t *testing.T) {
+	ctx := context.Background()
+	publisher := NewInProcessPublisher()
+	s := NewService(NewMemoryRepository()).WithEventPublisher(publisher)
+
+	hoth, _ := s.Insert(ctx, Planet{Name: "Hoth"})
+	if err := s.Delete(ctx, hoth.ID.Hex()); err != nil {
+		t.Fatalf("service.Delete() an error occurred deleting a planet for test")
+	}
+
+	var received []EventType
+	failing := true
+	unsubscribe := publisher.Subscribe(func(ctx context.Context, event Event) error {
+		received = append(received, event.Type)
+		if failing {
+			return errors.New("broker is down")
+		}
+		return nil
+	})
+	defer unsubscribe()
+
+	published, err := s.RelayEvents(ctx, 10)
+	if err == nil {
+		t.Fatalf("service.RelayEvents() expected the publisher error")
+	}
+	assert.Equal(t, 0, published, "service.RelayEvents() a failed event should stop the relay")
+
+	failing = false
+	published, err = s.RelayEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("service.RelayEvents() unexpected error %v", err)
+	}
+	assert.Equal(t, 2, published, "service.RelayEvents() unexpected published events")
+	assert.Equal(t, []EventType{PlanetCreated, PlanetCreated, PlanetDeleted}, received, "service.RelayEvents() a failed event should be published again")
+
+	published, _ = s.RelayEvents(ctx, 10)
+	assert.Equal(t, 0, published, "service.RelayEvents() published events should leave the outbox")
+}
@@ -0,0 +1,18 @@
+package planet
+
+import (
+	"context"
+	"time"
+)
+
+// Export calls each for every planet matching the query, in the query order.
+// Planets are streamed from the repository, so the collection is never held
+// in memory. With a non-zero at, the planets are exported as they were at
+// that time, rebuilt from their revisions like in List, which are read as a
+// whole first. It stops at the first error returned by each.
+func (s *Service) Export(ctx context.Context, query Query, at time.Time, each func(Planet) error) error {
+	if !at.IsZero() {
+		return s.listAt(ctx, at, Criteria{Query: query}, each)
+	}
+	return s.repo.List(ctx, Criteria{Query: query}, each)
+}
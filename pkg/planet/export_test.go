@@ -0,0 +1,73 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_service_Export(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	for _, p := range []Planet{
+		{Name: "Tatooine", Climate: []string{"arid"}, Diameter: int64Ptr(10465)},
+		{Name: "Hoth", Climate: []string{"frozen"}, Diameter: int64Ptr(7200)},
+		{Name: "Jakku", Climate: []string{"arid"}},
+	} {
+		if _, err := s.Insert(ctx, p); err != nil {
+			t.Fatalf("service.Export() an error occurred inserting a planet for test")
+		}
+	}
+
+	query := Query{
+		Filters: []Filter{{Field: "climate", Operator: OpEq, Value: "arid"}},
+		Sort:    []SortField{{Field: "name", Descending: true}},
+	}
+	var names []string
+	err := s.Export(ctx, query, time.Time{}, func(p Planet) error {
+		names = append(names, p.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("service.Export() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Tatooine", "Jakku"}, names, "service.Export() unexpected planets")
+
+	stop := errors.New("stop")
+	if err := s.Export(ctx, Query{}, time.Time{}, func(Planet) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("service.Export() errorType = %v, wantErrorType %v", err, stop)
+	}
+}
+
+func Test_service_Export_asOf(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	hoth, err := s.Insert(ctx, Planet{Name: "Hoth"})
+	if err != nil {
+		t.Fatalf("service.Export() an error occurred inserting a planet for test")
+	}
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	hoth.Name = "Echo Base"
+	if _, err := s.Update(ctx, hoth); err != nil {
+		t.Fatalf("service.Export() an error occurred updating a planet for test")
+	}
+	if _, err := s.Insert(ctx, Planet{Name: "Dagobah"}); err != nil {
+		t.Fatalf("service.Export() an error occurred inserting a planet for test")
+	}
+
+	var names []string
+	err = s.Export(ctx, Query{}, before, func(p Planet) error {
+		names = append(names, p.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("service.Export() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Hoth"}, names, "service.Export() the planets should be exported as they were")
+}
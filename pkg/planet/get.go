@@ -4,9 +4,7 @@ import (
 	"context"
 	"errors"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	driver "go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
@@ -14,14 +12,11 @@ var (
 )
 
 func (s *Service) GetByID(ctx context.Context, id string) (Planet, error) {
-	var planet Planet
-
 	objectID, _ := primitive.ObjectIDFromHex(id)
-	result := s.db.FindOne(ctx, bson.M{"_id": objectID})
-	err := result.Decode(&planet)
+	planet, err := s.repo.FindByID(ctx, objectID)
 
-	if errors.Is(err, driver.ErrNoDocuments) {
-		return planet, ErrPlanetNotFound
+	if err == nil && planet.DeletedAt != nil {
+		return Planet{}, ErrPlanetNotFound
 	}
 		
 	return planet, err
@@ -4,23 +4,13 @@ import (
 	"context"
 	"errors"
 	"testing"
-	"time"
-
-	"star-wars/pkg/testutils/docker"
 
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func Test_service_GetByID(t *testing.T) {
-	mongoServer := docker.NewMongo()
-	mongoServer.WithTestPort(t).
-		Start(t)
-	defer mongoServer.Stop()
-
-	mongo := mongoCollection(mongoServer.GetHost())
-
-	s := NewService(mongo, 2*time.Second)
+	s := NewService(NewMemoryRepository())
 	id, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
 
 	existingPlanet := Planet{
@@ -29,7 +19,7 @@ func Test_service_GetByID(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	_, err := s.db.InsertOne(ctx, existingPlanet)
+	_, err := s.repo.Insert(ctx, []Planet{existingPlanet}, true)
 	if err != nil {
 		t.Fatalf("service.Insert() an error occurred inserting a planet for test")
 	}
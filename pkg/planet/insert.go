@@ -9,11 +9,15 @@ import (
 func (s *Service) Insert(ctx context.Context, planetDocument Planet) (Planet, error) {
 
 	planetDocument.ID = primitive.NewObjectID()
-	_, err := s.db.InsertOne(ctx, planetDocument)
+	planetDocument.Version = 1
+	planetDocument.UpdatedAt = writeTime()
+	s.enrich(ctx, &planetDocument)
+
+	errs, err := s.repo.Insert(ctx, []Planet{planetDocument}, true)
 
 	if err != nil {
 		return planetDocument, err
 	}
 
-	return planetDocument, err
+	return planetDocument, errs[0]
 }
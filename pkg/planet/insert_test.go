@@ -5,19 +5,11 @@ import (
 	"testing"
 	"time"
 
-	"star-wars/pkg/testutils/docker"
-
 	"github.com/stretchr/testify/assert"
 )
 
 func Test_service_Insert(t *testing.T) {
-	mongoServer := docker.NewMongo()
-	mongoServer.WithTestPort(t).
-		Start(t)
-	defer mongoServer.Stop()
-
-	mongo := mongoCollection(mongoServer.GetHost())
-	s := NewService(mongo, 2*time.Second)
+	s := NewService(NewMemoryRepository())
 	ctx := context.Background()
 
 	tests := []struct {
@@ -34,6 +26,31 @@ func Test_service_Insert(t *testing.T) {
 				Name: "Pluto",
 			},
 		},
+		{
+			name: "when given planet with every attribute, then it should persist all of them",
+			givenPlanet: Planet{
+				Name:           "Tatooine",
+				RotationPeriod: int64Ptr(23),
+				OrbitalPeriod:  int64Ptr(304),
+				Diameter:       int64Ptr(10465),
+				Climate:        []string{"arid"},
+				Gravity:        float64Ptr(1),
+				Terrain:        []string{"desert"},
+				SurfaceWater:   float64Ptr(1),
+				Population:     int64Ptr(200000),
+			},
+			wantPlanet: Planet{
+				Name:           "Tatooine",
+				RotationPeriod: int64Ptr(23),
+				OrbitalPeriod:  int64Ptr(304),
+				Diameter:       int64Ptr(10465),
+				Climate:        []string{"arid"},
+				Gravity:        float64Ptr(1),
+				Terrain:        []string{"desert"},
+				SurfaceWater:   float64Ptr(1),
+				Population:     int64Ptr(200000),
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -42,6 +59,24 @@ func Test_service_Insert(t *testing.T) {
 				t.Fatalf("service.Insert() unexpected error %v", err)
 			}
 			assert.Equal(t, tt.givenPlanet.Name, planet.Name, "service.Insert() unexpected planet name")
+			assert.Equal(t, planet.UpdatedAt.Truncate(time.Millisecond), planet.UpdatedAt, "service.Insert() updated_at should be cut to milliseconds")
+
+			got, err := s.GetByID(ctx, planet.ID.Hex())
+			if err != nil {
+				t.Fatalf("service.Insert() an error occurred retrieving a planet for test")
+			}
+			tt.wantPlanet.ID = planet.ID
+			tt.wantPlanet.Version = 1
+			tt.wantPlanet.UpdatedAt = planet.UpdatedAt
+			assert.Equal(t, tt.wantPlanet, got, "service.Insert() unexpected stored planet")
 		})
 	}
 }
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
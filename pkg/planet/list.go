@@ -0,0 +1,161 @@
+package planet
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type ListOptions struct {
+	Limit  int64
+	Cursor string
+	Query  Query
+	// AsOf lists the planets as they were at that time, rebuilt from their
+	// revisions. The zero time lists them as they are now.
+	AsOf time.Time
+}
+
+type Page struct {
+	Planets    []Planet
+	NextCursor string
+}
+
+// List returns the planets matching the query, ordered by its sort fields and
+// then by id. The cursor is opaque to callers and points to the last planet
+// of the previous page.
+func (s *Service) List(ctx context.Context, opts ListOptions) (Page, error) {
+	return s.list(ctx, false, opts)
+}
+
+func (s *Service) list(ctx context.Context, trashed bool, opts ListOptions) (Page, error) {
+	page := Page{Planets: []Planet{}}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	criteria := Criteria{Query: opts.Query, Trashed: trashed, Limit: limit + 1}
+	if opts.Cursor != "" {
+		values, after, err := decodeCursor(opts.Cursor, opts.Query)
+		if err != nil {
+			return page, err
+		}
+		criteria.After = &Position{Values: values, ID: after}
+	}
+
+	collect := func(p Planet) error {
+		page.Planets = append(page.Planets, p)
+		return nil
+	}
+	var err error
+	if opts.AsOf.IsZero() {
+		err = s.repo.List(ctx, criteria, collect)
+	} else {
+		err = s.listAt(ctx, opts.AsOf, criteria, collect)
+	}
+	if err != nil {
+		return page, err
+	}
+
+	if int64(len(page.Planets)) > limit {
+		page.Planets = page.Planets[:limit]
+		last := page.Planets[limit-1]
+		page.NextCursor = encodeCursor(opts.Query.sortValues(last), last.ID, opts.Query)
+	}
+
+	return page, nil
+}
+
+// listAt lists the planets matching the criteria as they were at the given
+// time. Revisions cannot be filtered by the repositories, so the planets of
+// that time are all read and then filtered and ordered here.
+func (s *Service) listAt(ctx context.Context, at time.Time, criteria Criteria, each func(Planet) error) error {
+	var planets []Planet
+	err := s.repo.ListAt(ctx, at, func(p Planet) error {
+		if criteria.matches(p) {
+			planets = append(planets, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range criteria.arrange(planets) {
+		if err := each(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cursorPosition is the position of the last planet of a page. It records the
+// sort it was taken from so it cannot be replayed against a different order.
+type cursorPosition struct {
+	ID     primitive.ObjectID `json:"id"`
+	Values []interface{}      `json:"v,omitempty"`
+	Sort   []SortField        `json:"s,omitempty"`
+}
+
+func encodeCursor(values []interface{}, id primitive.ObjectID, query Query) string {
+	raw, _ := json.Marshal(cursorPosition{ID: id, Values: values, Sort: query.Sort})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(cursor string, query Query) ([]interface{}, primitive.ObjectID, error) {
+	var position cursorPosition
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, position.ID, ErrInvalidCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&position); err != nil {
+		return nil, position.ID, ErrInvalidCursor
+	}
+	if len(position.Values) != len(query.Sort) || len(position.Sort) != len(query.Sort) {
+		return nil, position.ID, ErrInvalidCursor
+	}
+	for i := range query.Sort {
+		if position.Sort[i] != query.Sort[i] {
+			return nil, position.ID, ErrInvalidCursor
+		}
+	}
+
+	for i, v := range position.Values {
+		if number, ok := v.(json.Number); ok {
+			position.Values[i] = numberValue(number)
+		}
+	}
+
+	return position.Values, position.ID, nil
+}
+
+// numberValue keeps integers as int64 so large populations survive the trip
+// through the cursor without losing precision.
+func numberValue(number json.Number) interface{} {
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	f, _ := number.Float64()
+	return f
+}
@@ -0,0 +1,88 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_service_List(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	for _, name := range []string{"Mercury", "Venus", "Earth"} {
+		if _, err := s.Insert(ctx, Planet{Name: name}); err != nil {
+			t.Fatalf("service.List() an error occurred inserting a planet for test")
+		}
+	}
+
+	first, err := s.List(ctx, ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("service.List() unexpected error %v", err)
+	}
+	assert.Len(t, first.Planets, 2, "service.List() unexpected first page size")
+	assert.NotEmpty(t, first.NextCursor, "service.List() expected a next cursor")
+
+	second, err := s.List(ctx, ListOptions{Limit: 2, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("service.List() unexpected error %v", err)
+	}
+	assert.Len(t, second.Planets, 1, "service.List() unexpected second page size")
+	assert.Equal(t, "Earth", second.Planets[0].Name, "service.List() unexpected planet name")
+	assert.Empty(t, second.NextCursor, "service.List() expected no next cursor on last page")
+
+	if _, err := s.List(ctx, ListOptions{Cursor: "not-a-cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("service.List() errorType = %v, wantErrorType %v", err, ErrInvalidCursor)
+	}
+}
+
+func Test_service_List_filterAndSort(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	planets := []Planet{
+		{Name: "Tatooine", Climate: []string{"arid"}, Diameter: int64Ptr(10465), Population: int64Ptr(200000)},
+		{Name: "Geonosis", Climate: []string{"temperate", "arid"}, Diameter: int64Ptr(11370), Population: int64Ptr(100000000000)},
+		{Name: "Jakku", Climate: []string{"arid"}, Population: int64Ptr(2000000)},
+		{Name: "Ryloth", Climate: []string{"temperate", "arid", "subartic"}, Diameter: int64Ptr(10600), Population: int64Ptr(1500000000)},
+		{Name: "Hoth", Climate: []string{"frozen"}, Diameter: int64Ptr(7200)},
+	}
+	for _, p := range planets {
+		if _, err := s.Insert(ctx, p); err != nil {
+			t.Fatalf("service.List() an error occurred inserting a planet for test")
+		}
+	}
+
+	query := Query{
+		Filters: []Filter{
+			{Field: "climate", Operator: OpEq, Value: "arid"},
+			{Field: "population", Operator: OpGt, Value: int64(1000000)},
+		},
+		Sort: []SortField{{Field: "diameter", Descending: true}, {Field: "name"}},
+	}
+
+	var names []string
+	cursor := ""
+	for {
+		page, err := s.List(ctx, ListOptions{Limit: 1, Cursor: cursor, Query: query})
+		if err != nil {
+			t.Fatalf("service.List() unexpected error %v", err)
+		}
+		for _, p := range page.Planets {
+			names = append(names, p.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	assert.Equal(t, []string{"Geonosis", "Ryloth", "Jakku"}, names, "service.List() unexpected filtered and sorted planets")
+
+	otherSort := ListOptions{Cursor: cursor, Query: Query{Sort: []SortField{{Field: "name"}}}}
+	if _, err := s.List(ctx, otherSort); cursor != "" && !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("service.List() errorType = %v, wantErrorType %v", err, ErrInvalidCursor)
+	}
+}
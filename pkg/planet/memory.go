@@ -0,0 +1,363 @@
+package planet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository keeps planets in memory. It is safe for concurrent use and
+// behaves like MongoRepository, which makes it a fast stand-in for tests.
+// Only a page of planets, which may wait on each, is bounded by the
+// repository timeout set with WithTimeout, the other operations never wait.
+type MemoryRepository struct {
+	timeout   time.Duration
+	mu        sync.RWMutex
+	planets   map[primitive.ObjectID]Planet
+	revisions map[primitive.ObjectID][]Revision
+	outbox    []Event
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		planets:   map[primitive.ObjectID]Planet{},
+		revisions: map[primitive.ObjectID][]Revision{},
+	}
+}
+
+// WithTimeout bounds the pages of planets listed by the repository. A zero
+// timeout, the default, sets none.
+func (r *MemoryRepository) WithTimeout(timeout time.Duration) *MemoryRepository {
+	r.timeout = timeout
+	return r
+}
+
+func (r *MemoryRepository) FindByID(ctx context.Context, id primitive.ObjectID) (Planet, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	planet, ok := r.planets[id]
+	if !ok {
+		return Planet{}, ErrPlanetNotFound
+	}
+
+	return clonePlanet(planet), nil
+}
+
+func (r *MemoryRepository) FindByNames(ctx context.Context, names []string) ([]Planet, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[strings.ToLower(name)] = true
+	}
+
+	planets := []Planet{}
+	for _, p := range r.planets {
+		if wanted[strings.ToLower(p.Name)] {
+			planets = append(planets, clonePlanet(p))
+		}
+	}
+
+	return planets, nil
+}
+
+func (r *MemoryRepository) Insert(ctx context.Context, planets []Planet, ordered bool) ([]error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	errs := make([]error, len(planets))
+	for i, p := range planets {
+		if err := r.nameConflict(p.Name, p.ID); err != nil {
+			errs[i] = err
+			if ordered {
+				for j := i + 1; j < len(errs); j++ {
+					errs[j] = ErrBatchAborted
+				}
+				break
+			}
+			continue
+		}
+		r.planets[p.ID] = clonePlanet(p)
+		r.record(newRevision(ctx, nil, p))
+	}
+
+	return errs, nil
+}
+
+func (r *MemoryRepository) Update(ctx context.Context, id primitive.ObjectID, change Change) (Planet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	planet, ok := r.planets[id]
+	if !ok || (planet.DeletedAt != nil) != change.Trashed {
+		return Planet{}, ErrPlanetNotFound
+	}
+	if change.Version > 0 && planet.Version != change.Version {
+		return Planet{}, ErrVersionConflict
+	}
+
+	before := planet
+	planet = clonePlanet(planet)
+	for field, value := range change.Set {
+		planet.setField(field, value)
+	}
+	if err := r.nameConflict(planet.Name, id); err != nil {
+		return Planet{}, err
+	}
+	planet.Version++
+	planet.UpdatedAt = change.At
+
+	r.planets[id] = planet
+	r.record(newRevision(ctx, &before, planet))
+	return clonePlanet(planet), nil
+}
+
+// List copies the matching planets before calling each, so each is free to
+// use the repository.
+func (r *MemoryRepository) List(ctx context.Context, criteria Criteria, each func(Planet) error) (err error) {
+	timeout := time.Duration(0)
+	if criteria.Limit > 0 {
+		timeout = r.timeout
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	var planets []Planet
+	for _, p := range r.planets {
+		if criteria.matches(p) {
+			planets = append(planets, clonePlanet(p))
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, p := range criteria.arrange(planets) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := each(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) Delete(ctx context.Context, trashedBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for id, p := range r.planets {
+		if p.DeletedAt != nil && p.DeletedAt.Before(trashedBefore) {
+			delete(r.planets, id)
+			delete(r.revisions, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (r *MemoryRepository) Revisions(ctx context.Context, id primitive.ObjectID) ([]Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := make([]Revision, 0, len(r.revisions[id]))
+	for _, revision := range r.revisions[id] {
+		revisions = append(revisions, cloneRevision(revision))
+	}
+
+	return revisions, nil
+}
+
+func (r *MemoryRepository) Revision(ctx context.Context, id primitive.ObjectID, version int64) (Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, revision := range r.revisions[id] {
+		if revision.Version == version {
+			return cloneRevision(revision), nil
+		}
+	}
+
+	return Revision{}, ErrRevisionNotFound
+}
+
+// ListAt copies the planets before calling each, like List.
+func (r *MemoryRepository) ListAt(ctx context.Context, at time.Time, each func(Planet) error) error {
+	r.mu.RLock()
+	var planets []Planet
+	for _, revisions := range r.revisions {
+		for i := len(revisions) - 1; i >= 0; i-- {
+			if !revisions[i].Timestamp.After(at) {
+				planets = append(planets, clonePlanet(revisions[i].After))
+				break
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, p := range planets {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := each(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) PendingEvents(ctx context.Context, limit int64) ([]Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []Event{}
+	for _, event := range r.outbox {
+		if limit > 0 && int64(len(events)) == limit {
+			break
+		}
+		event.Planet = clonePlanet(event.Planet)
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (r *MemoryRepository) AckEvent(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, event := range r.outbox {
+		if event.ID == id {
+			r.outbox = append(r.outbox[:i], r.outbox[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// record keeps a revision and raises its event, both cloned. The caller must
+// hold the lock.
+func (r *MemoryRepository) record(revision Revision) {
+	revision = cloneRevision(revision)
+	r.revisions[revision.PlanetID] = append(r.revisions[revision.PlanetID], revision)
+
+	event := newEvent(revision)
+	event.Planet = clonePlanet(event.Planet)
+	r.outbox = append(r.outbox, event)
+}
+
+// Load adds the planets of a JSON array, in the form written by Snapshot. It
+// is meant for fixtures, so ids, versions and updated_at can be left out and
+// are filled in. Each planet starts a new history with a baseline revision
+// dated at its updated_at, without raising an event.
+func (r *MemoryRepository) Load(reader io.Reader) error {
+	var planets []Planet
+	if err := json.NewDecoder(reader).Decode(&planets); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	for i, p := range planets {
+		if p.ID.IsZero() {
+			p.ID = primitive.NewObjectID()
+		}
+		if p.Version == 0 {
+			p.Version = 1
+		}
+		if p.UpdatedAt.IsZero() {
+			p.UpdatedAt = now
+		}
+		if p.FilmCount == 0 {
+			p.FilmCount = len(p.Films)
+		}
+		if err := r.nameConflict(p.Name, p.ID); err != nil {
+			return fmt.Errorf("planet %d: %w", i, err)
+		}
+		r.planets[p.ID] = clonePlanet(p)
+		if len(r.revisions[p.ID]) == 0 {
+			r.revisions[p.ID] = []Revision{cloneRevision(newRevision(context.Background(), nil, p))}
+		}
+	}
+
+	return nil
+}
+
+// Snapshot writes every planet, the ones in the trash included, as a JSON
+// array ordered by id. Revisions and pending events are not part of it, so a
+// planet loaded back starts a new history.
+func (r *MemoryRepository) Snapshot(writer io.Writer) error {
+	r.mu.RLock()
+	planets := make([]Planet, 0, len(r.planets))
+	for _, p := range r.planets {
+		planets = append(planets, p)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(planets, func(i, j int) bool {
+		return planets[i].ID.Hex() < planets[j].ID.Hex()
+	})
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(planets)
+}
+
+// nameConflict returns an AlreadyExistsError when a planet other than id
+// holds the name, ignoring case. The caller must hold the lock.
+func (r *MemoryRepository) nameConflict(name string, id primitive.ObjectID) error {
+	for _, p := range r.planets {
+		if p.ID != id && strings.EqualFold(p.Name, name) {
+			return &AlreadyExistsError{Name: name, ExistingID: p.ID}
+		}
+	}
+	return nil
+}
+
+// clonePlanet copies the slices and pointers of a planet, so callers never
+// share memory with the stored one.
+func clonePlanet(p Planet) Planet {
+	p.RotationPeriod = int64Pointer(int64Value(p.RotationPeriod))
+	p.OrbitalPeriod = int64Pointer(int64Value(p.OrbitalPeriod))
+	p.Diameter = int64Pointer(int64Value(p.Diameter))
+	p.Population = int64Pointer(int64Value(p.Population))
+	p.Gravity = float64Pointer(float64Value(p.Gravity))
+	p.SurfaceWater = float64Pointer(float64Value(p.SurfaceWater))
+	p.Climate = copyStrings(p.Climate)
+	p.Terrain = copyStrings(p.Terrain)
+	p.Films = copyStrings(p.Films)
+	if p.DeletedAt != nil {
+		deletedAt := *p.DeletedAt
+		p.DeletedAt = &deletedAt
+	}
+	return p
+}
+
+func cloneRevision(revision Revision) Revision {
+	if revision.Before != nil {
+		before := clonePlanet(*revision.Before)
+		revision.Before = &before
+	}
+	revision.After = clonePlanet(revision.After)
+	return revision
+}
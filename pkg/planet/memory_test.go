@@ -0,0 +1,117 @@
+package planet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MemoryRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T, timeout time.Duration) Repository {
+		return NewMemoryRepository().WithTimeout(timeout)
+	})
+}
+
+func Test_MemoryRepository_concurrentWrites(t *testing.T) {
+	r := NewMemoryRepository()
+	ctx := context.Background()
+	hoth := repositoryPlanet("Hoth")
+	insertPlanets(t, r, hoth)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			r.Insert(ctx, []Planet{repositoryPlanet(fmt.Sprintf("Planet %d", i))}, true)
+		}(i)
+		go func() {
+			defer wg.Done()
+			r.Update(ctx, hoth.ID, Change{At: writeTime(), Set: map[string]interface{}{"climate": []string{"frozen"}}})
+		}()
+	}
+	wg.Wait()
+
+	got, err := r.FindByID(ctx, hoth.ID)
+	if err != nil {
+		t.Fatalf("repository.FindByID() unexpected error %v", err)
+	}
+	assert.Equal(t, int64(51), got.Version, "repository.Update() every concurrent write should bump the version")
+	assert.Len(t, listNames(t, r, Criteria{}), 51, "repository.Insert() every concurrent insert should be stored")
+}
+
+func Test_MemoryRepository_LoadAndSnapshot(t *testing.T) {
+	ctx := context.Background()
+	fixture := `[
+		{"name": "Tatooine", "climate": ["arid"], "diameter": 10465, "films": ["https://swapi.dev/api/films/1/"]},
+		{"name": "Hoth", "gravity": 1.1, "deleted_at": "2021-01-01T00:00:00Z"}
+	]`
+
+	r := NewMemoryRepository()
+	if err := r.Load(strings.NewReader(fixture)); err != nil {
+		t.Fatalf("repository.Load() unexpected error %v", err)
+	}
+
+	loaded, _ := r.FindByNames(ctx, []string{"Tatooine"})
+	if assert.Len(t, loaded, 1, "repository.Load() unexpected planets") {
+		assert.False(t, loaded[0].ID.IsZero(), "repository.Load() a missing id should be filled in")
+		assert.Equal(t, int64(1), loaded[0].Version, "repository.Load() a missing version should be filled in")
+		assert.Equal(t, 1, loaded[0].FilmCount, "repository.Load() a missing film count should be filled in")
+	}
+	assert.Equal(t, []string{"Hoth"}, listNames(t, r, Criteria{Trashed: true}), "repository.Load() unexpected trash")
+
+	var snapshot bytes.Buffer
+	if err := r.Snapshot(&snapshot); err != nil {
+		t.Fatalf("repository.Snapshot() unexpected error %v", err)
+	}
+	restored := NewMemoryRepository()
+	if err := restored.Load(&snapshot); err != nil {
+		t.Fatalf("repository.Load() unexpected error loading a snapshot %v", err)
+	}
+	got, err := restored.FindByID(ctx, loaded[0].ID)
+	if err != nil {
+		t.Fatalf("repository.FindByID() unexpected error %v", err)
+	}
+	assert.Equal(t, loaded[0].Climate, got.Climate, "repository.Snapshot() unexpected climate")
+	assert.Equal(t, loaded[0].Diameter, got.Diameter, "repository.Snapshot() unexpected diameter")
+	assert.True(t, loaded[0].UpdatedAt.Equal(got.UpdatedAt), "repository.Snapshot() unexpected updated_at")
+	assert.Equal(t, []string{"Hoth"}, listNames(t, restored, Criteria{Trashed: true}), "repository.Snapshot() unexpected trash")
+
+	err = NewMemoryRepository().Load(strings.NewReader(`[{"name": "Naboo"}, {"name": "NABOO"}]`))
+	assert.True(t, errors.Is(err, ErrPlanetAlreadyExists), "repository.Load() errorType = %v, wantErrorType %v", err, ErrPlanetAlreadyExists)
+}
+
+func Test_MemoryRepository_Load_baseline(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemoryRepository()
+	if err := r.Load(strings.NewReader(`[{"name": "Tatooine", "version": 3, "updated_at": "2021-06-01T00:00:00Z"}]`)); err != nil {
+		t.Fatalf("repository.Load() unexpected error %v", err)
+	}
+	loaded, _ := r.FindByNames(ctx, []string{"Tatooine"})
+
+	revisions, err := r.Revisions(ctx, loaded[0].ID)
+	if err != nil {
+		t.Fatalf("repository.Revisions() unexpected error %v", err)
+	}
+	if assert.Len(t, revisions, 1, "repository.Load() a loaded planet should get a baseline revision") {
+		assert.Equal(t, int64(3), revisions[0].Version, "repository.Load() unexpected baseline version")
+		assert.Equal(t, loaded[0].Name, revisions[0].After.Name, "repository.Load() unexpected baseline planet")
+	}
+	events, _ := r.PendingEvents(ctx, 10)
+	assert.Empty(t, events, "repository.Load() loading should not raise events")
+
+	s := NewService(r)
+	if _, err := s.RevisionAt(ctx, loaded[0].ID.Hex(), time.Now()); err != nil {
+		t.Errorf("service.RevisionAt() a loaded planet should be found now, got %v", err)
+	}
+	if _, err := s.RevisionAt(ctx, loaded[0].ID.Hex(), time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)); !errors.Is(err, ErrPlanetNotFound) {
+		t.Errorf("service.RevisionAt() errorType = %v, wantErrorType %v before its baseline", err, ErrPlanetNotFound)
+	}
+}
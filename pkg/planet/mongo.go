@@ -0,0 +1,626 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// listBatchSize is how many planets a listing cursor fetches per round trip.
+const listBatchSize = 500
+
+// changeStreamHistoryLost and invalidResumeToken are the codes of the MongoDB
+// errors raised when a change stream cannot resume after the token given.
+const (
+	changeStreamHistoryLost = 286
+	invalidResumeToken      = 260
+)
+
+// nameCollation compares names ignoring case, so "Tatooine" and "tatooine"
+// are the same planet.
+var nameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// nextVersion bumps the version of a planet. Every write that changes a
+// planet must include it so stale writers can be detected.
+var nextVersion = bson.M{"version": 1}
+
+// MongoRepository stores planets in a MongoDB collection, and their
+// revisions and outbox in companion collections named after it with a
+// "_revisions" and an "_outbox" suffix. Changes are written in transactions,
+// so MongoDB must run as a replica set. Every operation is given the timeout
+// to complete, and every query the same maxTimeMS, but the full listings,
+// which are streamed for as long as their reader takes.
+type MongoRepository struct {
+	db        *driver.Collection
+	revisions *driver.Collection
+	outbox    *driver.Collection
+	timeout   time.Duration
+}
+
+func NewMongoRepository(db *driver.Collection, timeout time.Duration) *MongoRepository {
+	return &MongoRepository{
+		db:        db,
+		revisions: db.Database().Collection(db.Name() + "_revisions"),
+		outbox:    db.Database().Collection(db.Name() + "_outbox"),
+		timeout:   timeout,
+	}
+}
+
+func (r *MongoRepository) FindByID(ctx context.Context, id primitive.ObjectID) (Planet, error) {
+	var planet Planet
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	err := r.db.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetMaxTime(r.timeout)).Decode(&planet)
+	if errors.Is(err, driver.ErrNoDocuments) {
+		return planet, ErrPlanetNotFound
+	}
+
+	return planet, timedOut(ctx, err)
+}
+
+func (r *MongoRepository) FindByNames(ctx context.Context, names []string) ([]Planet, error) {
+	planets := []Planet{}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	findOptions := options.Find().SetCollation(nameCollation).SetMaxTime(r.timeout)
+	cursor, err := r.db.Find(ctx, bson.M{"name": bson.M{"$in": names}}, findOptions)
+	if err != nil {
+		return nil, timedOut(ctx, err)
+	}
+	if err := cursor.All(ctx, &planets); err != nil {
+		return nil, timedOut(ctx, err)
+	}
+
+	return planets, nil
+}
+
+// Insert writes the planets, their revisions and their events in a single
+// transaction. When a name is taken the whole transaction is rolled back, so
+// the planets are then written one transaction each to tell which failed.
+// The planets written before another failure stay written, so the failure is
+// reported for the planet that hit it and the ones after it are left out.
+func (r *MongoRepository) Insert(ctx context.Context, planets []Planet, ordered bool) ([]error, error) {
+	errs := make([]error, len(planets))
+	if len(planets) == 0 {
+		return errs, nil
+	}
+
+	err := r.insert(ctx, planets)
+	if err == nil {
+		return errs, nil
+	}
+	if !driver.IsDuplicateKeyError(err) {
+		return nil, timedOut(ctx, err)
+	}
+
+	for i, p := range planets {
+		err := r.insert(ctx, []Planet{p})
+		if err == nil {
+			continue
+		}
+		if !driver.IsDuplicateKeyError(err) {
+			errs[i] = timedOut(ctx, err)
+			for j := i + 1; j < len(errs); j++ {
+				errs[j] = errs[i]
+				if ordered {
+					errs[j] = ErrBatchAborted
+				}
+			}
+			break
+		}
+
+		errs[i] = r.nameConflict(ctx, err, p.Name)
+		if ordered {
+			for j := i + 1; j < len(errs); j++ {
+				errs[j] = ErrBatchAborted
+			}
+			break
+		}
+	}
+
+	return errs, nil
+}
+
+func (r *MongoRepository) insert(ctx context.Context, planets []Planet) error {
+	documents := make([]interface{}, len(planets))
+	revisions := make([]interface{}, len(planets))
+	events := make([]interface{}, len(planets))
+	for i, p := range planets {
+		revision := newRevision(ctx, nil, p)
+		documents[i], revisions[i], events[i] = p, revision, newEvent(revision)
+	}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	return r.transaction(ctx, func(ctx driver.SessionContext) error {
+		if _, err := r.db.InsertMany(ctx, documents); err != nil {
+			return err
+		}
+		if _, err := r.revisions.InsertMany(ctx, revisions); err != nil {
+			return err
+		}
+		_, err := r.outbox.InsertMany(ctx, events)
+		return err
+	})
+}
+
+// Update reads the planet as it was before the change, to record the
+// revision, and works out the planet after it the way MongoDB applies it.
+// The change, its revision and its event are written in a transaction.
+func (r *MongoRepository) Update(ctx context.Context, id primitive.ObjectID, change Change) (Planet, error) {
+	var planet Planet
+
+	set, unset := bson.M{}, bson.M{}
+	for field, value := range change.Set {
+		if value != nil {
+			set[field] = value
+		} else {
+			unset[field] = ""
+		}
+	}
+
+	set["updated_at"] = change.At
+	update := bson.M{"$inc": nextVersion, "$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := atVersion(inTrash(bson.M{"_id": id}, change.Trashed), change.Version)
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.Before).SetMaxTime(r.timeout)
+	writeCtx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	err := r.transaction(writeCtx, func(ctx driver.SessionContext) error {
+		var before Planet
+		result := r.db.FindOneAndUpdate(ctx, filter, update, updateOptions)
+		if err := result.Decode(&before); err != nil {
+			return err
+		}
+
+		planet = clonePlanet(before)
+		for field, value := range change.Set {
+			planet.setField(field, value)
+		}
+		planet.Version++
+		planet.UpdatedAt = change.At
+
+		revision := newRevision(ctx, &before, planet)
+		if _, err := r.revisions.InsertOne(ctx, revision); err != nil {
+			return err
+		}
+		_, err := r.outbox.InsertOne(ctx, newEvent(revision))
+		return err
+	})
+
+	// The transaction is over, as a failed write aborts it, before the
+	// failure is looked into.
+	if errors.Is(err, driver.ErrNoDocuments) {
+		stored, err := r.FindByID(ctx, id)
+		return Planet{}, missedWrite(stored, err, change)
+	}
+	if err != nil {
+		name, _ := change.Set["name"].(string)
+		return Planet{}, timedOut(writeCtx, r.nameConflict(ctx, err, name))
+	}
+
+	return planet, nil
+}
+
+// List reads the planets from a cursor, so they are never held in memory as
+// a whole. Only a page is bounded by the repository timeout. MongoDB counts
+// the time of a cursor over all its batches, so a full listing, like an
+// export, is not limited at all, it would otherwise be cut off while
+// streaming.
+func (r *MongoRepository) List(ctx context.Context, criteria Criteria, each func(Planet) error) error {
+	timeout := time.Duration(0)
+	if criteria.Limit > 0 {
+		timeout = r.timeout
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	conditions := bson.A{inTrash(bson.M{}, criteria.Trashed), criteria.Query.filter()}
+	if criteria.After != nil {
+		conditions = append(conditions, criteria.Query.after(criteria.After.Values, criteria.After.ID))
+	}
+
+	findOptions := options.Find().
+		SetSort(criteria.Query.sort()).
+		SetBatchSize(listBatchSize)
+	if criteria.Limit > 0 {
+		findOptions.SetLimit(criteria.Limit).SetMaxTime(r.timeout)
+	}
+
+	cursor, err := r.db.Find(ctx, bson.M{"$and": conditions}, findOptions)
+	if err != nil {
+		return timedOut(ctx, err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var planet Planet
+		if err := cursor.Decode(&planet); err != nil {
+			return err
+		}
+		if err := each(planet); err != nil {
+			return err
+		}
+	}
+
+	return timedOut(ctx, cursor.Err())
+}
+
+// Delete removes the revisions of the planets it purges along with them.
+func (r *MongoRepository) Delete(ctx context.Context, trashedBefore time.Time) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	findOptions := options.Find().SetProjection(bson.M{"_id": 1}).SetMaxTime(r.timeout)
+	cursor, err := r.db.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": trashedBefore}}, findOptions)
+	if err != nil {
+		return 0, timedOut(ctx, err)
+	}
+	var purged []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &purged); err != nil {
+		return 0, timedOut(ctx, err)
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	ids := make(bson.A, len(purged))
+	for i, p := range purged {
+		ids[i] = p.ID
+	}
+
+	result, err := r.db.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, timedOut(ctx, err)
+	}
+	if _, err := r.revisions.DeleteMany(ctx, bson.M{"planet_id": bson.M{"$in": ids}}); err != nil {
+		return 0, timedOut(ctx, err)
+	}
+
+	return result.DeletedCount, nil
+}
+
+func (r *MongoRepository) Revisions(ctx context.Context, id primitive.ObjectID) ([]Revision, error) {
+	revisions := []Revision{}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	findOptions := options.Find().SetSort(bson.D{{Key: "version", Value: 1}}).SetMaxTime(r.timeout)
+	cursor, err := r.revisions.Find(ctx, bson.M{"planet_id": id}, findOptions)
+	if err != nil {
+		return nil, timedOut(ctx, err)
+	}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, timedOut(ctx, err)
+	}
+
+	return revisions, nil
+}
+
+func (r *MongoRepository) Revision(ctx context.Context, id primitive.ObjectID, version int64) (Revision, error) {
+	var revision Revision
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	findOptions := options.FindOne().SetMaxTime(r.timeout)
+	err := r.revisions.FindOne(ctx, bson.M{"planet_id": id, "version": version}, findOptions).Decode(&revision)
+	if errors.Is(err, driver.ErrNoDocuments) {
+		return revision, ErrRevisionNotFound
+	}
+
+	return revision, timedOut(ctx, err)
+}
+
+// ListAt picks the latest revision of every planet at the given time with an
+// aggregation, so only those revisions leave the database. The revisions are
+// walked in the order of the planet_version_latest index instead of sorted in
+// memory, which may still spill to disk on a long history. Like a full
+// listing, it is not limited in time, as it is streamed.
+func (r *MongoRepository) ListAt(ctx context.Context, at time.Time, each func(Planet) error) error {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"timestamp": bson.M{"$lte": at}}},
+		bson.M{"$sort": bson.D{{Key: "planet_id", Value: 1}, {Key: "version", Value: -1}}},
+		bson.M{"$group": bson.M{"_id": "$planet_id", "after": bson.M{"$first": "$after"}}},
+	}
+
+	aggregateOptions := options.Aggregate().SetBatchSize(listBatchSize).SetAllowDiskUse(true)
+	cursor, err := r.revisions.Aggregate(ctx, pipeline, aggregateOptions)
+	if err != nil {
+		return timedOut(ctx, err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var latest struct {
+			After Planet `bson:"after"`
+		}
+		if err := cursor.Decode(&latest); err != nil {
+			return err
+		}
+		if err := each(latest.After); err != nil {
+			return err
+		}
+	}
+
+	return timedOut(ctx, cursor.Err())
+}
+
+func (r *MongoRepository) PendingEvents(ctx context.Context, limit int64) ([]Event, error) {
+	events := []Event{}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit).SetMaxTime(r.timeout)
+	cursor, err := r.outbox.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, timedOut(ctx, err)
+	}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, timedOut(ctx, err)
+	}
+
+	return events, nil
+}
+
+func (r *MongoRepository) AckEvent(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	_, err := r.outbox.DeleteOne(ctx, bson.M{"_id": id})
+	return timedOut(ctx, err)
+}
+
+// Watch streams the changes of the planets collection as events, read from a
+// MongoDB change stream. Tokens are change stream resume tokens. When the one
+// given can no longer be resumed from, its history being lost or the token
+// invalid, the stream starts from now with a reset. Other failures are
+// returned.
+// Events carry the planet as it is when the change is read, and purges raise
+// none, like in the outbox. The stream is closed once ctx is done or the
+// change stream fails.
+func (r *MongoRepository) Watch(ctx context.Context, after string) (<-chan StreamedEvent, error) {
+	pipeline := driver.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}}}}},
+	}
+	streamOptions := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+
+	var stream *driver.ChangeStream
+	var err error
+	reset := false
+	if after != "" {
+		stream, err = r.db.Watch(ctx, pipeline, streamOptions.SetResumeAfter(bson.M{"_data": after}))
+		reset = resumeLost(err)
+	}
+	if after == "" || reset {
+		stream, err = r.db.Watch(ctx, pipeline, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan StreamedEvent)
+	go func() {
+		defer close(events)
+		defer stream.Close(context.Background())
+
+		if reset {
+			token, _ := stream.ResumeToken().Lookup("_data").StringValueOK()
+			select {
+			case events <- StreamedEvent{Token: token, Reset: true}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for stream.Next(ctx) {
+			var change struct {
+				OperationType     string  `bson:"operationType"`
+				FullDocument      *Planet `bson:"fullDocument"`
+				UpdateDescription struct {
+					UpdatedFields bson.M `bson:"updatedFields"`
+				} `bson:"updateDescription"`
+			}
+			// The planet is missing when it was purged before the change
+			// was read.
+			if err := stream.Decode(&change); err != nil || change.FullDocument == nil {
+				continue
+			}
+
+			eventType := PlanetUpdated
+			switch {
+			case change.OperationType == "insert":
+				eventType = PlanetCreated
+			case change.UpdateDescription.UpdatedFields["deleted_at"] != nil:
+				eventType = PlanetDeleted
+			}
+			token, _ := stream.ResumeToken().Lookup("_data").StringValueOK()
+			streamed := StreamedEvent{
+				Token: token,
+				Event: Event{
+					Type:       eventType,
+					PlanetID:   change.FullDocument.ID,
+					Version:    change.FullDocument.Version,
+					OccurredAt: change.FullDocument.UpdatedAt,
+					Planet:     *change.FullDocument,
+				},
+			}
+
+			select {
+			case events <- streamed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// resumeLost tells whether a change stream failed to resume because its
+// resume token can no longer be used.
+func resumeLost(err error) bool {
+	var serverErr driver.ServerError
+	return errors.As(err, &serverErr) &&
+		(serverErr.HasErrorCode(changeStreamHistoryLost) || serverErr.HasErrorCode(invalidResumeToken))
+}
+
+// transaction runs fn in a transaction, which MongoDB retries on transient
+// errors. The collections must exist beforehand, as EnsureIndexes makes sure.
+func (r *MongoRepository) transaction(ctx context.Context, fn func(ctx driver.SessionContext) error) error {
+	session, err := r.db.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx driver.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// EnsureIndexes creates the case-insensitive unique index on the planet name,
+// the index revisions are looked up by, and the one ListAt finds the latest
+// revisions with. Planets in the trash keep their
+// name reserved until they are purged. Creating them also creates the
+// collections, which transactions cannot do, the outbox being created apart.
+func (r *MongoRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.db.Indexes().CreateOne(ctx, driver.IndexModel{
+		Keys: bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().
+			SetName("name_unique").
+			SetUnique(true).
+			SetCollation(nameCollation),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.revisions.Indexes().CreateMany(ctx, []driver.IndexModel{
+		{
+			Keys: bson.D{{Key: "planet_id", Value: 1}, {Key: "version", Value: 1}},
+			Options: options.Index().
+				SetName("planet_version_unique").
+				SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "planet_id", Value: 1}, {Key: "version", Value: -1}, {Key: "timestamp", Value: 1}},
+			Options: options.Index().SetName("planet_version_latest"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = r.outbox.Database().CreateCollection(ctx, r.outbox.Name())
+	var commandErr driver.CommandError
+	if errors.As(err, &commandErr) && commandErr.Name == "NamespaceExists" {
+		return nil
+	}
+	return err
+}
+
+// EnsureVersions gives a first version to planets stored before versioning
+// was introduced, and a baseline revision dated at their updated_at to the
+// planets without any, like the ones stored before revisions were recorded,
+// so reads at a point in time find them.
+func (r *MongoRepository) EnsureVersions(ctx context.Context) error {
+	_, err := r.db.UpdateMany(ctx, bson.M{"version": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"version": int64(1)}})
+	if err != nil {
+		return err
+	}
+
+	// The lookup is unwound right away, so the revisions of a planet are
+	// streamed one by one instead of gathered in an array.
+	pipeline := driver.Pipeline{
+		{{Key: "$lookup", Value: bson.M{"from": r.revisions.Name(), "localField": "_id", "foreignField": "planet_id", "as": "revision"}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$revision", "preserveNullAndEmptyArrays": true}}},
+		{{Key: "$match", Value: bson.M{"revision": bson.M{"$exists": false}}}},
+		{{Key: "$project", Value: bson.M{"revision": 0}}},
+	}
+	cursor, err := r.db.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(listBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	baselines := make([]interface{}, 0, listBatchSize)
+	flush := func() error {
+		if len(baselines) == 0 {
+			return nil
+		}
+		// Another instance starting at the same time may have written some.
+		_, err := r.revisions.InsertMany(ctx, baselines, options.InsertMany().SetOrdered(false))
+		baselines = baselines[:0]
+		if err != nil && !driver.IsDuplicateKeyError(err) {
+			return err
+		}
+		return nil
+	}
+	for cursor.Next(ctx) {
+		var planet Planet
+		if err := cursor.Decode(&planet); err != nil {
+			return err
+		}
+		baselines = append(baselines, newRevision(ctx, nil, planet))
+		if len(baselines) == listBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// nameConflict turns a duplicate key error into an AlreadyExistsError that
+// points to the planet holding the name. Other errors are returned as is.
+func (r *MongoRepository) nameConflict(ctx context.Context, err error, name string) error {
+	if !driver.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	conflict := &AlreadyExistsError{Name: name}
+	var existing Planet
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	findOptions := options.FindOne().SetCollation(nameCollation).SetMaxTime(r.timeout)
+	if err := r.db.FindOne(ctx, bson.M{"name": name}, findOptions).Decode(&existing); err == nil {
+		conflict.ExistingID = existing.ID
+	}
+
+	return conflict
+}
+
+// withTimeout bounds an operation by the repository timeout.
+func (r *MongoRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, r.timeout)
+}
+
+// inTrash restricts a filter to planets in the trash, or to the ones out of
+// it.
+func inTrash(filter bson.M, trashed bool) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": trashed}
+	return filter
+}
+
+// atVersion restricts a filter to the given version of a planet. A zero
+// version means the caller doesn't hold a version and any one matches.
+func atVersion(filter bson.M, version int64) bson.M {
+	if version > 0 {
+		filter["version"] = version
+	}
+	return filter
+}
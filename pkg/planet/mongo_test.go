@@ -0,0 +1,129 @@
+package planet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"star-wars/pkg/testutils/docker"
+
+	"github.com/ory/dockertest/v3"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func Test_MongoRepository(t *testing.T) {
+	host := startMongo(t)
+
+	testRepository(t, func(t *testing.T, timeout time.Duration) Repository {
+		r := NewMongoRepository(mongoCollection(host, primitive.NewObjectID().Hex()), timeout)
+		if err := r.EnsureIndexes(context.Background()); err != nil {
+			t.Fatalf("repository.EnsureIndexes() unexpected error %v", err)
+		}
+		return r
+	})
+}
+
+func Test_MongoRepository_EnsureVersions(t *testing.T) {
+	host := startMongo(t)
+
+	mongo := mongoCollection(host, "planet")
+	r := NewMongoRepository(mongo, 2*time.Second)
+	ctx := context.Background()
+
+	id := primitive.NewObjectID()
+	if _, err := mongo.InsertOne(ctx, map[string]interface{}{"_id": id, "name": "Mars"}); err != nil {
+		t.Fatalf("repository.EnsureVersions() an error occurred inserting a planet for test")
+	}
+
+	if err := r.EnsureVersions(ctx); err != nil {
+		t.Fatalf("repository.EnsureVersions() unexpected error %v", err)
+	}
+
+	p, err := r.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("repository.EnsureVersions() an error occurred retrieving a planet for test")
+	}
+	assert.Equal(t, int64(1), p.Version, "repository.EnsureVersions() unexpected version")
+
+	// A second start must not record the baseline again.
+	if err := r.EnsureVersions(ctx); err != nil {
+		t.Fatalf("repository.EnsureVersions() unexpected error %v", err)
+	}
+	revisions, err := r.Revisions(ctx, id)
+	if err != nil {
+		t.Fatalf("repository.Revisions() unexpected error %v", err)
+	}
+	if assert.Len(t, revisions, 1, "repository.EnsureVersions() a planet without revisions should get a baseline") {
+		assert.Equal(t, int64(1), revisions[0].Version, "repository.EnsureVersions() unexpected baseline version")
+		assert.Equal(t, "Mars", revisions[0].After.Name, "repository.EnsureVersions() unexpected baseline planet")
+	}
+}
+
+func Test_resumeLost(t *testing.T) {
+	tests := []struct {
+		name     string
+		givenErr error
+		want     bool
+	}{
+		{
+			name:     "when the history of the token is lost then it should report it",
+			givenErr: driver.CommandError{Code: changeStreamHistoryLost, Message: "resume point may no longer be in the oplog"},
+			want:     true,
+		},
+		{
+			name:     "when the token is invalid then it should report it",
+			givenErr: driver.CommandError{Code: invalidResumeToken, Message: "invalid resume token"},
+			want:     true,
+		},
+		{
+			name:     "when the watch failed otherwise then it should not report it",
+			givenErr: driver.CommandError{Code: 13, Message: "not authorized"},
+		},
+		{
+			name:     "when the server could not be reached then it should not report it",
+			givenErr: context.DeadlineExceeded,
+		},
+		{
+			name: "when there is no error then it should not report it",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, resumeLost(tc.givenErr), "resumeLost() unexpected result")
+		})
+	}
+}
+
+// startMongo starts a MongoDB container for the test and returns its host.
+// The test is skipped in short mode or when Docker is not available.
+func startMongo(t *testing.T) string {
+	if testing.Short() {
+		t.Skip("skipping MongoDB test in short mode")
+	}
+	if pool, err := dockertest.NewPool(""); err != nil || pool.Client.Ping() != nil {
+		t.Skip("skipping MongoDB test, Docker is not available")
+	}
+
+	mongoServer := docker.NewMongo()
+	mongoServer.WithTestPort(t).
+		Start(t)
+	t.Cleanup(func() { mongoServer.Stop() })
+
+	return mongoServer.GetHost()
+}
+
+func mongoCollection(host, name string) *driver.Collection {
+	ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
+	defer cancel()
+	client, err := driver.Connect(ctx, options.Client().ApplyURI(host))
+
+	if err != nil {
+		log.Fatal("Error trying to connect to the database")
+	}
+
+	return client.Database("planet").Collection(name)
+}
@@ -0,0 +1,62 @@
+package planet
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PatchableFields are the attributes clients are allowed to change.
+var PatchableFields = []string{
+	"name",
+	"rotation_period",
+	"orbital_period",
+	"diameter",
+	"climate",
+	"gravity",
+	"terrain",
+	"surface_water",
+	"population",
+}
+
+// Patch changes only the given fields of a planet, taking their new values
+// from changes, and returns the updated planet. Fields left unknown in
+// changes are removed from the stored planet. Like Update, a version in
+// changes makes the patch conditional on it.
+func (s *Service) Patch(ctx context.Context, id string, changes Planet, fields []string) (Planet, error) {
+	var planet Planet
+
+	if len(fields) == 0 {
+		planet, err := s.GetByID(ctx, id)
+		if err == nil && changes.Version > 0 && planet.Version != changes.Version {
+			return planet, ErrVersionConflict
+		}
+		return planet, err
+	}
+
+	for _, field := range fields {
+		if !isPatchable(field) {
+			return planet, fmt.Errorf("field %q cannot be patched", field)
+		}
+	}
+
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	change := Change{
+		Version: changes.Version,
+		At:      writeTime(),
+		Set:     fieldChanges(changes, fields),
+	}
+	s.reenrich(ctx, objectID, &change)
+
+	return s.repo.Update(ctx, objectID, change)
+}
+
+func isPatchable(field string) bool {
+	for _, patchable := range PatchableFields {
+		if patchable == field {
+			return true
+		}
+	}
+	return false
+}
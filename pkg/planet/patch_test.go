@@ -0,0 +1,35 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_service_Patch(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	mars, err := s.Insert(ctx, Planet{Name: "Mars", Climate: []string{"frozen"}, Gravity: float64Ptr(0.38)})
+	if err != nil {
+		t.Fatalf("service.Patch() an error occurred inserting a planet for test")
+	}
+
+	changes := Planet{Name: "Mars", Diameter: int64Ptr(6779), Gravity: float64Ptr(0.38)}
+	patched, err := s.Patch(ctx, mars.ID.Hex(), changes, []string{"diameter", "climate"})
+	if err != nil {
+		t.Fatalf("service.Patch() unexpected error %v", err)
+	}
+	assert.Equal(t, int64Ptr(6779), patched.Diameter, "service.Patch() unexpected diameter")
+	assert.Nil(t, patched.Climate, "service.Patch() climate should have been removed")
+	assert.Equal(t, float64Ptr(0.38), patched.Gravity, "service.Patch() untouched gravity should be kept")
+
+	if _, err := s.Patch(ctx, "5f165e2e4de9b442e60b3905", changes, []string{"diameter"}); !errors.Is(err, ErrPlanetNotFound) {
+		t.Errorf("service.Patch() errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+	}
+	if _, err := s.Patch(ctx, mars.ID.Hex(), changes, []string{"film_count"}); err == nil {
+		t.Errorf("service.Patch() expected an error patching a read-only field")
+	}
+}
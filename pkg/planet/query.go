@@ -0,0 +1,344 @@
+package planet
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Operator string
+
+const (
+	OpEq  Operator = "eq"
+	OpNe  Operator = "ne"
+	OpGt  Operator = "gt"
+	OpGte Operator = "gte"
+	OpLt  Operator = "lt"
+	OpLte Operator = "lte"
+	OpIn  Operator = "in"
+)
+
+// Filter compares a planet attribute, named after its stored field, with a
+// value. Values are strings, int64 or float64, nil for unknown, or a slice of
+// those for OpIn. On list attributes such as climate, OpEq matches planets
+// whose list contains the value.
+type Filter struct {
+	Field    string
+	Operator Operator
+	Value    interface{}
+}
+
+type SortField struct {
+	Field      string `json:"f"`
+	Descending bool   `json:"d,omitempty"`
+}
+
+// Query narrows and orders a listing. Planets with the same sort values are
+// ordered by id, which keeps cursor pagination stable.
+type Query struct {
+	Filters []Filter
+	Sort    []SortField
+}
+
+func (q Query) filter() bson.M {
+	if len(q.Filters) == 0 {
+		return bson.M{}
+	}
+
+	and := make(bson.A, 0, len(q.Filters))
+	for _, f := range q.Filters {
+		and = append(and, bson.M{f.Field: bson.M{"$" + string(f.Operator): f.Value}})
+	}
+
+	return bson.M{"$and": and}
+}
+
+func (q Query) sort() bson.D {
+	sort := make(bson.D, 0, len(q.Sort)+1)
+	for _, f := range q.Sort {
+		direction := 1
+		if f.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: f.Field, Value: direction})
+	}
+
+	return append(sort, bson.E{Key: "_id", Value: 1})
+}
+
+// after matches the planets that come after the given position in the sort
+// order. Unknown values sort first in ascending order and last in descending
+// order, as MongoDB sorts null.
+func (q Query) after(values []interface{}, id primitive.ObjectID) bson.M {
+	or := bson.A{}
+	equal := bson.M{}
+
+	for i, f := range q.Sort {
+		if clause := strictlyAfter(f, values[i]); clause != nil {
+			or = append(or, merge(equal, clause))
+		}
+		equal = merge(equal, bson.M{f.Field: values[i]})
+	}
+	or = append(or, merge(equal, bson.M{"_id": bson.M{"$gt": id}}))
+
+	return bson.M{"$or": or}
+}
+
+func strictlyAfter(f SortField, value interface{}) bson.M {
+	switch {
+	case value == nil && f.Descending:
+		return nil
+	case value == nil:
+		return bson.M{f.Field: bson.M{"$ne": nil}}
+	case f.Descending:
+		return bson.M{"$or": bson.A{
+			bson.M{f.Field: bson.M{"$lt": value}},
+			bson.M{f.Field: nil},
+		}}
+	default:
+		return bson.M{f.Field: bson.M{"$gt": value}}
+	}
+}
+
+func merge(a, b bson.M) bson.M {
+	merged := make(bson.M, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
+
+// sortValues returns the values of the sorted attributes of a planet, in the
+// shape they are compared in the database.
+func (q Query) sortValues(p Planet) []interface{} {
+	values := make([]interface{}, 0, len(q.Sort))
+	for _, f := range q.Sort {
+		values = append(values, p.fieldValue(f.Field))
+	}
+	return values
+}
+
+func (p Planet) fieldValue(field string) interface{} {
+	switch field {
+	case "name":
+		return p.Name
+	case "rotation_period":
+		return int64Value(p.RotationPeriod)
+	case "orbital_period":
+		return int64Value(p.OrbitalPeriod)
+	case "diameter":
+		return int64Value(p.Diameter)
+	case "gravity":
+		return float64Value(p.Gravity)
+	case "surface_water":
+		return float64Value(p.SurfaceWater)
+	case "population":
+		return int64Value(p.Population)
+	case "climate":
+		return stringsValue(p.Climate)
+	case "terrain":
+		return stringsValue(p.Terrain)
+	case "film_count":
+		return int64(p.FilmCount)
+	case "films":
+		return stringsValue(p.Films)
+	case "enrichment":
+		if p.Enrichment == "" {
+			return nil
+		}
+		return p.Enrichment
+	case "deleted_at":
+		if p.DeletedAt == nil {
+			return nil
+		}
+		return *p.DeletedAt
+	}
+	return nil
+}
+
+func stringsValue(v []string) interface{} {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func int64Value(v *int64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func float64Value(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// matches tells whether a planet passes the filters of the query, comparing
+// values the way filter() has MongoDB compare them.
+func (q Query) matches(p Planet) bool {
+	for _, f := range q.Filters {
+		if !f.matches(p.fieldValue(f.Field)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Filter) matches(value interface{}) bool {
+	switch f.Operator {
+	case OpEq:
+		return containsEqual(value, f.Value)
+	case OpNe:
+		return !containsEqual(value, f.Value)
+	case OpIn:
+		values, _ := f.Value.([]interface{})
+		for _, v := range values {
+			if containsEqual(value, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if value == nil || f.Value == nil {
+		return value == nil && f.Value == nil && (f.Operator == OpGte || f.Operator == OpLte)
+	}
+	c, ok := compareValues(value, f.Value)
+	if !ok {
+		return false
+	}
+	switch f.Operator {
+	case OpGt:
+		return c > 0
+	case OpGte:
+		return c >= 0
+	case OpLt:
+		return c < 0
+	case OpLte:
+		return c <= 0
+	}
+	return false
+}
+
+// containsEqual compares a value with an expected one. A list matches when
+// one of its items does.
+func containsEqual(value, expected interface{}) bool {
+	if list, ok := value.([]string); ok && expected != nil {
+		for _, item := range list {
+			if item == expected {
+				return true
+			}
+		}
+		return false
+	}
+	if value == nil || expected == nil {
+		return value == nil && expected == nil
+	}
+	c, ok := compareValues(value, expected)
+	return ok && c == 0
+}
+
+// less tells whether planet a comes before planet b in the query order.
+func (q Query) less(a, b Planet) bool {
+	return q.comparePosition(q.sortValues(a), a.ID, Position{Values: q.sortValues(b), ID: b.ID}) < 0
+}
+
+// comparePosition compares sort values and an id with a position. Unknown
+// values sort first in ascending order and last in descending order, as
+// MongoDB sorts null.
+func (q Query) comparePosition(values []interface{}, id primitive.ObjectID, position Position) int {
+	for i, f := range q.Sort {
+		c := compareSortValues(values[i], position.Values[i])
+		if f.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return bytes.Compare(id[:], position.ID[:])
+}
+
+func compareSortValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := compareValues(a, b)
+	return c
+}
+
+// compareValues compares two values of the same kind, numbers being compared
+// by value whatever their type. It returns false when they are not
+// comparable.
+func compareValues(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	x, ok := toFloat64(a)
+	if !ok {
+		return 0, false
+	}
+	y, ok := toFloat64(b)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
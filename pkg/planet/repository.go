@@ -0,0 +1,195 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrTimeout is returned, wrapping the storage error, when the storage did
+// not answer in time.
+var ErrTimeout = errors.New("planet storage timed out")
+
+// Repository stores planets for the Service. Implementations report missing
+// planets with ErrPlanetNotFound, stale versions with ErrVersionConflict,
+// taken names with an *AlreadyExistsError and operations that ran out of
+// their own time with ErrTimeout, while an operation whose context ended
+// first returns the context error. Names are unique ignoring case, and
+// planets in the trash keep their name reserved until they are deleted.
+// Every insert and update of a planet is recorded as a Revision, along with
+// the Author found in the context, and raises an Event in the outbox of the
+// repository, atomically with the write.
+type Repository interface {
+	// FindByID returns a planet, whether it is in the trash or not.
+	FindByID(ctx context.Context, id primitive.ObjectID) (Planet, error)
+	// FindByNames returns the planets holding any of the names, ignoring
+	// case, whether they are in the trash or not.
+	FindByNames(ctx context.Context, names []string) ([]Planet, error)
+	// Insert stores new planets and returns one error per planet, nil for
+	// the inserted ones. An ordered insert stops at the first failure and
+	// reports the planets after it with ErrBatchAborted. The returned error
+	// is only set when the insert as a whole failed.
+	Insert(ctx context.Context, planets []Planet, ordered bool) ([]error, error)
+	// Update applies a change to a planet and returns the planet as stored
+	// after it.
+	Update(ctx context.Context, id primitive.ObjectID, change Change) (Planet, error)
+	// List calls each for the planets matching the criteria, in the query
+	// order. It stops at the first error returned by each.
+	List(ctx context.Context, criteria Criteria, each func(Planet) error) error
+	// Delete removes for good the planets moved to the trash before the
+	// given time, with their revisions, and returns how many were removed.
+	Delete(ctx context.Context, trashedBefore time.Time) (int64, error)
+	// Revisions returns the revisions of a planet, oldest first.
+	Revisions(ctx context.Context, id primitive.ObjectID) ([]Revision, error)
+	// Revision returns the revision that brought a planet to the given
+	// version, or ErrRevisionNotFound.
+	Revision(ctx context.Context, id primitive.ObjectID, version int64) (Revision, error)
+	// ListAt calls each for every planet as it was at the given time, taken
+	// from its latest revision at or before it, in no particular order.
+	// Planets in the trash at that time are included.
+	ListAt(ctx context.Context, at time.Time, each func(Planet) error) error
+	// PendingEvents returns up to limit events of the outbox, oldest first.
+	PendingEvents(ctx context.Context, limit int64) ([]Event, error)
+	// AckEvent removes a published event from the outbox.
+	AckEvent(ctx context.Context, id primitive.ObjectID) error
+}
+
+// Change is a write to the stored fields of a planet. It only applies when
+// the planet is at the expected version, zero matching any, and is in or out
+// of the trash as expected. Every change bumps the version of the planet and
+// stamps its updated_at with At.
+type Change struct {
+	Version int64
+	Trashed bool
+	// At is the time of the change, from writeTime.
+	At time.Time
+	// Set maps stored fields to their new value, in the shape returned by
+	// fieldValue. A nil value removes the field.
+	Set map[string]interface{}
+}
+
+// Criteria selects the planets to list.
+type Criteria struct {
+	Query Query
+	// Trashed lists the planets in the trash instead of the live ones.
+	Trashed bool
+	// After only lists the planets coming after a position in the query
+	// order.
+	After *Position
+	// Limit caps the number of planets listed, zero meaning no limit.
+	Limit int64
+}
+
+// matches tells whether the criteria select a planet, leaving the limit
+// aside.
+func (c Criteria) matches(p Planet) bool {
+	if (p.DeletedAt != nil) != c.Trashed || !c.Query.matches(p) {
+		return false
+	}
+	return c.After == nil || c.Query.comparePosition(c.Query.sortValues(p), p.ID, *c.After) > 0
+}
+
+// arrange puts planets selected by the criteria in the query order and cuts
+// them to the limit.
+func (c Criteria) arrange(planets []Planet) []Planet {
+	sort.Slice(planets, func(i, j int) bool {
+		return c.Query.less(planets[i], planets[j])
+	})
+	if c.Limit > 0 && int64(len(planets)) > c.Limit {
+		planets = planets[:c.Limit]
+	}
+	return planets
+}
+
+// Position is the place of a planet in the order of a query: its sort values
+// followed by its id.
+type Position struct {
+	Values []interface{}
+	ID     primitive.ObjectID
+}
+
+// missedWrite explains why a change matched no planet, given the planet as
+// stored and the error met reading it: either the planet is gone or it has
+// moved past the expected version.
+func missedWrite(stored Planet, err error, change Change) error {
+	if err != nil {
+		return err
+	}
+	if (stored.DeletedAt != nil) != change.Trashed || change.Version == 0 {
+		return ErrPlanetNotFound
+	}
+	return ErrVersionConflict
+}
+
+// fieldChanges returns the values of the given fields of a planet, ready to
+// be used as the Set of a Change.
+func fieldChanges(p Planet, fields []string) map[string]interface{} {
+	set := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		set[field] = p.fieldValue(field)
+	}
+	return set
+}
+
+// setField writes a value in the shape returned by fieldValue back to the
+// planet. A nil value leaves the field unknown.
+func (p *Planet) setField(field string, value interface{}) {
+	switch field {
+	case "name":
+		p.Name, _ = value.(string)
+	case "rotation_period":
+		p.RotationPeriod = int64Pointer(value)
+	case "orbital_period":
+		p.OrbitalPeriod = int64Pointer(value)
+	case "diameter":
+		p.Diameter = int64Pointer(value)
+	case "gravity":
+		p.Gravity = float64Pointer(value)
+	case "surface_water":
+		p.SurfaceWater = float64Pointer(value)
+	case "population":
+		p.Population = int64Pointer(value)
+	case "climate":
+		p.Climate = copyStrings(value)
+	case "terrain":
+		p.Terrain = copyStrings(value)
+	case "film_count":
+		count, _ := value.(int64)
+		p.FilmCount = int(count)
+	case "films":
+		p.Films = copyStrings(value)
+	case "enrichment":
+		p.Enrichment, _ = value.(string)
+	case "deleted_at":
+		if deletedAt, ok := value.(time.Time); ok {
+			p.DeletedAt = &deletedAt
+		} else {
+			p.DeletedAt = nil
+		}
+	}
+}
+
+func int64Pointer(value interface{}) *int64 {
+	if v, ok := value.(int64); ok {
+		return &v
+	}
+	return nil
+}
+
+func float64Pointer(value interface{}) *float64 {
+	if v, ok := value.(float64); ok {
+		return &v
+	}
+	return nil
+}
+
+func copyStrings(value interface{}) []string {
+	v, ok := value.([]string)
+	if !ok || v == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(v)), v...)
+}
@@ -0,0 +1,452 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testRepository is the conformance suite every Repository must pass.
+// newTimedRepository returns an empty repository bounded by the timeout.
+func testRepository(t *testing.T, newTimedRepository func(t *testing.T, timeout time.Duration) Repository) {
+	ctx := context.Background()
+	newRepository := func(t *testing.T) Repository {
+		return newTimedRepository(t, 2*time.Second)
+	}
+
+	t.Run("when a planet is inserted, then it should be found by id with every attribute", func(t *testing.T) {
+		r := newRepository(t)
+		tatooine := repositoryPlanet("Tatooine")
+		tatooine.RotationPeriod = int64Ptr(23)
+		tatooine.Gravity = float64Ptr(1.5)
+		tatooine.Climate = []string{"arid"}
+		tatooine.Population = int64Ptr(200000)
+		insertPlanets(t, r, tatooine)
+
+		got, err := r.FindByID(ctx, tatooine.ID)
+		if err != nil {
+			t.Fatalf("repository.FindByID() unexpected error %v", err)
+		}
+		assert.Equal(t, tatooine.Name, got.Name, "repository.FindByID() unexpected name")
+		assert.Equal(t, tatooine.RotationPeriod, got.RotationPeriod, "repository.FindByID() unexpected rotation period")
+		assert.Equal(t, tatooine.Gravity, got.Gravity, "repository.FindByID() unexpected gravity")
+		assert.Equal(t, tatooine.Climate, got.Climate, "repository.FindByID() unexpected climate")
+		assert.Equal(t, tatooine.Population, got.Population, "repository.FindByID() unexpected population")
+		assert.Nil(t, got.Diameter, "repository.FindByID() unknown diameter should stay unknown")
+		assert.Equal(t, int64(1), got.Version, "repository.FindByID() unexpected version")
+
+		if _, err := r.FindByID(ctx, primitive.NewObjectID()); !errors.Is(err, ErrPlanetNotFound) {
+			t.Errorf("repository.FindByID() errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+		}
+	})
+
+	t.Run("when a name is taken ignoring case, then it should report the planet holding it", func(t *testing.T) {
+		r := newRepository(t)
+		tatooine := repositoryPlanet("Tatooine")
+		insertPlanets(t, r, tatooine)
+
+		errs, err := r.Insert(ctx, []Planet{repositoryPlanet("TATOOINE"), repositoryPlanet("Hoth")}, false)
+		if err != nil {
+			t.Fatalf("repository.Insert() unexpected error %v", err)
+		}
+		var conflict *AlreadyExistsError
+		if !errors.As(errs[0], &conflict) {
+			t.Fatalf("repository.Insert() errorType = %v, wantErrorType %v", errs[0], ErrPlanetAlreadyExists)
+		}
+		assert.Equal(t, tatooine.ID, conflict.ExistingID, "repository.Insert() unexpected existing planet")
+		assert.Nil(t, errs[1], "repository.Insert() an unordered insert should go on after a failure")
+
+		errs, err = r.Insert(ctx, []Planet{repositoryPlanet("Naboo"), repositoryPlanet("hoth"), repositoryPlanet("Endor")}, true)
+		if err != nil {
+			t.Fatalf("repository.Insert() unexpected error %v", err)
+		}
+		assert.Nil(t, errs[0], "repository.Insert() unexpected error before the failure")
+		assert.True(t, errors.Is(errs[1], ErrPlanetAlreadyExists), "repository.Insert() unexpected error %v", errs[1])
+		assert.Equal(t, ErrBatchAborted, errs[2], "repository.Insert() an ordered insert should stop at the first failure")
+		endor, err := r.FindByNames(ctx, []string{"Endor"})
+		if err != nil {
+			t.Fatalf("repository.FindByNames() unexpected error %v", err)
+		}
+		assert.Empty(t, endor, "repository.Insert() an aborted planet should not be stored")
+	})
+
+	t.Run("when names are looked up, then it should find them ignoring case", func(t *testing.T) {
+		r := newRepository(t)
+		insertPlanets(t, r, repositoryPlanet("Tatooine"), repositoryPlanet("Hoth"), repositoryPlanet("Naboo"))
+
+		got, err := r.FindByNames(ctx, []string{"tatooine", "HOTH", "Kamino"})
+		if err != nil {
+			t.Fatalf("repository.FindByNames() unexpected error %v", err)
+		}
+		assert.ElementsMatch(t, []string{"Tatooine", "Hoth"}, planetNames(got), "repository.FindByNames() unexpected planets")
+	})
+
+	t.Run("when a planet is updated, then it should set and remove fields and bump the version", func(t *testing.T) {
+		r := newRepository(t)
+		hoth := repositoryPlanet("Hoth")
+		hoth.Diameter = int64Ptr(7200)
+		insertPlanets(t, r, hoth)
+		at := writeTime()
+
+		got, err := r.Update(ctx, hoth.ID, Change{At: at, Version: 1, Set: map[string]interface{}{
+			"climate":  []string{"frozen"},
+			"diameter": nil,
+		}})
+		if err != nil {
+			t.Fatalf("repository.Update() unexpected error %v", err)
+		}
+		assert.Equal(t, []string{"frozen"}, got.Climate, "repository.Update() unexpected climate")
+		assert.Nil(t, got.Diameter, "repository.Update() a nil value should remove the field")
+		assert.Equal(t, "Hoth", got.Name, "repository.Update() untouched fields should be kept")
+		assert.Equal(t, int64(2), got.Version, "repository.Update() unexpected version")
+		assert.Equal(t, at, got.UpdatedAt, "repository.Update() updated_at should be the time of the change")
+
+		stored, _ := r.FindByID(ctx, hoth.ID)
+		assert.Equal(t, got.Climate, stored.Climate, "repository.Update() the change should be stored")
+		assert.Equal(t, at, stored.UpdatedAt, "repository.Update() updated_at should read back as it was given")
+	})
+
+	t.Run("when an update does not hold, then it should explain why", func(t *testing.T) {
+		r := newRepository(t)
+		hoth, naboo := repositoryPlanet("Hoth"), repositoryPlanet("Naboo")
+		insertPlanets(t, r, hoth, naboo)
+
+		_, err := r.Update(ctx, hoth.ID, Change{At: writeTime(), Version: 2, Set: map[string]interface{}{"diameter": int64(7200)}})
+		assert.True(t, errors.Is(err, ErrVersionConflict), "repository.Update() stale version, got %v", err)
+		_, err = r.Update(ctx, primitive.NewObjectID(), Change{At: writeTime(), Version: 1})
+		assert.True(t, errors.Is(err, ErrPlanetNotFound), "repository.Update() missing planet, got %v", err)
+		_, err = r.Update(ctx, hoth.ID, Change{At: writeTime(), Trashed: true, Set: map[string]interface{}{"deleted_at": nil}})
+		assert.True(t, errors.Is(err, ErrPlanetNotFound), "repository.Update() planet out of the trash, got %v", err)
+
+		_, err = r.Update(ctx, hoth.ID, Change{At: writeTime(), Set: map[string]interface{}{"name": "NABOO"}})
+		var conflict *AlreadyExistsError
+		if assert.True(t, errors.As(err, &conflict), "repository.Update() taken name, got %v", err) {
+			assert.Equal(t, naboo.ID, conflict.ExistingID, "repository.Update() unexpected existing planet")
+		}
+
+		renamed, err := r.Update(ctx, hoth.ID, Change{At: writeTime(), Set: map[string]interface{}{"name": "HOTH"}})
+		if err != nil {
+			t.Fatalf("repository.Update() a planet should be renamed to its own name, got %v", err)
+		}
+		assert.Equal(t, "HOTH", renamed.Name, "repository.Update() unexpected name")
+	})
+
+	t.Run("when planets are listed, then it should filter and order them like MongoDB", func(t *testing.T) {
+		r := newRepository(t)
+		tatooine := repositoryPlanet("Tatooine")
+		tatooine.Climate = []string{"arid", "temperate"}
+		tatooine.Diameter = int64Ptr(10465)
+		hoth := repositoryPlanet("Hoth")
+		hoth.Climate = []string{"frozen"}
+		hoth.Diameter = int64Ptr(7200)
+		dagobah := repositoryPlanet("Dagobah")
+		dagobah.Climate = []string{"murky"}
+		dagobah.Diameter = int64Ptr(8900)
+		yavin := repositoryPlanet("Yavin IV")
+		yavin.Climate = []string{"temperate", "tropical"}
+		insertPlanets(t, r, tatooine, hoth, dagobah, yavin)
+
+		tests := []struct {
+			name     string
+			criteria Criteria
+			want     []string
+		}{
+			{
+				name:     "unordered planets follow their id",
+				criteria: Criteria{},
+				want:     []string{"Tatooine", "Hoth", "Dagobah", "Yavin IV"},
+			},
+			{
+				name:     "eq on a list matches planets containing the value",
+				criteria: Criteria{Query: Query{Filters: []Filter{{Field: "climate", Operator: OpEq, Value: "temperate"}}}},
+				want:     []string{"Tatooine", "Yavin IV"},
+			},
+			{
+				name: "comparisons skip unknown values",
+				criteria: Criteria{Query: Query{
+					Filters: []Filter{{Field: "diameter", Operator: OpGt, Value: int64(8000)}},
+					Sort:    []SortField{{Field: "diameter"}},
+				}},
+				want: []string{"Dagobah", "Tatooine"},
+			},
+			{
+				name:     "eq nil matches unknown values",
+				criteria: Criteria{Query: Query{Filters: []Filter{{Field: "diameter", Operator: OpEq, Value: nil}}}},
+				want:     []string{"Yavin IV"},
+			},
+			{
+				name:     "in matches any of the values",
+				criteria: Criteria{Query: Query{Filters: []Filter{{Field: "name", Operator: OpIn, Value: []interface{}{"Hoth", "Dagobah"}}}}},
+				want:     []string{"Hoth", "Dagobah"},
+			},
+			{
+				name:     "unknown values sort first in ascending order",
+				criteria: Criteria{Query: Query{Sort: []SortField{{Field: "diameter"}}}},
+				want:     []string{"Yavin IV", "Hoth", "Dagobah", "Tatooine"},
+			},
+			{
+				name:     "unknown values sort last in descending order",
+				criteria: Criteria{Query: Query{Sort: []SortField{{Field: "diameter", Descending: true}}}},
+				want:     []string{"Tatooine", "Dagobah", "Hoth", "Yavin IV"},
+			},
+			{
+				name: "after a position and limited",
+				criteria: Criteria{
+					Query: Query{Sort: []SortField{{Field: "diameter"}}},
+					After: &Position{Values: []interface{}{int64(7200)}, ID: hoth.ID},
+					Limit: 1,
+				},
+				want: []string{"Dagobah"},
+			},
+			{
+				name: "after an unknown value",
+				criteria: Criteria{
+					Query: Query{Sort: []SortField{{Field: "diameter", Descending: true}}},
+					After: &Position{Values: []interface{}{int64(7200)}, ID: hoth.ID},
+				},
+				want: []string{"Yavin IV"},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.want, listNames(t, r, tt.criteria), "repository.List() unexpected planets")
+			})
+		}
+	})
+
+	t.Run("when planets are in the trash, then they should be listed apart and deleted after the retention", func(t *testing.T) {
+		r := newRepository(t)
+		hoth, naboo, endor := repositoryPlanet("Hoth"), repositoryPlanet("Naboo"), repositoryPlanet("Endor")
+		insertPlanets(t, r, hoth, naboo, endor)
+
+		trashedAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+		if _, err := r.Update(ctx, hoth.ID, Change{At: writeTime(), Set: map[string]interface{}{"deleted_at": trashedAt}}); err != nil {
+			t.Fatalf("repository.Update() unexpected error %v", err)
+		}
+		if _, err := r.Update(ctx, naboo.ID, Change{At: writeTime(), Set: map[string]interface{}{"deleted_at": time.Now().UTC()}}); err != nil {
+			t.Fatalf("repository.Update() unexpected error %v", err)
+		}
+
+		assert.Equal(t, []string{"Endor"}, listNames(t, r, Criteria{}), "repository.List() unexpected live planets")
+		assert.Equal(t, []string{"Hoth", "Naboo"}, listNames(t, r, Criteria{Trashed: true}), "repository.List() unexpected trash")
+
+		errs, _ := r.Insert(ctx, []Planet{repositoryPlanet("hoth")}, true)
+		assert.True(t, errors.Is(errs[0], ErrPlanetAlreadyExists), "repository.Insert() a trashed planet should keep its name, got %v", errs[0])
+
+		deleted, err := r.Delete(ctx, time.Now().UTC().Add(-time.Minute))
+		if err != nil {
+			t.Fatalf("repository.Delete() unexpected error %v", err)
+		}
+		assert.Equal(t, int64(1), deleted, "repository.Delete() unexpected deleted count")
+		if _, err := r.FindByID(ctx, hoth.ID); !errors.Is(err, ErrPlanetNotFound) {
+			t.Errorf("repository.FindByID() errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+		}
+		assert.Equal(t, []string{"Naboo"}, listNames(t, r, Criteria{Trashed: true}), "repository.List() unexpected trash")
+	})
+
+	t.Run("when a planet changes, then it should record a revision of every change with its author", func(t *testing.T) {
+		r := newRepository(t)
+		hoth := repositoryPlanet("Hoth")
+		hoth.Diameter = int64Ptr(7200)
+		insertPlanets(t, r, hoth)
+
+		authored := WithAuthor(ctx, Author{RequestID: "request-1", Actor: "rebel-base"})
+		updated, err := r.Update(authored, hoth.ID, Change{At: writeTime(), Version: 1, Set: map[string]interface{}{
+			"climate":  []string{"frozen"},
+			"diameter": nil,
+		}})
+		if err != nil {
+			t.Fatalf("repository.Update() unexpected error %v", err)
+		}
+
+		revisions, err := r.Revisions(ctx, hoth.ID)
+		if err != nil {
+			t.Fatalf("repository.Revisions() unexpected error %v", err)
+		}
+		if !assert.Len(t, revisions, 2, "repository.Revisions() unexpected revisions") {
+			return
+		}
+		assert.Equal(t, int64(1), revisions[0].Version, "repository.Revisions() unexpected first version")
+		assert.Nil(t, revisions[0].Before, "repository.Revisions() the creation should have nothing before it")
+		assert.Equal(t, "Hoth", revisions[0].After.Name, "repository.Revisions() unexpected created planet")
+
+		got, err := r.Revision(ctx, hoth.ID, 2)
+		if err != nil {
+			t.Fatalf("repository.Revision() unexpected error %v", err)
+		}
+		assert.Equal(t, hoth.ID, got.PlanetID, "repository.Revision() unexpected planet")
+		assert.Equal(t, "request-1", got.RequestID, "repository.Revision() unexpected request id")
+		assert.Equal(t, "rebel-base", got.Actor, "repository.Revision() unexpected actor")
+		assert.True(t, got.Timestamp.Equal(updated.UpdatedAt), "repository.Revision() timestamp %v, want %v", got.Timestamp, updated.UpdatedAt)
+		if assert.NotNil(t, got.Before, "repository.Revision() unexpected missing before") {
+			assert.Equal(t, int64(1), got.Before.Version, "repository.Revision() unexpected version before")
+			assert.Equal(t, hoth.Diameter, got.Before.Diameter, "repository.Revision() unexpected diameter before")
+		}
+		assert.Equal(t, int64(2), got.After.Version, "repository.Revision() unexpected version after")
+		assert.Equal(t, []string{"frozen"}, got.After.Climate, "repository.Revision() unexpected climate after")
+		assert.Nil(t, got.After.Diameter, "repository.Revision() unexpected diameter after")
+
+		if _, err := r.Revision(ctx, hoth.ID, 3); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("repository.Revision() errorType = %v, wantErrorType %v", err, ErrRevisionNotFound)
+		}
+
+		trashedAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+		if _, err := r.Update(ctx, hoth.ID, Change{At: writeTime(), Set: map[string]interface{}{"deleted_at": trashedAt}}); err != nil {
+			t.Fatalf("repository.Update() unexpected error %v", err)
+		}
+		if _, err := r.Delete(ctx, time.Now().UTC()); err != nil {
+			t.Fatalf("repository.Delete() unexpected error %v", err)
+		}
+		revisions, err = r.Revisions(ctx, hoth.ID)
+		if err != nil {
+			t.Fatalf("repository.Revisions() unexpected error %v", err)
+		}
+		assert.Empty(t, revisions, "repository.Delete() the revisions of a purged planet should be removed")
+	})
+
+	t.Run("when planets are listed at a past time, then they should be as they were then", func(t *testing.T) {
+		r := newRepository(t)
+		hoth := repositoryPlanet("Hoth")
+		insertPlanets(t, r, hoth)
+		time.Sleep(5 * time.Millisecond)
+		before := time.Now().UTC()
+		time.Sleep(5 * time.Millisecond)
+
+		if _, err := r.Update(ctx, hoth.ID, Change{At: writeTime(), Set: map[string]interface{}{"name": "Echo Base"}}); err != nil {
+			t.Fatalf("repository.Update() unexpected error %v", err)
+		}
+		insertPlanets(t, r, repositoryPlanet("Naboo"))
+
+		listAt := func(at time.Time) []Planet {
+			var planets []Planet
+			err := r.ListAt(ctx, at, func(p Planet) error {
+				planets = append(planets, p)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("repository.ListAt() unexpected error %v", err)
+			}
+			return planets
+		}
+
+		then := listAt(before)
+		assert.Equal(t, []string{"Hoth"}, planetNames(then), "repository.ListAt() unexpected planets in the past")
+		if len(then) == 1 {
+			assert.Equal(t, int64(1), then[0].Version, "repository.ListAt() unexpected version in the past")
+		}
+		assert.ElementsMatch(t, []string{"Echo Base", "Naboo"}, planetNames(listAt(time.Now().UTC())), "repository.ListAt() unexpected planets now")
+		assert.Empty(t, listAt(before.Add(-time.Hour)), "repository.ListAt() no planet should exist before the first insert")
+	})
+
+	t.Run("when planets change, then their events should wait in the outbox until acknowledged", func(t *testing.T) {
+		r := newRepository(t)
+		hoth := repositoryPlanet("Hoth")
+		insertPlanets(t, r, hoth)
+		authored := WithAuthor(ctx, Author{RequestID: "request-1", Actor: "rebel-base"})
+		if _, err := r.Update(authored, hoth.ID, Change{At: writeTime(), Set: map[string]interface{}{"climate": []string{"frozen"}}}); err != nil {
+			t.Fatalf("repository.Update() unexpected error %v", err)
+		}
+		if _, err := r.Update(ctx, hoth.ID, Change{At: writeTime(), Set: map[string]interface{}{"deleted_at": time.Now().UTC()}}); err != nil {
+			t.Fatalf("repository.Update() unexpected error %v", err)
+		}
+		if errs, _ := r.Insert(ctx, []Planet{repositoryPlanet("HOTH")}, true); errs[0] == nil {
+			t.Fatalf("repository.Insert() a taken name should fail")
+		}
+		r.Update(ctx, hoth.ID, Change{At: writeTime(), Version: 1, Set: map[string]interface{}{"name": "Echo Base"}})
+
+		events, err := r.PendingEvents(ctx, 0)
+		if err != nil {
+			t.Fatalf("repository.PendingEvents() unexpected error %v", err)
+		}
+		var types []EventType
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		assert.Equal(t, []EventType{PlanetCreated, PlanetUpdated, PlanetDeleted}, types, "repository.PendingEvents() failed writes should raise no event")
+		if len(events) != 3 {
+			return
+		}
+		assert.Equal(t, hoth.ID, events[1].PlanetID, "repository.PendingEvents() unexpected planet")
+		assert.Equal(t, int64(2), events[1].Version, "repository.PendingEvents() unexpected version")
+		assert.Equal(t, []string{"frozen"}, events[1].Planet.Climate, "repository.PendingEvents() the event should carry the planet after the change")
+		assert.Equal(t, "request-1", events[1].RequestID, "repository.PendingEvents() unexpected request id")
+		assert.Equal(t, "rebel-base", events[1].Actor, "repository.PendingEvents() unexpected actor")
+
+		if err := r.AckEvent(ctx, events[0].ID); err != nil {
+			t.Fatalf("repository.AckEvent() unexpected error %v", err)
+		}
+		pending, err := r.PendingEvents(ctx, 1)
+		if err != nil {
+			t.Fatalf("repository.PendingEvents() unexpected error %v", err)
+		}
+		if assert.Len(t, pending, 1, "repository.PendingEvents() unexpected limit") {
+			assert.Equal(t, events[1].ID, pending[0].ID, "repository.AckEvent() the acknowledged event should leave the outbox")
+		}
+	})
+
+	t.Run("when an operation runs out of time, then it should report a timeout only when the repository gave up", func(t *testing.T) {
+		r := newTimedRepository(t, time.Nanosecond)
+		err := r.List(ctx, Criteria{Limit: 10}, func(Planet) error { return nil })
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("repository.List() errorType = %v, wantErrorType %v", err, ErrTimeout)
+		}
+		if err := r.List(ctx, Criteria{}, func(Planet) error { return nil }); err != nil {
+			t.Errorf("repository.List() a full listing should not be bounded, got %v", err)
+		}
+
+		unbounded := newTimedRepository(t, 0)
+		expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+		defer cancel()
+		err = unbounded.List(expired, Criteria{Limit: 10}, func(Planet) error { return nil })
+		if err == nil || errors.Is(err, ErrTimeout) {
+			t.Errorf("repository.List() the deadline of the caller should be returned as is, got %v", err)
+		}
+	})
+}
+
+// repositoryPlanet returns a planet ready to be inserted. Ids are increasing,
+// so planets without a sort are listed in creation order.
+func repositoryPlanet(name string) Planet {
+	return Planet{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Version:   1,
+		UpdatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+}
+
+func insertPlanets(t *testing.T, r Repository, planets ...Planet) {
+	errs, err := r.Insert(context.Background(), planets, true)
+	if err != nil {
+		t.Fatalf("repository.Insert() an error occurred inserting planets for test: %v", err)
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("repository.Insert() an error occurred inserting planets for test: %v", err)
+		}
+	}
+}
+
+func listNames(t *testing.T, r Repository, criteria Criteria) []string {
+	var planets []Planet
+	err := r.List(context.Background(), criteria, func(p Planet) error {
+		planets = append(planets, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("repository.List() unexpected error %v", err)
+	}
+	return planetNames(planets)
+}
+
+func planetNames(planets []Planet) []string {
+	names := []string{}
+	for _, p := range planets {
+		names = append(names, p.Name)
+	}
+	return names
+}
@@ -0,0 +1,116 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrRevisionNotFound = errors.New("revision not found")
+)
+
+// Revision records one change of a planet, numbered by the version it
+// produced. Before is nil for the change that created the planet.
+type Revision struct {
+	PlanetID  primitive.ObjectID `bson:"planet_id" json:"planet_id"`
+	Version   int64              `bson:"version" json:"version"`
+	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+	RequestID string             `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	Actor     string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	Before    *Planet            `bson:"before" json:"before"`
+	After     Planet             `bson:"after" json:"after"`
+}
+
+// Author tells who makes the changes of a context: the request they come
+// with and the application that sent it.
+type Author struct {
+	RequestID string
+	Actor     string
+}
+
+type authorKey struct{}
+
+// WithAuthor records who makes the changes done with ctx, so repositories
+// can store it in the revisions.
+func WithAuthor(ctx context.Context, author Author) context.Context {
+	return context.WithValue(ctx, authorKey{}, author)
+}
+
+func authorFrom(ctx context.Context) Author {
+	author, _ := ctx.Value(authorKey{}).(Author)
+	return author
+}
+
+// newRevision records the change from before to after, made with ctx.
+func newRevision(ctx context.Context, before *Planet, after Planet) Revision {
+	author := authorFrom(ctx)
+	return Revision{
+		PlanetID:  after.ID,
+		Version:   after.Version,
+		Timestamp: after.UpdatedAt,
+		RequestID: author.RequestID,
+		Actor:     author.Actor,
+		Before:    before,
+		After:     after,
+	}
+}
+
+// Revisions returns the changes of a planet, in the trash or not, oldest
+// first.
+func (s *Service) Revisions(ctx context.Context, id string) ([]Revision, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	if _, err := s.repo.FindByID(ctx, objectID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.Revisions(ctx, objectID)
+}
+
+// Revision returns the change of a planet that produced the given version.
+func (s *Service) Revision(ctx context.Context, id string, version int64) (Revision, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	if _, err := s.repo.FindByID(ctx, objectID); err != nil {
+		return Revision{}, err
+	}
+
+	return s.repo.Revision(ctx, objectID, version)
+}
+
+// RevisionAt returns the revision a planet was at the given time. The planet
+// is not found when it did not exist yet or was in the trash at that time.
+func (s *Service) RevisionAt(ctx context.Context, id string, at time.Time) (Revision, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	revisions, err := s.repo.Revisions(ctx, objectID)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if revisions[i].Timestamp.After(at) {
+			continue
+		}
+		if revisions[i].After.DeletedAt != nil {
+			break
+		}
+		return revisions[i], nil
+	}
+
+	return Revision{}, ErrPlanetNotFound
+}
+
+// Revert gives a planet back the attributes it had after the given revision,
+// as a new change. Like Update, a non zero expected version makes it
+// conditional on that version.
+func (s *Service) Revert(ctx context.Context, id string, version int64, expected int64) (Planet, error) {
+	revision, err := s.Revision(ctx, id, version)
+	if err != nil {
+		return Planet{}, err
+	}
+
+	reverted := revision.After
+	reverted.Version = expected
+	return s.Update(ctx, reverted)
+}
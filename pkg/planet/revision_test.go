@@ -0,0 +1,101 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Test_service_Revert(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	hoth, _ := s.Insert(ctx, Planet{Name: "Hoth", Climate: []string{"frozen"}, Diameter: int64Ptr(7200)})
+	hoth.Climate = []string{"temperate"}
+	hoth.Diameter = nil
+	if _, err := s.Update(ctx, hoth); err != nil {
+		t.Fatalf("service.Update() an error occurred updating a planet for test")
+	}
+
+	revisions, err := s.Revisions(ctx, hoth.ID.Hex())
+	if err != nil {
+		t.Fatalf("service.Revisions() unexpected error %v", err)
+	}
+	assert.Len(t, revisions, 2, "service.Revisions() unexpected revisions")
+
+	if _, err := s.Revert(ctx, hoth.ID.Hex(), 1, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("service.Revert() errorType = %v, wantErrorType %v", err, ErrVersionConflict)
+	}
+
+	reverted, err := s.Revert(ctx, hoth.ID.Hex(), 1, 2)
+	if err != nil {
+		t.Fatalf("service.Revert() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"frozen"}, reverted.Climate, "service.Revert() unexpected climate")
+	assert.Equal(t, int64Ptr(7200), reverted.Diameter, "service.Revert() unexpected diameter")
+	assert.Equal(t, int64(3), reverted.Version, "service.Revert() a revert should be a new version")
+
+	if _, err := s.Revert(ctx, hoth.ID.Hex(), 9, 0); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("service.Revert() errorType = %v, wantErrorType %v", err, ErrRevisionNotFound)
+	}
+	if _, err := s.Revisions(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, ErrPlanetNotFound) {
+		t.Errorf("service.Revisions() errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+	}
+}
+
+func Test_service_asOf(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	hoth, _ := s.Insert(ctx, Planet{Name: "Hoth"})
+	time.Sleep(2 * time.Millisecond)
+	created := time.Now().UTC()
+	time.Sleep(2 * time.Millisecond)
+
+	hoth.Name = "Echo Base"
+	if _, err := s.Update(ctx, hoth); err != nil {
+		t.Fatalf("service.Update() an error occurred updating a planet for test")
+	}
+	naboo, _ := s.Insert(ctx, Planet{Name: "Naboo"})
+	if err := s.Delete(ctx, naboo.ID.Hex()); err != nil {
+		t.Fatalf("service.Delete() an error occurred deleting a planet for test")
+	}
+	time.Sleep(2 * time.Millisecond)
+	now := time.Now().UTC()
+
+	revision, err := s.RevisionAt(ctx, hoth.ID.Hex(), created)
+	if err != nil {
+		t.Fatalf("service.RevisionAt() unexpected error %v", err)
+	}
+	assert.Equal(t, "Hoth", revision.After.Name, "service.RevisionAt() unexpected planet")
+
+	if _, err := s.RevisionAt(ctx, naboo.ID.Hex(), created); !errors.Is(err, ErrPlanetNotFound) {
+		t.Errorf("service.RevisionAt() planet not created yet, errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+	}
+	if _, err := s.RevisionAt(ctx, naboo.ID.Hex(), now); !errors.Is(err, ErrPlanetNotFound) {
+		t.Errorf("service.RevisionAt() planet in the trash, errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+	}
+
+	page, err := s.List(ctx, ListOptions{AsOf: created})
+	if err != nil {
+		t.Fatalf("service.List() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Hoth"}, planetNames(page.Planets), "service.List() unexpected planets in the past")
+
+	page, err = s.List(ctx, ListOptions{AsOf: now, Limit: 1})
+	if err != nil {
+		t.Fatalf("service.List() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Echo Base"}, planetNames(page.Planets), "service.List() unexpected planets now")
+	assert.Empty(t, page.NextCursor, "service.List() a planet in the trash should not be listed")
+
+	trash, err := s.ListTrash(ctx, ListOptions{AsOf: now})
+	if err != nil {
+		t.Fatalf("service.ListTrash() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Naboo"}, planetNames(trash.Planets), "service.ListTrash() unexpected trash")
+}
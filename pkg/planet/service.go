@@ -4,22 +4,67 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Planet follows the SWAPI planet schema. Nil attributes are the ones SWAPI
+// reports as "unknown" and are stored as null. The JSON form is the one of
+// MemoryRepository fixtures and snapshots.
 type Planet struct {
-	ID   primitive.ObjectID `bson:"_id"`
-	Name string             `bson:"name"`
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	Name           string             `bson:"name" json:"name"`
+	RotationPeriod *int64             `bson:"rotation_period" json:"rotation_period"` // standard hours
+	OrbitalPeriod  *int64             `bson:"orbital_period" json:"orbital_period"`   // standard days
+	Diameter       *int64             `bson:"diameter" json:"diameter"`               // kilometers
+	Climate        []string           `bson:"climate" json:"climate"`
+	Gravity        *float64           `bson:"gravity" json:"gravity"` // standard G
+	Terrain        []string           `bson:"terrain" json:"terrain"`
+	SurfaceWater   *float64           `bson:"surface_water" json:"surface_water"` // percentage of the surface
+	Population     *int64             `bson:"population" json:"population"`
+	FilmCount      int                `bson:"film_count" json:"film_count"`
+	Films          []string           `bson:"films" json:"films"`
+	Enrichment     string             `bson:"enrichment,omitempty" json:"enrichment,omitempty"`
+	Version        int64              `bson:"version" json:"version"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	DeletedAt      *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+}
+
+// Clone returns a copy of the planet sharing no memory with it, for callers
+// keeping planets around.
+func (p Planet) Clone() Planet {
+	return clonePlanet(p)
 }
 
 type Service struct {
-	db *mongo.Collection
-	timeout time.Duration
+	repo          Repository
+	films         FilmsResolver
+	enrichTimeout time.Duration
+	publisher     EventPublisher
+}
+
+// writeTime returns the time a write is stamped with. It is cut to the
+// milliseconds MongoDB keeps, so every backend reads back the updated_at
+// it was given, which is what conditional requests compare against.
+func writeTime() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
 }
 
-func NewService(db *mongo.Collection, timeout time.Duration) *Service {
+func NewService(repo Repository) *Service {
 	return &Service{
-		db: db,
-		timeout: timeout,
+		repo: repo,
 	}
 }
+
+// WithFilmsResolver enables film enrichment of the planets being inserted
+// and renamed.
+func (s *Service) WithFilmsResolver(films FilmsResolver) *Service {
+	s.films = films
+	return s
+}
+
+// WithEnrichmentTimeout bounds the film lookup made while a planet is
+// written, so a slow upstream delays the write by at most timeout. Planets
+// not enriched in time are left pending for RetryPendingEnrichment.
+func (s *Service) WithEnrichmentTimeout(timeout time.Duration) *Service {
+	s.enrichTimeout = timeout
+	return s
+}
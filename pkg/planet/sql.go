@@ -0,0 +1,778 @@
+package planet
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqlSchema creates the planets table, the table of their revisions and the
+// outbox of their events. Lists are stored as JSON arrays and times as Unix
+// nanoseconds, so they compare in order. The name index ignores case, like
+// the MongoDB one, but only for ASCII letters. Revisions and events keep the
+// planets as JSON documents, and events are numbered in the order raised.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS planets (
+		id              TEXT PRIMARY KEY,
+		name            TEXT NOT NULL,
+		rotation_period INTEGER,
+		orbital_period  INTEGER,
+		diameter        INTEGER,
+		climate         TEXT,
+		gravity         REAL,
+		terrain         TEXT,
+		surface_water   REAL,
+		population      INTEGER,
+		film_count      INTEGER NOT NULL DEFAULT 0,
+		films           TEXT,
+		enrichment      TEXT,
+		version         INTEGER NOT NULL,
+		updated_at      INTEGER NOT NULL,
+		deleted_at      INTEGER
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS planets_name_unique ON planets (name COLLATE NOCASE)`,
+	`CREATE TABLE IF NOT EXISTS planet_revisions (
+		planet_id  TEXT NOT NULL,
+		version    INTEGER NOT NULL,
+		timestamp  INTEGER NOT NULL,
+		request_id TEXT,
+		actor      TEXT,
+		before     TEXT,
+		after      TEXT NOT NULL,
+		PRIMARY KEY (planet_id, version)
+	)`,
+	`CREATE TABLE IF NOT EXISTS planet_outbox (
+		seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+		id          TEXT NOT NULL UNIQUE,
+		type        TEXT NOT NULL,
+		planet_id   TEXT NOT NULL,
+		version     INTEGER NOT NULL,
+		occurred_at INTEGER NOT NULL,
+		request_id  TEXT,
+		actor       TEXT,
+		planet      TEXT NOT NULL
+	)`,
+}
+
+// sqlRevisionColumns are the revision columns, in the order scanRevision
+// reads them.
+const sqlRevisionColumns = "planet_id, version, timestamp, request_id, actor, before, after"
+
+// sqlEventColumns are the outbox columns, in the order PendingEvents reads
+// them.
+const sqlEventColumns = "id, type, planet_id, version, occurred_at, request_id, actor, planet"
+
+// sqlColumns are the planet columns, in the order scanPlanet reads them.
+const sqlColumns = "id, name, rotation_period, orbital_period, diameter, climate, gravity, terrain, surface_water, population, film_count, films, enrichment, version, updated_at, deleted_at"
+
+// sqlFields are the stored fields a change, a filter or a sort can name.
+// Lists are the ones held as JSON arrays.
+var sqlFields = map[string]bool{
+	"name":            false,
+	"rotation_period": false,
+	"orbital_period":  false,
+	"diameter":        false,
+	"climate":         true,
+	"gravity":         false,
+	"terrain":         true,
+	"surface_water":   false,
+	"population":      false,
+	"film_count":      false,
+	"films":           true,
+	"enrichment":      false,
+	"deleted_at":      false,
+}
+
+// SQLRepository stores planets in a SQLite database through database/sql.
+// Updates read the planet before writing it in the same transaction, so the
+// database should be opened with _txlock=immediate to keep concurrent
+// updates from deadlocking.
+// Every operation but a full listing is bounded by the repository timeout,
+// set with WithTimeout. A full listing, like an export, is streamed for as
+// long as it takes.
+type SQLRepository struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewSQLRepository(db *sql.DB) *SQLRepository {
+	return &SQLRepository{
+		db: db,
+	}
+}
+
+// WithTimeout bounds the operations of the repository. A zero timeout, the
+// default, sets none.
+func (r *SQLRepository) WithTimeout(timeout time.Duration) *SQLRepository {
+	r.timeout = timeout
+	return r
+}
+
+// EnsureSchema creates the planets and revisions tables and their indexes
+// when missing.
+func (r *SQLRepository) EnsureSchema(ctx context.Context) error {
+	for _, statement := range sqlSchema {
+		if _, err := r.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) FindByID(ctx context.Context, id primitive.ObjectID) (_ Planet, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	row := r.db.QueryRowContext(ctx, "SELECT "+sqlColumns+" FROM planets WHERE id = ?", id.Hex())
+	planet, err := scanPlanet(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return planet, ErrPlanetNotFound
+	}
+
+	return planet, err
+}
+
+func (r *SQLRepository) FindByNames(ctx context.Context, names []string) (_ []Planet, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	planets := []Planet{}
+	if len(names) == 0 {
+		return planets, nil
+	}
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	query := "SELECT " + sqlColumns + " FROM planets WHERE name COLLATE NOCASE IN (" + placeholders(len(names)) + ")"
+
+	err = r.query(ctx, query, args, func(p Planet) error {
+		planets = append(planets, p)
+		return nil
+	})
+	return planets, err
+}
+
+// Insert writes the planets in a single transaction. The planets inserted
+// before a failure are kept, as with MongoDB.
+func (r *SQLRepository) Insert(ctx context.Context, planets []Planet, ordered bool) (_ []error, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	errs := make([]error, len(planets))
+	if len(planets) == 0 {
+		return errs, nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO planets (" + sqlColumns + ") VALUES (" + placeholders(strings.Count(sqlColumns, ",")+1) + ")"
+	for i, p := range planets {
+		_, err := tx.ExecContext(ctx, query, planetArgs(p)...)
+		if err == nil {
+			if err := sqlRecord(ctx, tx, newRevision(ctx, nil, p)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !isUniqueViolation(err) {
+			return nil, err
+		}
+
+		errs[i] = sqlNameConflict(ctx, tx, p.Name)
+		if ordered {
+			for j := i + 1; j < len(errs); j++ {
+				errs[j] = ErrBatchAborted
+			}
+			break
+		}
+	}
+
+	return errs, tx.Commit()
+}
+
+func (r *SQLRepository) Update(ctx context.Context, id primitive.ObjectID, change Change) (_ Planet, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	fields := make([]string, 0, len(change.Set))
+	for field := range change.Set {
+		if _, ok := sqlFields[field]; !ok {
+			return Planet{}, fmt.Errorf("unknown planet field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	assignments := make([]string, 0, len(fields)+2)
+	args := make([]interface{}, 0, len(fields)+4)
+	for _, field := range fields {
+		assignments = append(assignments, field+" = ?")
+		args = append(args, sqlValue(change.Set[field]))
+	}
+	assignments = append(assignments, "version = version + 1", "updated_at = ?")
+	args = append(args, change.At.UnixNano())
+
+	where := "id = ? AND (deleted_at IS NOT NULL) = ?"
+	args = append(args, id.Hex(), change.Trashed)
+	if change.Version > 0 {
+		where += " AND version = ?"
+		args = append(args, change.Version)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Planet{}, err
+	}
+	defer tx.Rollback()
+
+	before, err := scanPlanet(tx.QueryRowContext(ctx, "SELECT "+sqlColumns+" FROM planets WHERE id = ?", id.Hex()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Planet{}, ErrPlanetNotFound
+	}
+	if err != nil {
+		return Planet{}, err
+	}
+
+	query := "UPDATE planets SET " + strings.Join(assignments, ", ") + " WHERE " + where + " RETURNING " + sqlColumns
+	planet, err := scanPlanet(tx.QueryRowContext(ctx, query, args...))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return Planet{}, missedWrite(before, nil, change)
+	}
+	if isUniqueViolation(err) {
+		name, _ := change.Set["name"].(string)
+		return Planet{}, sqlNameConflict(ctx, tx, name)
+	}
+	if err != nil {
+		return Planet{}, err
+	}
+
+	if err := sqlRecord(ctx, tx, newRevision(ctx, &before, planet)); err != nil {
+		return Planet{}, err
+	}
+
+	return planet, tx.Commit()
+}
+
+// List bounds a page by the repository timeout, but not a full listing.
+func (r *SQLRepository) List(ctx context.Context, criteria Criteria, each func(Planet) error) (err error) {
+	timeout := time.Duration(0)
+	if criteria.Limit > 0 {
+		timeout = r.timeout
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	where, args, err := sqlCriteria(criteria)
+	if err != nil {
+		return err
+	}
+	order, err := sqlOrder(criteria.Query)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT " + sqlColumns + " FROM planets WHERE " + where + " ORDER BY " + order
+	if criteria.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, criteria.Limit)
+	}
+
+	return r.query(ctx, query, args, each)
+}
+
+func (r *SQLRepository) Delete(ctx context.Context, trashedBefore time.Time) (_ int64, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM planet_revisions WHERE planet_id IN (SELECT id FROM planets WHERE deleted_at < ?)", trashedBefore.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM planets WHERE deleted_at < ?", trashedBefore.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
+
+func (r *SQLRepository) Revisions(ctx context.Context, id primitive.ObjectID) (_ []Revision, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+sqlRevisionColumns+" FROM planet_revisions WHERE planet_id = ? ORDER BY version", id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+func (r *SQLRepository) Revision(ctx context.Context, id primitive.ObjectID, version int64) (_ Revision, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	row := r.db.QueryRowContext(ctx, "SELECT "+sqlRevisionColumns+" FROM planet_revisions WHERE planet_id = ? AND version = ?", id.Hex(), version)
+	revision, err := scanRevision(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return revision, ErrRevisionNotFound
+	}
+
+	return revision, err
+}
+
+func (r *SQLRepository) query(ctx context.Context, query string, args []interface{}, each func(Planet) error) error {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		planet, err := scanPlanet(rows)
+		if err != nil {
+			return err
+		}
+		if err := each(planet); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// sqlCriteria turns criteria into a WHERE clause and its arguments.
+func sqlCriteria(criteria Criteria) (string, []interface{}, error) {
+	clauses := []string{"deleted_at IS NULL"}
+	if criteria.Trashed {
+		clauses[0] = "deleted_at IS NOT NULL"
+	}
+	var args []interface{}
+
+	for _, f := range criteria.Query.Filters {
+		clause, filterArgs, err := sqlFilter(f)
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, clause)
+		args = append(args, filterArgs...)
+	}
+
+	if criteria.After != nil {
+		clause, afterArgs := sqlAfter(criteria.Query, *criteria.After)
+		clauses = append(clauses, clause)
+		args = append(args, afterArgs...)
+	}
+
+	return strings.Join(clauses, " AND "), args, nil
+}
+
+// sqlFilter compares a column the way filter() has MongoDB compare fields.
+// IS is used for equality so unknown values match nil.
+func sqlFilter(f Filter) (string, []interface{}, error) {
+	if _, ok := sqlFields[f.Field]; !ok {
+		return "", nil, fmt.Errorf("unknown planet field %q", f.Field)
+	}
+
+	switch f.Operator {
+	case OpEq:
+		clause, args := sqlEqual(f.Field, f.Value)
+		return clause, args, nil
+	case OpNe:
+		clause, args := sqlEqual(f.Field, f.Value)
+		return "NOT (" + clause + ")", args, nil
+	case OpIn:
+		values, _ := f.Value.([]interface{})
+		if len(values) == 0 {
+			return "0", nil, nil
+		}
+		clauses := make([]string, len(values))
+		var args []interface{}
+		for i, value := range values {
+			var valueArgs []interface{}
+			clauses[i], valueArgs = sqlEqual(f.Field, value)
+			args = append(args, valueArgs...)
+		}
+		return "(" + strings.Join(clauses, " OR ") + ")", args, nil
+	}
+
+	comparisons := map[Operator]string{OpGt: ">", OpGte: ">=", OpLt: "<", OpLte: "<="}
+	comparison, ok := comparisons[f.Operator]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown operator %q", f.Operator)
+	}
+	if f.Value == nil {
+		if f.Operator == OpGte || f.Operator == OpLte {
+			return f.Field + " IS NULL", nil, nil
+		}
+		return "0", nil, nil
+	}
+	return f.Field + " " + comparison + " ?", []interface{}{sqlValue(f.Value)}, nil
+}
+
+// sqlEqual matches a column holding value. A list matches when one of its
+// items does.
+func sqlEqual(field string, value interface{}) (string, []interface{}) {
+	if sqlFields[field] && value != nil {
+		return "EXISTS (SELECT 1 FROM json_each(" + field + ") WHERE value = ?)", []interface{}{value}
+	}
+	return field + " IS ?", []interface{}{sqlValue(value)}
+}
+
+// sqlOrder sorts like sort(). SQLite sorts NULL first in ascending order and
+// last in descending order, as MongoDB does.
+func sqlOrder(q Query) (string, error) {
+	order := make([]string, 0, len(q.Sort)+1)
+	for _, f := range q.Sort {
+		if isList, ok := sqlFields[f.Field]; !ok || isList {
+			return "", fmt.Errorf("cannot sort planets by %q", f.Field)
+		}
+		direction := "ASC"
+		if f.Descending {
+			direction = "DESC"
+		}
+		order = append(order, f.Field+" "+direction)
+	}
+
+	return strings.Join(append(order, "id ASC"), ", "), nil
+}
+
+// sqlAfter matches the planets coming after a position, like after().
+func sqlAfter(q Query, position Position) (string, []interface{}) {
+	var or, equal []string
+	var args, equalArgs []interface{}
+
+	for i, f := range q.Sort {
+		value := sqlValue(position.Values[i])
+		if clause, clauseArgs := sqlStrictlyAfter(f, value); clause != "" {
+			or = append(or, strings.Join(append(equal[:len(equal):len(equal)], clause), " AND "))
+			args = append(append(args, equalArgs...), clauseArgs...)
+		}
+		equal = append(equal, f.Field+" IS ?")
+		equalArgs = append(equalArgs, value)
+	}
+	or = append(or, strings.Join(append(equal, "id > ?"), " AND "))
+	args = append(append(args, equalArgs...), position.ID.Hex())
+
+	return "(" + strings.Join(or, " OR ") + ")", args
+}
+
+func sqlStrictlyAfter(f SortField, value interface{}) (string, []interface{}) {
+	switch {
+	case value == nil && f.Descending:
+		return "", nil
+	case value == nil:
+		return f.Field + " IS NOT NULL", nil
+	case f.Descending:
+		return "(" + f.Field + " < ? OR " + f.Field + " IS NULL)", []interface{}{value}
+	default:
+		return f.Field + " > ?", []interface{}{value}
+	}
+}
+
+// sqlValue converts a value in the shape returned by fieldValue to the way
+// it is stored.
+func sqlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []string:
+		if len(v) == 0 {
+			return nil
+		}
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	case time.Time:
+		return v.UnixNano()
+	}
+	return value
+}
+
+func planetArgs(p Planet) []interface{} {
+	return []interface{}{
+		p.ID.Hex(),
+		p.Name,
+		int64Value(p.RotationPeriod),
+		int64Value(p.OrbitalPeriod),
+		int64Value(p.Diameter),
+		sqlValue(p.Climate),
+		float64Value(p.Gravity),
+		sqlValue(p.Terrain),
+		float64Value(p.SurfaceWater),
+		int64Value(p.Population),
+		p.FilmCount,
+		sqlValue(p.Films),
+		sqlValue(p.fieldValue("enrichment")),
+		p.Version,
+		p.UpdatedAt.UnixNano(),
+		sqlValue(p.fieldValue("deleted_at")),
+	}
+}
+
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPlanet(row sqlScanner) (Planet, error) {
+	var p Planet
+	var id string
+	var rotationPeriod, orbitalPeriod, diameter, population, deletedAt sql.NullInt64
+	var gravity, surfaceWater sql.NullFloat64
+	var climate, terrain, films, enrichment sql.NullString
+	var updatedAt int64
+
+	err := row.Scan(&id, &p.Name, &rotationPeriod, &orbitalPeriod, &diameter, &climate, &gravity, &terrain,
+		&surfaceWater, &population, &p.FilmCount, &films, &enrichment, &p.Version, &updatedAt, &deletedAt)
+	if err != nil {
+		return Planet{}, err
+	}
+
+	if p.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return Planet{}, err
+	}
+	p.RotationPeriod = nullInt64(rotationPeriod)
+	p.OrbitalPeriod = nullInt64(orbitalPeriod)
+	p.Diameter = nullInt64(diameter)
+	p.Population = nullInt64(population)
+	p.Gravity = nullFloat64(gravity)
+	p.SurfaceWater = nullFloat64(surfaceWater)
+	p.Enrichment = enrichment.String
+	p.UpdatedAt = time.Unix(0, updatedAt).UTC()
+	if deletedAt.Valid {
+		t := time.Unix(0, deletedAt.Int64).UTC()
+		p.DeletedAt = &t
+	}
+	for _, list := range []struct {
+		raw  sql.NullString
+		dest *[]string
+	}{{climate, &p.Climate}, {terrain, &p.Terrain}, {films, &p.Films}} {
+		if list.raw.Valid {
+			if err := json.Unmarshal([]byte(list.raw.String), list.dest); err != nil {
+				return Planet{}, err
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// ListAt picks the latest revision of every planet at the given time. Like a
+// full listing, it is not bounded by the repository timeout.
+func (r *SQLRepository) ListAt(ctx context.Context, at time.Time, each func(Planet) error) (err error) {
+	ctx, cancel := withTimeout(ctx, 0)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	query := "SELECT " + sqlRevisionColumns + " FROM planet_revisions r WHERE version = " +
+		"(SELECT MAX(version) FROM planet_revisions WHERE planet_id = r.planet_id AND timestamp <= ?)"
+	rows, err := r.db.QueryContext(ctx, query, at.UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return err
+		}
+		if err := each(revision.After); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *SQLRepository) PendingEvents(ctx context.Context, limit int64) (_ []Event, err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	query := "SELECT " + sqlEventColumns + " FROM planet_outbox ORDER BY seq"
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		var id, planetID, planet string
+		var occurredAt int64
+		err := rows.Scan(&id, &event.Type, &planetID, &event.Version, &occurredAt, &event.RequestID, &event.Actor, &planet)
+		if err != nil {
+			return nil, err
+		}
+		if event.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		if event.PlanetID, err = primitive.ObjectIDFromHex(planetID); err != nil {
+			return nil, err
+		}
+		event.OccurredAt = time.Unix(0, occurredAt).UTC()
+		if err := json.Unmarshal([]byte(planet), &event.Planet); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (r *SQLRepository) AckEvent(ctx context.Context, id primitive.ObjectID) (err error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+	defer reportTimeout(ctx, &err)
+
+	_, err = r.db.ExecContext(ctx, "DELETE FROM planet_outbox WHERE id = ?", id.Hex())
+	return err
+}
+
+// sqlRecord stores a revision and raises its event within the transaction of
+// its change.
+func sqlRecord(ctx context.Context, tx *sql.Tx, revision Revision) error {
+	var before interface{}
+	if revision.Before != nil {
+		raw, err := json.Marshal(revision.Before)
+		if err != nil {
+			return err
+		}
+		before = string(raw)
+	}
+	after, err := json.Marshal(revision.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO planet_revisions ("+sqlRevisionColumns+") VALUES ("+placeholders(7)+")",
+		revision.PlanetID.Hex(), revision.Version, revision.Timestamp.UnixNano(),
+		revision.RequestID, revision.Actor, before, string(after))
+	if err != nil {
+		return err
+	}
+
+	event := newEvent(revision)
+	_, err = tx.ExecContext(ctx, "INSERT INTO planet_outbox ("+sqlEventColumns+") VALUES ("+placeholders(8)+")",
+		event.ID.Hex(), event.Type, event.PlanetID.Hex(), event.Version, event.OccurredAt.UnixNano(),
+		event.RequestID, event.Actor, string(after))
+	return err
+}
+
+func scanRevision(row sqlScanner) (Revision, error) {
+	var r Revision
+	var planetID, after string
+	var timestamp int64
+	var before sql.NullString
+
+	err := row.Scan(&planetID, &r.Version, &timestamp, &r.RequestID, &r.Actor, &before, &after)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	if r.PlanetID, err = primitive.ObjectIDFromHex(planetID); err != nil {
+		return Revision{}, err
+	}
+	r.Timestamp = time.Unix(0, timestamp).UTC()
+	if before.Valid {
+		r.Before = &Planet{}
+		if err := json.Unmarshal([]byte(before.String), r.Before); err != nil {
+			return Revision{}, err
+		}
+	}
+	if err := json.Unmarshal([]byte(after), &r.After); err != nil {
+		return Revision{}, err
+	}
+
+	return r, nil
+}
+
+func nullInt64(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
+
+func nullFloat64(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+type sqlQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqlNameConflict returns an AlreadyExistsError pointing to the planet
+// holding the name.
+func sqlNameConflict(ctx context.Context, db sqlQuerier, name string) error {
+	conflict := &AlreadyExistsError{Name: name}
+
+	var id string
+	if err := db.QueryRowContext(ctx, "SELECT id FROM planets WHERE name = ? COLLATE NOCASE", name).Scan(&id); err == nil {
+		conflict.ExistingID, _ = primitive.ObjectIDFromHex(id)
+	}
+
+	return conflict
+}
@@ -0,0 +1,27 @@
+package planet
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func Test_SQLRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T, timeout time.Duration) Repository {
+		db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "planets.db")+"?_txlock=immediate")
+		if err != nil {
+			t.Fatalf("sql.Open() unexpected error %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		r := NewSQLRepository(db).WithTimeout(timeout)
+		if err := r.EnsureSchema(context.Background()); err != nil {
+			t.Fatalf("repository.EnsureSchema() unexpected error %v", err)
+		}
+		return r
+	})
+}
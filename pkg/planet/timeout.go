@@ -0,0 +1,62 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxTimeMSExpired is the code of the MongoDB error raised when an operation
+// runs out of its maxTimeMS.
+const maxTimeMSExpired = 50
+
+// callerKey holds, in the context of an operation, the context it was called
+// with.
+type callerKey struct{}
+
+// withTimeout bounds an operation by a repository timeout, on top of the
+// deadline ctx may already have. A zero timeout sets none.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, callerKey{}, ctx)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timedOut reports the operations that ran out of the repository time, on the
+// client or on the server through maxTimeMS, as ErrTimeout. When the caller
+// gave up first, through its own deadline or cancellation, the error is
+// returned as is, like any other error.
+func timedOut(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ErrTimeout) {
+		return err
+	}
+
+	var serverErr driver.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(maxTimeMSExpired) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+
+	caller := ctx
+	if parent, ok := ctx.Value(callerKey{}).(context.Context); ok {
+		caller = parent
+	}
+	if caller.Err() != nil {
+		return err
+	}
+	if driver.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+
+	return err
+}
+
+// reportTimeout applies timedOut to the error an operation returns, when
+// deferred by it.
+func reportTimeout(ctx context.Context, err *error) {
+	*err = timedOut(ctx, *err)
+}
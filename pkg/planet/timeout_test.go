@@ -0,0 +1,64 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+func Test_timedOut(t *testing.T) {
+	tests := []struct {
+		name        string
+		givenErr    error
+		callerGone  bool
+		wantTimeout bool
+	}{
+		{
+			name:        "when the operation ran out of its deadline then it should report a timeout",
+			givenErr:    context.DeadlineExceeded,
+			wantTimeout: true,
+		},
+		{
+			name:        "when the query ran out of its maxTimeMS then it should report a timeout",
+			givenErr:    driver.CommandError{Code: maxTimeMSExpired, Message: "operation exceeded time limit"},
+			wantTimeout: true,
+		},
+		{
+			name:     "when the query failed otherwise then it should return the error as is",
+			givenErr: driver.CommandError{Code: 11000, Message: "duplicate key"},
+		},
+		{
+			name:     "when the request was cancelled then it should return the error as is",
+			givenErr: context.Canceled,
+		},
+		{
+			name:       "when the caller ran out of its own deadline then it should return the error as is",
+			givenErr:   context.DeadlineExceeded,
+			callerGone: true,
+		},
+		{
+			name: "when there is no error then it should return nil",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			caller, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.callerGone {
+				cancel()
+			}
+			ctx, cancelOperation := withTimeout(caller, time.Minute)
+			defer cancelOperation()
+
+			err := timedOut(ctx, tc.givenErr)
+			assert.Equal(t, tc.wantTimeout, errors.Is(err, ErrTimeout), "timedOut() unexpected timeout")
+			if !tc.wantTimeout {
+				assert.Equal(t, tc.givenErr, err, "timedOut() unexpected error")
+			}
+		})
+	}
+}
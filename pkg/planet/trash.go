@@ -0,0 +1,28 @@
+package planet
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListTrash returns soft-deleted planets, paginated the same way as List.
+func (s *Service) ListTrash(ctx context.Context, opts ListOptions) (Page, error) {
+	return s.list(ctx, true, opts)
+}
+
+// Restore takes a planet out of the trash and returns it.
+func (s *Service) Restore(ctx context.Context, id string) (Planet, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+
+	change := Change{Trashed: true, At: writeTime(), Set: map[string]interface{}{"deleted_at": nil}}
+	return s.repo.Update(ctx, objectID, change)
+}
+
+// Purge permanently removes planets that were deleted before the given time.
+// Their revisions go with them: reads at a point in time no longer find them,
+// even at times they existed.
+func (s *Service) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return s.repo.Delete(ctx, deletedBefore)
+}
@@ -0,0 +1,50 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_service_Trash(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	mars, _ := s.Insert(ctx, Planet{Name: "Mars"})
+	venus, _ := s.Insert(ctx, Planet{Name: "Venus"})
+	if err := s.Delete(ctx, mars.ID.Hex()); err != nil {
+		t.Fatalf("service.Delete() an error occurred deleting a planet for test")
+	}
+	if err := s.Delete(ctx, venus.ID.Hex()); err != nil {
+		t.Fatalf("service.Delete() an error occurred deleting a planet for test")
+	}
+
+	trash, err := s.ListTrash(ctx, ListOptions{})
+	if err != nil {
+		t.Fatalf("service.ListTrash() unexpected error %v", err)
+	}
+	assert.Len(t, trash.Planets, 2, "service.ListTrash() unexpected trash size")
+
+	restored, err := s.Restore(ctx, mars.ID.Hex())
+	if err != nil {
+		t.Fatalf("service.Restore() unexpected error %v", err)
+	}
+	assert.Nil(t, restored.DeletedAt, "service.Restore() planet still marked as deleted")
+
+	if _, err := s.Restore(ctx, mars.ID.Hex()); !errors.Is(err, ErrPlanetNotFound) {
+		t.Errorf("service.Restore() errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+	}
+
+	purged, err := s.Purge(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("service.Purge() unexpected error %v", err)
+	}
+	assert.Equal(t, int64(1), purged, "service.Purge() unexpected purged count")
+
+	if _, err := s.GetByID(ctx, mars.ID.Hex()); err != nil {
+		t.Errorf("service.Purge() restored planet should remain, err = %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package planet
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrPlanetAlreadyExists = errors.New("planet already exists")
+)
+
+// AlreadyExistsError is returned when a planet name is already taken. It
+// matches ErrPlanetAlreadyExists with errors.Is.
+type AlreadyExistsError struct {
+	Name       string
+	ExistingID primitive.ObjectID
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("%s: %q is taken by %s", ErrPlanetAlreadyExists, e.Name, e.ExistingID.Hex())
+}
+
+func (e *AlreadyExistsError) Unwrap() error {
+	return ErrPlanetAlreadyExists
+}
@@ -0,0 +1,55 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_service_UniqueName(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	tatooine, err := s.Insert(ctx, Planet{Name: "Tatooine"})
+	if err != nil {
+		t.Fatalf("service.Insert() an error occurred inserting a planet for test")
+	}
+	hoth, err := s.Insert(ctx, Planet{Name: "Hoth"})
+	if err != nil {
+		t.Fatalf("service.Insert() an error occurred inserting a planet for test")
+	}
+
+	tests := []struct {
+		name  string
+		write func() error
+	}{
+		{
+			name: "when inserting a name that differs only by case, then it should return already exists err",
+			write: func() error {
+				_, err := s.Insert(ctx, Planet{Name: "tatooine"})
+				return err
+			},
+		},
+		{
+			name: "when renaming onto another planet name, then it should return already exists err",
+			write: func() error {
+				_, err := s.Update(ctx, Planet{ID: hoth.ID, Name: "TATOOINE"})
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.write()
+			if !errors.Is(err, ErrPlanetAlreadyExists) {
+				t.Fatalf("errorType = %v, wantErrorType %v", err, ErrPlanetAlreadyExists)
+			}
+			var conflict *AlreadyExistsError
+			if errors.As(err, &conflict) {
+				assert.Equal(t, tatooine.ID, conflict.ExistingID, "unexpected conflicting planet id")
+			}
+		})
+	}
+}
@@ -2,24 +2,19 @@ package planet
 
 import (
 	"context"
-
-	"go.mongodb.org/mongo-driver/bson"
 )
 
-func (s *Service) Update(ctx context.Context, planetDocument Planet) (int64, error) {
-
-	filter := bson.M{"_id": planetDocument.ID}
-	update := bson.M{"$set": bson.M{
-		"name": planetDocument.Name,
-	}}
-
-	result, err := s.db.UpdateOne(ctx, filter, update)
-
-	if err != nil {
-		return 0, err
-	} else if result.MatchedCount == 0 {
-		return 0, ErrPlanetNotFound
+// Update replaces the attributes of a planet and returns it with its new
+// version. When the planet carries a version the update only applies to that
+// version, otherwise ErrVersionConflict is returned. A renamed planet is
+// enriched again.
+func (s *Service) Update(ctx context.Context, planetDocument Planet) (Planet, error) {
+	change := Change{
+		Version: planetDocument.Version,
+		At:      writeTime(),
+		Set:     fieldChanges(planetDocument, PatchableFields),
 	}
+	s.reenrich(ctx, planetDocument.ID, &change)
 
-	return result.MatchedCount, err
+	return s.repo.Update(ctx, planetDocument.ID, change)
 }
@@ -4,26 +4,13 @@ import (
 	"context"
 	"errors"
 	"testing"
-	"time"
 
-	"star-wars/pkg/testutils/docker"
-
-	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	driver "go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func Test_service_Update(t *testing.T) {
-	mongoServer := docker.NewMongo()
-	mongoServer.WithTestPort(t).
-		Start(t)
-	defer mongoServer.Stop()
-
-	mongo := mongoCollection(mongoServer.GetHost())
-
-	s := NewService(mongo, 2*time.Second)
+	s := NewService(NewMemoryRepository())
 	id, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
 
 	existingPlanet := Planet{
@@ -32,7 +19,7 @@ func Test_service_Update(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	_, err := s.db.InsertOne(ctx, existingPlanet)
+	_, err := s.repo.Insert(ctx, []Planet{existingPlanet}, true)
 	if err != nil {
 		t.Fatalf("service.Update() an error occurred inserting a planet for test")
 	}
@@ -82,15 +69,3 @@ func Test_service_Update(t *testing.T) {
 		})
 	}
 }
-
-func mongoCollection(host string) *driver.Collection {
-	ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
-	defer cancel()
-	client, err := driver.Connect(ctx, options.Client().ApplyURI(host))
-
-	if err != nil {
-		log.Fatal("Error trying to connect to the database")
-	}
-
-	return client.Database("planet").Collection("planet")
-}
@@ -0,0 +1,96 @@
+package planet
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+const (
+	UpsertInserted = "inserted"
+	UpsertUpdated  = "updated"
+	UpsertSkipped  = "skipped"
+)
+
+// UpsertResult is the outcome of upserting one planet. Outcome is empty when
+// Err is set.
+type UpsertResult struct {
+	Planet  Planet
+	Outcome string
+	Err     error
+}
+
+// UpsertByName writes each planet over the stored planet with the same name,
+// ignoring case, or inserts it when there is none. Planets whose attributes
+// already match the stored ones are skipped without a write. Updates are
+// conditional on the version read, and new planets go through InsertBatch.
+// The returned error is only set when the batch as a whole failed.
+func (s *Service) UpsertByName(ctx context.Context, planets []Planet) ([]UpsertResult, error) {
+	results := make([]UpsertResult, len(planets))
+
+	names := make([]string, len(planets))
+	for i, p := range planets {
+		names[i] = p.Name
+	}
+	stored, err := s.repo.FindByNames(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]Planet, len(stored))
+	for _, p := range stored {
+		if p.DeletedAt == nil {
+			byName[strings.ToLower(p.Name)] = p
+		}
+	}
+
+	var inserts []Planet
+	var insertPositions []int
+	for i, p := range planets {
+		existing, ok := byName[strings.ToLower(p.Name)]
+		if !ok {
+			inserts = append(inserts, p)
+			insertPositions = append(insertPositions, i)
+			continue
+		}
+		if sameAttributes(existing, p) {
+			results[i] = UpsertResult{Planet: existing, Outcome: UpsertSkipped}
+			continue
+		}
+
+		p.ID, p.Version = existing.ID, existing.Version
+		updated, err := s.Update(ctx, p)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i] = UpsertResult{Planet: updated, Outcome: UpsertUpdated}
+		byName[strings.ToLower(p.Name)] = updated
+	}
+
+	if len(inserts) > 0 {
+		inserted, err := s.InsertBatch(ctx, inserts, false)
+		if err != nil {
+			return nil, err
+		}
+		for j, result := range inserted {
+			i := insertPositions[j]
+			results[i] = UpsertResult{Planet: result.Planet, Err: result.Err}
+			if result.Err == nil {
+				results[i].Outcome = UpsertInserted
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// sameAttributes tells whether two planets hold the same client provided
+// attributes.
+func sameAttributes(a, b Planet) bool {
+	for _, field := range PatchableFields {
+		if !reflect.DeepEqual(a.fieldValue(field), b.fieldValue(field)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package planet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_service_UpsertByName(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	tatooine, err := s.Insert(ctx, Planet{Name: "Tatooine", Climate: []string{"arid"}})
+	if err != nil {
+		t.Fatalf("service.UpsertByName() an error occurred inserting a planet for test")
+	}
+	hoth, err := s.Insert(ctx, Planet{Name: "Hoth", Climate: []string{"frozen"}})
+	if err != nil {
+		t.Fatalf("service.UpsertByName() an error occurred inserting a planet for test")
+	}
+
+	results, err := s.UpsertByName(ctx, []Planet{
+		{Name: "tatooine", Climate: []string{"arid"}, Diameter: int64Ptr(10465)},
+		{Name: "Hoth", Climate: []string{"frozen"}},
+		{Name: "Dagobah", Climate: []string{"murky"}},
+	})
+	if err != nil {
+		t.Fatalf("service.UpsertByName() unexpected error %v", err)
+	}
+
+	assert.Equal(t, UpsertUpdated, results[0].Outcome, "service.UpsertByName() unexpected outcome for an existing planet")
+	assert.Equal(t, tatooine.ID, results[0].Planet.ID, "service.UpsertByName() should update the planet with the same name")
+	assert.Equal(t, int64Ptr(10465), results[0].Planet.Diameter, "service.UpsertByName() unexpected diameter")
+	assert.Equal(t, UpsertSkipped, results[1].Outcome, "service.UpsertByName() unexpected outcome for an unchanged planet")
+	assert.Equal(t, hoth.Version, results[1].Planet.Version, "service.UpsertByName() should not write an unchanged planet")
+	assert.Equal(t, UpsertInserted, results[2].Outcome, "service.UpsertByName() unexpected outcome for a new planet")
+
+	if _, err := s.GetByID(ctx, results[2].Planet.ID.Hex()); err != nil {
+		t.Errorf("service.UpsertByName() inserted planet not found %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+package planet
+
+import (
+	"errors"
+)
+
+var (
+	ErrVersionConflict = errors.New("planet version conflict")
+)
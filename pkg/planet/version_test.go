@@ -0,0 +1,46 @@
+package planet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Test_service_Versions(t *testing.T) {
+	s := NewService(NewMemoryRepository())
+	ctx := context.Background()
+
+	mars, err := s.Insert(ctx, Planet{Name: "Mars"})
+	if err != nil {
+		t.Fatalf("service.Update() an error occurred inserting a planet for test")
+	}
+	assert.Equal(t, int64(1), mars.Version, "service.Insert() unexpected first version")
+
+	updated, err := s.Update(ctx, Planet{ID: mars.ID, Name: "Red Mars", Version: mars.Version})
+	if err != nil {
+		t.Fatalf("service.Update() unexpected error %v", err)
+	}
+	assert.Equal(t, int64(2), updated.Version, "service.Update() unexpected version")
+	assert.False(t, updated.UpdatedAt.Before(mars.UpdatedAt), "service.Update() updated_at should move forward")
+
+	if _, err := s.Update(ctx, Planet{ID: mars.ID, Name: "Stale Mars", Version: mars.Version}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("service.Update() errorType = %v, wantErrorType %v", err, ErrVersionConflict)
+	}
+	if _, err := s.Patch(ctx, mars.ID.Hex(), Planet{Diameter: int64Ptr(6779), Version: mars.Version}, []string{"diameter"}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("service.Patch() errorType = %v, wantErrorType %v", err, ErrVersionConflict)
+	}
+
+	patched, err := s.Patch(ctx, mars.ID.Hex(), Planet{Diameter: int64Ptr(6779), Version: updated.Version}, []string{"diameter"})
+	if err != nil {
+		t.Fatalf("service.Patch() unexpected error %v", err)
+	}
+	assert.Equal(t, int64(3), patched.Version, "service.Patch() unexpected version")
+
+	missing, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3905")
+	if _, err := s.Update(ctx, Planet{ID: missing, Name: "Nowhere", Version: 1}); !errors.Is(err, ErrPlanetNotFound) {
+		t.Errorf("service.Update() errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+	}
+}
@@ -0,0 +1,123 @@
+package planet
+
+import (
+	"context"
+	"sync"
+)
+
+// watchBuffer is how many events a stream can lag behind before it is
+// dropped, so a slow client never holds up the others.
+const watchBuffer = 64
+
+// StreamedEvent is a planet event read from a live stream, with the token
+// that resumes the stream right after it. A stream that cannot resume after
+// the token it was given starts with a reset instead of an event, the events
+// in between being lost, so its client knows to reload the planets.
+type StreamedEvent struct {
+	Token string
+	Event Event
+	Reset bool
+}
+
+// EventHub fans the planet events published in the process, or relayed from
+// a MongoDB change stream, out to live streams. It keeps the latest events, so
+// a stream can resume after one of them.
+type EventHub struct {
+	mu       sync.Mutex
+	recent   []StreamedEvent
+	size     int
+	watchers map[chan StreamedEvent]struct{}
+}
+
+// NewEventHub returns a hub keeping the latest size events to resume from.
+func NewEventHub(size int) *EventHub {
+	return &EventHub{
+		size:     size,
+		watchers: map[chan StreamedEvent]struct{}{},
+	}
+}
+
+// Publish hands the event to every stream, with its id as token.
+func (h *EventHub) Publish(ctx context.Context, event Event) error {
+	h.Relay(StreamedEvent{Token: event.ID.Hex(), Event: event})
+	return nil
+}
+
+// Relay hands an event streamed from elsewhere to every stream, keeping its
+// token. A reset drops the events kept, as no stream can resume across it. A
+// stream too far behind is closed instead of waited for, its client can
+// resume from the last event it got.
+func (h *EventHub) Relay(streamed StreamedEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if streamed.Reset {
+		h.recent = nil
+	} else {
+		h.recent = append(h.recent, streamed)
+		if len(h.recent) > h.size {
+			h.recent = h.recent[len(h.recent)-h.size:]
+		}
+	}
+
+	for watcher := range h.watchers {
+		select {
+		case watcher <- streamed:
+		default:
+			h.unwatch(watcher)
+		}
+	}
+}
+
+// Watch streams the events published after the one of the given token, or
+// from now on when the token is empty. A token too old to be kept starts the
+// stream from now with a reset. The stream is closed once ctx is done.
+func (h *EventHub) Watch(ctx context.Context, after string) (<-chan StreamedEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	backlog := h.backlog(after)
+
+	watcher := make(chan StreamedEvent, len(backlog)+watchBuffer)
+	for _, streamed := range backlog {
+		watcher <- streamed
+	}
+	h.watchers[watcher] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.unwatch(watcher)
+	}()
+
+	return watcher, nil
+}
+
+// backlog is what a stream resuming after the given token has missed. The
+// caller must hold the lock.
+func (h *EventHub) backlog(after string) []StreamedEvent {
+	if after == "" {
+		return nil
+	}
+	for i, streamed := range h.recent {
+		if streamed.Token == after {
+			return h.recent[i+1:]
+		}
+	}
+
+	reset := StreamedEvent{Reset: true}
+	if len(h.recent) > 0 {
+		reset.Token = h.recent[len(h.recent)-1].Token
+	}
+	return []StreamedEvent{reset}
+}
+
+// unwatch closes a stream, unless it already is. The caller must hold the
+// lock.
+func (h *EventHub) unwatch(watcher chan StreamedEvent) {
+	if _, ok := h.watchers[watcher]; ok {
+		delete(h.watchers, watcher)
+		close(watcher)
+	}
+}
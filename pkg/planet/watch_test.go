@@ -0,0 +1,80 @@
+package planet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func Test_EventHub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := NewEventHub(2)
+
+	created := Event{ID: primitive.NewObjectID(), Type: PlanetCreated}
+	updated := Event{ID: primitive.NewObjectID(), Type: PlanetUpdated}
+	deleted := Event{ID: primitive.NewObjectID(), Type: PlanetDeleted}
+	hub.Publish(ctx, created)
+	hub.Publish(ctx, updated)
+
+	resumed, _ := hub.Watch(ctx, created.ID.Hex())
+	live, _ := hub.Watch(ctx, "")
+	tooOld, _ := hub.Watch(ctx, primitive.NewObjectID().Hex())
+	hub.Publish(ctx, deleted)
+
+	assert.Equal(t, []EventType{PlanetUpdated, PlanetDeleted}, receive(resumed, 2), "EventHub.Watch() a stream should resume after its token")
+	assert.Equal(t, []EventType{PlanetDeleted}, receive(live, 1), "EventHub.Watch() a stream without token should start from now")
+	reset := <-tooOld
+	assert.True(t, reset.Reset, "EventHub.Watch() a stream with an unknown token should start with a reset")
+	assert.Equal(t, updated.ID.Hex(), reset.Token, "EventHub.Watch() the reset should resume after the latest event")
+	assert.Equal(t, []EventType{PlanetDeleted}, receive(tooOld, 1), "EventHub.Watch() a stream with an unknown token should start from now")
+
+	cancel()
+	if _, open := <-live; open {
+		t.Errorf("EventHub.Watch() the stream should be closed once its context is done")
+	}
+}
+
+func Test_EventHub_slowStream(t *testing.T) {
+	ctx := context.Background()
+	hub := NewEventHub(1)
+
+	slow, _ := hub.Watch(ctx, "")
+	for i := 0; i <= watchBuffer; i++ {
+		hub.Publish(ctx, Event{ID: primitive.NewObjectID(), Type: PlanetUpdated})
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, watchBuffer, received, "EventHub.Publish() a stream too far behind should be closed")
+}
+
+func Test_EventHub_Relay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := NewEventHub(2)
+
+	hub.Relay(StreamedEvent{Token: "a", Event: Event{Type: PlanetCreated}})
+	hub.Relay(StreamedEvent{Token: "b", Event: Event{Type: PlanetUpdated}})
+	resumed, _ := hub.Watch(ctx, "a")
+	assert.Equal(t, []EventType{PlanetUpdated}, receive(resumed, 1), "EventHub.Relay() a stream should resume after the token relayed")
+
+	hub.Relay(StreamedEvent{Token: "c", Reset: true})
+	assert.True(t, (<-resumed).Reset, "EventHub.Relay() a reset should reach the streams")
+
+	afterReset, _ := hub.Watch(ctx, "b")
+	assert.True(t, (<-afterReset).Reset, "EventHub.Relay() no stream should resume across a reset")
+}
+
+func receive(events <-chan StreamedEvent, n int) []EventType {
+	var types []EventType
+	for i := 0; i < n; i++ {
+		streamed := <-events
+		types = append(types, streamed.Event.Type)
+	}
+	return types
+}
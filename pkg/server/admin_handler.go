@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+)
+
+// cacheStatsDTO shows the planet cache stats. The hit ratio is zero until
+// the first lookup.
+type cacheStatsDTO struct {
+	Size      int     `json:"size"`
+	Capacity  int     `json:"capacity"`
+	TTL       string  `json:"ttl"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Evictions int64   `json:"evictions"`
+	HitRatio  float64 `json:"hit_ratio"`
+}
+
+func newCacheStatsDTO(stats cacheStats) cacheStatsDTO {
+	dto := cacheStatsDTO{
+		Size:      stats.Size,
+		Capacity:  stats.Capacity,
+		TTL:       stats.TTL.String(),
+		Hits:      stats.Hits,
+		Misses:    stats.Misses,
+		Evictions: stats.Evictions,
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		dto.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	return dto
+}
+
+type CacheInspector interface {
+	Stats() cacheStats
+}
+
+func (a *App) handleGetCacheStats(cacheInspector CacheInspector) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		writeJsonResponse(rw, http.StatusOK, newCacheStatsDTO(cacheInspector.Stats()))
+	}
+}
+
+type CacheFlusher interface {
+	Flush()
+}
+
+// handleFlushCache empties the planet cache, for when the planets were
+// changed behind the service's back.
+func (a *App) handleFlushCache(cacheFlusher CacheFlusher) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		cacheFlusher.Flush()
+		loggerFromRequest(r).Info("planet cache flushed")
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type cacheAdminMock struct {
+	stats   cacheStats
+	flushed bool
+}
+
+func (a *cacheAdminMock) Stats() cacheStats {
+	return a.stats
+}
+
+func (a *cacheAdminMock) Flush() {
+	a.flushed = true
+}
+
+func Test_handleGetCacheStats(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		cacheAdminMock   *cacheAdminMock
+		wantResponseBody string
+	}{
+		{
+			name:             "when the cache was used, then it should return its stats with the hit ratio",
+			cacheAdminMock:   &cacheAdminMock{stats: cacheStats{Size: 2, Capacity: 1000, TTL: time.Minute, Hits: 3, Misses: 1, Evictions: 1}},
+			wantResponseBody: `{"size":2,"capacity":1000,"ttl":"1m0s","hits":3,"misses":1,"evictions":1,"hit_ratio":0.75}`,
+		},
+		{
+			name:             "when the cache was never used, then it should return a zero hit ratio",
+			cacheAdminMock:   &cacheAdminMock{stats: cacheStats{Capacity: 1000}},
+			wantResponseBody: `{"size":0,"capacity":1000,"ttl":"0s","hits":0,"misses":0,"evictions":0,"hit_ratio":0}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{cacheInspector: tc.cacheAdminMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", "/v1/admin/cache", nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Errorf("handleGetCacheStats() status code = %v, want %v", rr.Code, http.StatusOK)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleGetCacheStats() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+func Test_handleFlushCache(t *testing.T) {
+	t.Parallel()
+
+	flusher := &cacheAdminMock{}
+	var app App
+	app.container = &container{cacheFlusher: flusher}
+	app.RegisterRoutes()
+
+	req, _ := http.NewRequest("POST", "/v1/admin/cache:flush", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("handleFlushCache() status code = %v, want %v", rr.Code, http.StatusNoContent)
+	}
+	if !flusher.flushed {
+		t.Errorf("handleFlushCache() the cache should be flushed")
+	}
+}
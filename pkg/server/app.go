@@ -5,38 +5,72 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"time"
 
 	"star-wars/pkg/planet"
+	"star-wars/pkg/swapi"
+	"star-wars/pkg/webhook"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
-	driver "go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type App struct {
-	router    *mux.Router
-	container *container
-	server    *http.Server
+	router     *mux.Router
+	container  *container
+	server     *http.Server
+	onShutdown []func(context.Context) error
+	// events receives the planet events relayed from the outbox, for the
+	// subscribers within the application.
+	events *planet.InProcessPublisher
+	// changes feeds the planet event streams from the MongoDB change stream,
+	// when the planets are stored in MongoDB.
+	changes func(context.Context) error
+	// streams ends the planet event streams on shutdown.
+	streams *streamCloser
+	// jobs are stopped on shutdown, before the hooks close the storage.
+	jobs    *jobGroup
+	sockets socketPolicy
 }
 
+// Start runs the background jobs and serves the requests until the server is
+// shut down. Shutting down before Start is called makes it return right away.
 func (a *App) Start(ctx context.Context) {
-	port := viper.GetString("port")
-	log.Printf("Application started at port: %s", port)
-	a.server = &http.Server{
-		Addr:        fmt.Sprintf(":%s", port),
-		Handler:     a,
-		BaseContext: func(_ net.Listener) context.Context { return ctx },
-	}
+	log.Printf("Application started at port: %s", viper.GetString("port"))
+	a.startJobs(ctx)
+	// Shutdown does not read it, so it can be set after the server is shared.
+	a.server.BaseContext = func(_ net.Listener) context.Context { return ctx }
 	if err := a.server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatalf("HTTP server ListenAndServe: %v", err)
 	}
 }
-func (a App) Shutdown(ctx context.Context) error {
-	return a.server.Shutdown(ctx)
+
+// Shutdown ends the event streams and stops the server, waiting for the
+// WebSockets to close, and stops the background jobs, waiting for the ones
+// running. It then runs the shutdown hooks even when the server did not stop
+// cleanly. The first error met is returned.
+func (a *App) Shutdown(ctx context.Context) error {
+	if a.streams != nil {
+		a.streams.Close()
+	}
+	err := a.server.Shutdown(ctx)
+	if a.streams != nil {
+		if waitErr := a.streams.Wait(ctx); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	if a.jobs != nil {
+		if stopErr := a.jobs.Stop(ctx); stopErr != nil && err == nil {
+			err = stopErr
+		}
+	}
+	for _, hook := range a.onShutdown {
+		if hookErr := hook(ctx); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+	return err
 }
 
 func (a App) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -47,26 +81,40 @@ func NewApp() *App {
 	var app App
 
 	configureLog(viper.GetString("log_level"))
-	planetService := planet.NewService(mongoCollection(), viper.GetDuration("MONGO_TIMEOUT"))
-	container := NewContainer(planetService)
+	planetRepository, webhookRepository := app.newRepositories()
+	planetService := planet.NewService(planetRepository)
+	if swapiURL := viper.GetString("SWAPI_URL"); swapiURL != "" {
+		planetService.WithFilmsResolver(swapi.NewClient(swapiURL, &http.Client{
+			Timeout:   viper.GetDuration("SWAPI_TIMEOUT"),
+			Transport: metricTransport{next: http.DefaultTransport},
+		})).WithEnrichmentTimeout(viper.GetDuration("ENRICHMENT_TIMEOUT"))
+	}
+	webhookService := webhook.NewService(webhookRepository, &http.Client{
+		Timeout:   viper.GetDuration("WEBHOOK_TIMEOUT"),
+		Transport: metricTransport{next: webhookTransport(viper.GetBool("WEBHOOK_ALLOW_PRIVATE_URLS"))},
+	}).WithRetries(viper.GetInt("WEBHOOK_MAX_ATTEMPTS"), viper.GetDuration("WEBHOOK_BACKOFF")).
+		WithEncoder(encodeWebhookEvent).
+		WithPrivateURLs(viper.GetBool("WEBHOOK_ALLOW_PRIVATE_URLS"))
+	app.events = planet.NewInProcessPublisher()
+	app.events.Subscribe(logEvent)
+	app.events.Subscribe(webhookService.Enqueue)
+	planetService.WithEventPublisher(app.events)
+	app.streams = newStreamCloser()
+	app.sockets = socketPolicyFromConfig()
+	planetCache := planetCacheFromConfig(newPlanetCoalescer(planetService))
+	container := NewContainer(planetService, webhookService, app.newPlanetWatcher(planetRepository), planetCache)
 	app.container = container
 
 	app.RegisterRoutes()
-
-	return &app
-}
-
-func mongoCollection() *driver.Collection {
-
-	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
-	defer cancel()
-	client, err := driver.Connect(ctx, options.Client().ApplyURI(viper.GetString("MONGO_URI")))
-
-	if err != nil {
-		log.Fatal("Error trying to connect to the database.", err)
+	// Both are made before Start runs on its own goroutine, so Shutdown
+	// finds them whenever it is called.
+	app.jobs = newJobGroup()
+	app.server = &http.Server{
+		Addr:    fmt.Sprintf(":%s", viper.GetString("port")),
+		Handler: &app,
 	}
 
-	return client.Database(viper.GetString("MONGO_DB")).Collection(viper.GetString("MONGO_COLLECTION"))
+	return &app
 }
 
 func (a *App) RegisterRoutes() {
@@ -74,12 +122,38 @@ func (a *App) RegisterRoutes() {
 
 	router.Use(a.HTTPServerMetricMiddleware)
 	router.Use(a.RequestIdMiddleware)
+	router.Use(a.AuthorMiddleware)
 
 	router.Handle("/health", a.healthHandler()).Methods(http.MethodGet)
 	router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	router.Handle("/v1/planets", a.handleListPlanets(a.container.planetLister)).Methods(http.MethodGet)
 	router.Handle("/v1/planets", a.handleCreatePlanet(a.container.planetInserter)).Methods(http.MethodPost)
-	router.Handle("/v1/planets/{id}", a.handleGetPlanetByID(a.container.planetGetter)).Methods(http.MethodGet)
+	router.Handle("/v1/planets:batch", a.handleCreatePlanetBatch(a.container.batchInserter)).Methods(http.MethodPost)
+	router.Handle("/v1/planets/import", a.handleImportPlanets(a.container.planetImporter)).Methods(http.MethodPost)
+	router.Handle("/v1/planets/export", a.handleExportPlanets(a.container.planetExporter)).Methods(http.MethodGet)
+	router.Handle("/v1/planets/trash", a.handleListTrash(a.container.trashLister)).Methods(http.MethodGet)
+	router.Handle("/v1/planets/events", a.handlePlanetEvents(a.container.planetWatcher)).Methods(http.MethodGet)
+	router.Handle("/v1/planets/{id}", a.handleGetPlanetByID(a.container.planetGetter, a.container.historyReader)).Methods(http.MethodGet)
 	router.Handle("/v1/planets/{id}", a.handleUpdatePlanet(a.container.planetUpdater)).Methods(http.MethodPut)
+	router.Handle("/v1/planets/{id}", a.handlePatchPlanet(a.container.uncachedGetter, a.container.planetPatcher)).Methods(http.MethodPatch)
+	router.Handle("/v1/planets/{id}", a.handleDeletePlanet(a.container.planetDeleter)).Methods(http.MethodDelete)
+	router.Handle("/v1/planets/{id}/restore", a.handleRestorePlanet(a.container.planetRestorer)).Methods(http.MethodPost)
+	router.Handle("/v1/planets/{id}/revisions", a.handleListRevisions(a.container.revisionLister)).Methods(http.MethodGet)
+	router.Handle("/v1/planets/{id}/revisions/{rev:[0-9]+}", a.handleGetRevision(a.container.revisionGetter)).Methods(http.MethodGet)
+	router.Handle("/v1/planets/{id}/revisions/{rev:[0-9]+}/diff", a.handleDiffRevisions(a.container.revisionGetter)).Methods(http.MethodGet)
+	router.Handle("/v1/planets/{id}/revisions/{rev:[0-9]+}:revert", a.handleRevertPlanet(a.container.planetReverter)).Methods(http.MethodPost)
+	router.Handle("/v1/ws", a.handlePlanetSocket(a.container.planetWatcher)).Methods(http.MethodGet)
+	router.Handle("/v1/admin/cache", a.handleGetCacheStats(a.container.cacheInspector)).Methods(http.MethodGet)
+	router.Handle("/v1/admin/cache:flush", a.handleFlushCache(a.container.cacheFlusher)).Methods(http.MethodPost)
+	router.Handle("/v1/webhooks", a.handleListWebhooks(a.container.webhookLister)).Methods(http.MethodGet)
+	router.Handle("/v1/webhooks", a.handleCreateWebhook(a.container.webhookCreator)).Methods(http.MethodPost)
+	router.Handle("/v1/webhooks/{id}", a.handleGetWebhook(a.container.webhookGetter)).Methods(http.MethodGet)
+	router.Handle("/v1/webhooks/{id}", a.handleUpdateWebhook(a.container.webhookUpdater)).Methods(http.MethodPut)
+	router.Handle("/v1/webhooks/{id}", a.handleDeleteWebhook(a.container.webhookDeleter)).Methods(http.MethodDelete)
+	router.Handle("/v1/webhooks/{id}/deliveries", a.handleListDeliveries(a.container.deliveryLister)).Methods(http.MethodGet)
+	router.Handle("/v1/webhooks/{id}/dead-letters", a.handleListDeadLetters(a.container.deliveryLister)).Methods(http.MethodGet)
+	router.Handle("/v1/webhooks/{id}/dead-letters:replay", a.handleReplayDeadLetters(a.container.deadLetterReplayer)).Methods(http.MethodPost)
+	router.Handle("/v1/webhooks/{id}/dead-letters/{delivery}:replay", a.handleReplayDeadLetter(a.container.deadLetterReplayer)).Methods(http.MethodPost)
 	a.router = &router
 }
 
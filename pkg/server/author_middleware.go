@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net/http"
+
+	"star-wars/pkg/planet"
+)
+
+// AuthorMiddleware records who the request acts for, so the planet changes it
+// makes are stored with their request id and calling application. It must run
+// after RequestIdMiddleware.
+func (a App) AuthorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := planet.WithAuthor(r.Context(), planet.Author{
+			RequestID: requestIdFromContext(r.Context()),
+			Actor:     r.Header.Get(XApplicationId),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"star-wars/pkg/planet"
+	"star-wars/pkg/webhook"
+)
+
+func TestApp_AuthorMiddleware(t *testing.T) {
+	planetService := planet.NewService(planet.NewMemoryRepository())
+	var app App
+	app.container = NewContainer(planetService, webhook.NewService(webhook.NewMemoryRepository(), nil), planet.NewEventHub(1), newPlanetCache(planetService, 0, 0))
+	app.RegisterRoutes()
+
+	req, _ := http.NewRequest("POST", "/v1/planets", strings.NewReader(`{"name":"Mars"}`))
+	req.Header.Set(xRequestIdHeader, "abc123")
+	req.Header.Set(XApplicationId, "rebels")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code creating a planet for test, got %v", rr.Code)
+	}
+
+	var created PlanetDTO
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("unexpected error decoding the created planet: %v", err)
+	}
+	revisions, err := planetService.Revisions(req.Context(), created.ID.Hex())
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("unexpected revisions %v, err %v", revisions, err)
+	}
+	if revisions[0].RequestID != "abc123" || revisions[0].Actor != "rebels" {
+		t.Errorf("unexpected author, want abc123 by rebels got %s by %s", revisions[0].RequestID, revisions[0].Actor)
+	}
+}
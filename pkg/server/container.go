@@ -1,17 +1,80 @@
 package server
 
-import "star-wars/pkg/planet"
+import (
+	"star-wars/pkg/planet"
+	"star-wars/pkg/webhook"
+)
 
 type container struct {
-	planetInserter PlanetInserter
-	planetUpdater  PlanetUpdater
-	planetGetter   PlanetGetter
+	planetInserter     PlanetInserter
+	batchInserter      PlanetBatchInserter
+	planetImporter     PlanetImporter
+	planetExporter     PlanetExporter
+	planetUpdater      PlanetUpdater
+	planetGetter       PlanetGetter
+	planetLister       PlanetLister
+	planetDeleter      PlanetDeleter
+	trashLister        TrashLister
+	planetRestorer     PlanetRestorer
+	planetPurger       PlanetPurger
+	enrichmentRetrier  EnrichmentRetrier
+	planetPatcher      PlanetPatcher
+	revisionLister     RevisionLister
+	revisionGetter     RevisionGetter
+	planetReverter     PlanetReverter
+	historyReader      PlanetHistoryReader
+	eventRelayer       EventRelayer
+	webhookCreator     WebhookCreator
+	webhookLister      WebhookLister
+	webhookGetter      WebhookGetter
+	webhookUpdater     WebhookUpdater
+	webhookDeleter     WebhookDeleter
+	deliveryLister     DeliveryLister
+	deadLetterReplayer DeadLetterReplayer
+	webhookDeliverer   WebhookDeliverer
+	planetWatcher      PlanetWatcher
+	uncachedGetter     PlanetGetter
+	cacheInspector     CacheInspector
+	cacheFlusher       CacheFlusher
+	cacheInvalidator   CacheInvalidator
 }
 
-func NewContainer(planetService *planet.Service) *container {
+// NewContainer wires the services to the handlers. The reads by id are
+// served from the planet cache, and the writes of a single planet go through
+// it so its entry is invalidated. Patches read the planet uncached, their
+// If-Match must be checked against its current version.
+func NewContainer(planetService *planet.Service, webhookService *webhook.Service, planetWatcher PlanetWatcher, planetCache *planetCache) *container {
 	return &container{
-		planetInserter: planetService,
-		planetUpdater:  planetService,
-		planetGetter:   planetService,
+		planetInserter:     planetService,
+		batchInserter:      planetService,
+		planetImporter:     planetService,
+		planetExporter:     planetService,
+		planetUpdater:      planetCache,
+		planetGetter:       planetCache,
+		planetLister:       planetService,
+		planetDeleter:      planetCache,
+		trashLister:        planetService,
+		planetRestorer:     planetCache,
+		planetPurger:       planetService,
+		enrichmentRetrier:  planetService,
+		planetPatcher:      planetCache,
+		revisionLister:     planetService,
+		revisionGetter:     planetService,
+		planetReverter:     planetCache,
+		historyReader:      planetService,
+		eventRelayer:       planetService,
+		webhookCreator:     webhookService,
+		webhookLister:      webhookService,
+		webhookGetter:      webhookService,
+		webhookUpdater:     webhookService,
+		webhookDeleter:     webhookService,
+		deliveryLister:     webhookService,
+		deadLetterReplayer: webhookService,
+		webhookDeliverer:   webhookService,
+		planetWatcher:      planetWatcher,
+		uncachedGetter:     planetService,
+		cacheInspector:     planetCache,
+		cacheFlusher:       planetCache,
+		cacheInvalidator:   planetCache,
 	}
 }
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"star-wars/pkg/planet"
+)
+
+// planetETag is the strong entity tag of a planet, taken from its version.
+func planetETag(p planet.Planet) string {
+	return `"` + strconv.FormatInt(p.Version, 10) + `"`
+}
+
+// revisionETag is the weak entity tag of a planet read at a point in time.
+// It never matches planetETag, so a past planet is not taken for the live one,
+// nor accepted by If-Match to write it.
+func revisionETag(p planet.Planet) string {
+	return `W/"r` + strconv.FormatInt(p.Version, 10) + `"`
+}
+
+// pageETag is a weak entity tag for a page of planets. It changes whenever a
+// planet of the page is written or the page holds different planets. Pages
+// read at a point in time also depend on that time, so they never share a tag
+// with the live pages.
+func pageETag(page planet.Page, asOf time.Time) string {
+	hash := sha1.New()
+	for _, p := range page.Planets {
+		hash.Write([]byte(p.ID.Hex() + ":" + strconv.FormatInt(p.Version, 10) + ";"))
+	}
+	hash.Write([]byte(page.NextCursor))
+	if !asOf.IsZero() {
+		hash.Write([]byte("@" + asOf.UTC().Format(time.RFC3339Nano)))
+	}
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+}
+
+// notModified evaluates If-None-Match and, when it is absent,
+// If-Modified-Since against the current representation. If-None-Match uses
+// the weak comparison, so weak and strong tags of the same version match.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// writeNotModified answers a conditional GET whose representation the client
+// already has. The validators are repeated but the body is not.
+func writeNotModified(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// ifMatchVersion reads the planet version a write is conditioned on. The
+// version is zero when the request has no If-Match header or uses "*". Only a
+// single strong entity tag can match a planet, anything else reports false so
+// the write fails its precondition.
+func ifMatchVersion(r *http.Request) (int64, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+func writeVersionConflict(w http.ResponseWriter) {
+	writeJsonResponse(w, http.StatusPreconditionFailed, errorMessage{ErrorCode: "WA:017", Message: "planet was modified by another request"})
+}
+
+// setMementoDatetime marks a point-in-time response with the time it shows
+// the planets at, the way RFC 7089 marks archived resources, so it is never
+// mistaken for the live planets.
+func setMementoDatetime(w http.ResponseWriter, asOf time.Time) {
+	w.Header().Set("Memento-Datetime", asOf.UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"star-wars/pkg/planet"
+)
+
+// eventHubSize is how many of the latest planet events a stream can resume
+// after when they are fanned out in the process.
+const eventHubSize = 1000
+
+// logEvent logs the planet events relayed from the outbox, so they can be
+// followed without any downstream consumer.
+func logEvent(ctx context.Context, event planet.Event) error {
+	loggerFromContext(ctx).WithField("event_id", event.ID.Hex()).
+		WithField("planet_id", event.PlanetID.Hex()).
+		Debugf("%s planet event published at version %d", event.Type, event.Version)
+	return nil
+}
+
+// encodeWebhookEvent is the body posted to the webhooks for a planet event,
+// the event as the streams send it.
+func encodeWebhookEvent(event planet.Event) ([]byte, error) {
+	return json.Marshal(newPlanetEventDTO(event))
+}
+
+// newPlanetWatcher returns what the planet event streams read from, a hub
+// fanning the events out in the process. With MongoDB it is fed by a single
+// change stream of the planets, shared by every stream of the instance, which
+// sees the changes of every instance. MongoDB always runs as a replica set,
+// the writes need one for their transactions. The memory and SQLite storages
+// have no such stream, the hub is fed by the events relayed from the outbox.
+func (a *App) newPlanetWatcher(planetRepository planet.Repository) PlanetWatcher {
+	hub := planet.NewEventHub(eventHubSize)
+	if mongoRepository, ok := planetRepository.(*planet.MongoRepository); ok {
+		a.changes = a.changesJob(mongoRepository, hub)
+		return hub
+	}
+
+	a.events.Subscribe(hub.Publish)
+	return hub
+}
@@ -3,35 +3,73 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"errors"
 
 	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var govalidator = validator.New()
+var govalidator = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterCustomTypeFunc(optionalValue, optionalInt64{}, optionalFloat64{})
+	v.RegisterValidation("http_url", isHTTPURL)
+	v.RegisterValidation("object_id", isObjectID)
+	return v
+}
+
+// isHTTPURL accepts the absolute http and https URLs, the ones we can post to.
+func isHTTPURL(fl validator.FieldLevel) bool {
+	parsed, err := url.Parse(fl.Field().String())
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// isObjectID accepts the hex ids planets and other documents are known by.
+func isObjectID(fl validator.FieldLevel) bool {
+	return primitive.IsValidObjectID(fl.Field().String())
+}
 
 func decodeAndValidate(w http.ResponseWriter, r *http.Request, dest interface{}) error {
 	if err := json.NewDecoder(r.Body).Decode(dest); err != nil {
 		writeJsonResponse(w, http.StatusBadRequest, errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"})
 		return err
 	}
-	if err := govalidator.Struct(dest); err != nil {
-		var validationErrors validator.ValidationErrors
-		if errors.As(err, &validationErrors) {
-			details := make([]map[string]string, 0, len(validationErrors))
-			for _, v := range validationErrors {
-				details = append(details, map[string]string{"name": v.Field(), "reason": v.Error()})
-			}
-			writeJsonResponse(w, http.StatusUnprocessableEntity, errorMessage{Message: "payload is invalid", ErrorCode: "WA:001", Details: details})
-			return err
-		}
-		writeJsonResponse(w, http.StatusInternalServerError, errorMessage{Message: "failed to validate payload", ErrorCode: "WA:006"})
+	return validate(w, dest)
+}
+
+// validate checks dest against its validator tags and writes the 422 listing
+// every invalid field when it does not pass.
+func validate(w http.ResponseWriter, dest interface{}) error {
+	if code, message, err := validationFailure(dest); err != nil {
+		writeJsonResponse(w, code, message)
 		return err
 	}
 	return nil
 }
 
+// validationFailure checks dest against its validator tags and returns the
+// status and error message a failure should be answered with.
+func validationFailure(dest interface{}) (int, errorMessage, error) {
+	err := govalidator.Struct(dest)
+	if err == nil {
+		return 0, errorMessage{}, nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		details := make([]map[string]string, 0, len(validationErrors))
+		for _, v := range validationErrors {
+			details = append(details, map[string]string{"name": v.Field(), "reason": v.Error()})
+		}
+		return http.StatusUnprocessableEntity, errorMessage{Message: "payload is invalid", ErrorCode: "WA:001", Details: details}, err
+	}
+	return http.StatusInternalServerError, errorMessage{Message: "failed to validate payload", ErrorCode: "WA:006"}, err
+}
+
 type errorMessage struct {
 	ErrorCode string              `json:"error_code,omitempty"`
 	Message   string              `json:"message,omitempty"`
@@ -44,3 +82,23 @@ func writeJsonResponse(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(res)
 }
+
+// writeLinkHeader advertises the first and, when there is one, the next page
+// of a listing as RFC 8288 web links, keeping every other query parameter.
+func writeLinkHeader(w http.ResponseWriter, r *http.Request, nextCursor string) {
+	links := []string{pageLink(r, "", "first")}
+	if nextCursor != "" {
+		links = append(links, pageLink(r, nextCursor, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+func pageLink(r *http.Request, cursor, rel string) string {
+	query := r.URL.Query()
+	query.Del("cursor")
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	target := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return "<" + target.String() + `>; rel="` + rel + `"`
+}
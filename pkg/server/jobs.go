@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	enrichmentRetryBatchSize = 50
+	outboxRelayBatchSize     = 100
+	webhookDeliveryBatchSize = 50
+
+	// rewatchInterval is how soon the planet events are watched again after
+	// their stream ended.
+	rewatchInterval = time.Second
+)
+
+// errChangesEnded is logged when the change stream of the planets ends before
+// it is stopped.
+var errChangesEnded = errors.New("the planet change stream ended")
+
+type PlanetPurger interface {
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+}
+
+type EnrichmentRetrier interface {
+	RetryPendingEnrichment(ctx context.Context, limit int64) (int, error)
+}
+
+type EventRelayer interface {
+	RelayEvents(ctx context.Context, limit int64) (int, error)
+}
+
+type WebhookDeliverer interface {
+	Deliver(ctx context.Context, limit int64) (int, error)
+}
+
+type ChangeRelayer interface {
+	Relay(streamed planet.StreamedEvent)
+}
+
+type CacheInvalidator interface {
+	Invalidate(id primitive.ObjectID)
+	Flush()
+}
+
+func (a *App) startJobs(ctx context.Context) {
+	jobs := a.jobs
+	if retention := viper.GetDuration("PURGE_RETENTION"); retention > 0 {
+		jobs.run(ctx, "purge", viper.GetDuration("PURGE_INTERVAL"), a.purgeJob(a.container.planetPurger, retention))
+	}
+	if viper.GetString("SWAPI_URL") != "" {
+		jobs.run(ctx, "enrichment", viper.GetDuration("ENRICHMENT_RETRY_INTERVAL"), a.enrichmentJob(a.container.enrichmentRetrier))
+	}
+	jobs.run(ctx, "outbox", viper.GetDuration("OUTBOX_RELAY_INTERVAL"), a.outboxJob(a.container.eventRelayer))
+	jobs.run(ctx, "webhooks", viper.GetDuration("WEBHOOK_DELIVERY_INTERVAL"), a.webhookJob(a.container.webhookDeliverer))
+	if a.changes != nil {
+		jobs.run(ctx, "changes", rewatchInterval, a.changes)
+	}
+	if viper.GetInt("PLANET_CACHE_SIZE") > 0 {
+		jobs.run(ctx, "cache", rewatchInterval, a.cacheJob(a.container.planetWatcher, a.container.cacheInvalidator))
+	}
+}
+
+// jobGroup keeps track of the background jobs, so they can be stopped before
+// the storage they write to is closed. It is created with the App, so a
+// shutdown racing the start still finds it, and the jobs started after it
+// was stopped never run.
+type jobGroup struct {
+	mu      sync.Mutex
+	stopped bool
+	cancels []context.CancelFunc
+	running sync.WaitGroup
+}
+
+func newJobGroup() *jobGroup {
+	return &jobGroup{}
+}
+
+// run runs job every interval until ctx is done or the group is stopped.
+func (g *jobGroup) run(ctx context.Context, name string, interval time.Duration, job func(context.Context) error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	g.cancels = append(g.cancels, cancel)
+	g.running.Add(1)
+	go func() {
+		defer g.running.Done()
+		runPeriodically(ctx, name, interval, job)
+	}()
+}
+
+// Stop cancels the jobs and waits for the ones running to return, or for ctx
+// to be done.
+func (g *jobGroup) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	g.stopped = true
+	for _, cancel := range g.cancels {
+		cancel()
+	}
+	g.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		g.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runPeriodically runs job every interval until ctx is done. Failures are
+// logged and the job is simply attempted again on the next tick.
+func runPeriodically(ctx context.Context, name string, interval time.Duration, job func(context.Context) error) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	logger := loggerFromContext(ctx).WithField("job", name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := job(ctx); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+}
+
+func (a *App) purgeJob(planetPurger PlanetPurger, retention time.Duration) func(context.Context) error {
+	return func(ctx context.Context) error {
+		purged, err := planetPurger.Purge(ctx, time.Now().Add(-retention))
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			loggerFromContext(ctx).Infof("purged %d deleted planets", purged)
+		}
+		return nil
+	}
+}
+
+func (a *App) enrichmentJob(enrichmentRetrier EnrichmentRetrier) func(context.Context) error {
+	return func(ctx context.Context) error {
+		enriched, err := enrichmentRetrier.RetryPendingEnrichment(ctx, enrichmentRetryBatchSize)
+		if enriched > 0 {
+			loggerFromContext(ctx).Infof("enriched %d pending planets", enriched)
+		}
+		return err
+	}
+}
+
+// outboxJob publishes the pending planet events, batch after batch until the
+// outbox is drained or a publication fails.
+func (a *App) outboxJob(eventRelayer EventRelayer) func(context.Context) error {
+	return func(ctx context.Context) error {
+		for {
+			published, err := eventRelayer.RelayEvents(ctx, outboxRelayBatchSize)
+			if published > 0 {
+				loggerFromContext(ctx).Debugf("published %d planet events", published)
+			}
+			if err != nil || published < outboxRelayBatchSize {
+				return err
+			}
+		}
+	}
+}
+
+// webhookJob attempts the due webhook deliveries, batch after batch until
+// none is left. Failed deliveries are scheduled again by the webhook service,
+// so only storage failures stop the job.
+func (a *App) webhookJob(webhookDeliverer WebhookDeliverer) func(context.Context) error {
+	return func(ctx context.Context) error {
+		for {
+			delivered, err := webhookDeliverer.Deliver(ctx, webhookDeliveryBatchSize)
+			if delivered > 0 {
+				loggerFromContext(ctx).Debugf("delivered %d webhooks", delivered)
+			}
+			if err != nil || delivered < webhookDeliveryBatchSize {
+				return err
+			}
+		}
+	}
+}
+
+// cacheJob invalidates the cached planets as their events are streamed, until
+// the stream ends. With MongoDB the stream has the changes of every instance,
+// not only the ones this instance relays. The changes made while the stream
+// was down are unknown, so the cache is flushed when it starts or resets.
+func (a *App) cacheJob(planetWatcher PlanetWatcher, cacheInvalidator CacheInvalidator) func(context.Context) error {
+	return func(ctx context.Context) error {
+		events, err := planetWatcher.Watch(ctx, "")
+		if err != nil {
+			return err
+		}
+		cacheInvalidator.Flush()
+
+		for streamed := range events {
+			if streamed.Reset {
+				cacheInvalidator.Flush()
+				continue
+			}
+			cacheInvalidator.Invalidate(streamed.Event.PlanetID)
+		}
+		return nil
+	}
+}
+
+// changesJob relays the changes read from planetWatcher to changeRelayer,
+// until their stream ends, so every planet event stream of the instance
+// shares a single MongoDB change stream. It resumes after the last change
+// relayed, the changes lost in between reaching the streams as a reset.
+func (a *App) changesJob(planetWatcher PlanetWatcher, changeRelayer ChangeRelayer) func(context.Context) error {
+	var last string
+	return func(ctx context.Context) error {
+		events, err := planetWatcher.Watch(ctx, last)
+		if err != nil {
+			return err
+		}
+
+		for streamed := range events {
+			changeRelayer.Relay(streamed)
+			last = streamed.Token
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		return errChangesEnded
+	}
+}
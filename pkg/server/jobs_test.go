@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type planetPurgerMock struct {
+	deletedBefore time.Time
+}
+
+func (a *planetPurgerMock) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	a.deletedBefore = deletedBefore
+	return 1, nil
+}
+
+func TestApp_purgeJob(t *testing.T) {
+	var app App
+	purger := &planetPurgerMock{}
+
+	job := app.purgeJob(purger, 24*time.Hour)
+	if err := job(context.Background()); err != nil {
+		t.Fatalf("purgeJob() unexpected error %v", err)
+	}
+
+	wantCutoff := time.Now().Add(-24 * time.Hour)
+	if diff := wantCutoff.Sub(purger.deletedBefore); diff < 0 || diff > time.Minute {
+		t.Errorf("purgeJob() deletedBefore = %v, want about %v", purger.deletedBefore, wantCutoff)
+	}
+}
+
+type enrichmentRetrierMock struct {
+	enriched int
+	err      error
+}
+
+func (a enrichmentRetrierMock) RetryPendingEnrichment(ctx context.Context, limit int64) (int, error) {
+	return a.enriched, a.err
+}
+
+func TestApp_enrichmentJob(t *testing.T) {
+	var app App
+
+	if err := app.enrichmentJob(enrichmentRetrierMock{enriched: 2})(context.Background()); err != nil {
+		t.Errorf("enrichmentJob() unexpected error %v", err)
+	}
+	if err := app.enrichmentJob(enrichmentRetrierMock{err: errors.New("swapi is down")})(context.Background()); err == nil {
+		t.Errorf("enrichmentJob() expected the upstream error")
+	}
+}
+
+type eventRelayerMock struct {
+	batches []int
+	calls   int
+}
+
+func (a *eventRelayerMock) RelayEvents(ctx context.Context, limit int64) (int, error) {
+	published := a.batches[a.calls]
+	a.calls++
+	return published, nil
+}
+
+func TestApp_outboxJob(t *testing.T) {
+	var app App
+	relayer := &eventRelayerMock{batches: []int{outboxRelayBatchSize, outboxRelayBatchSize, 3}}
+
+	if err := app.outboxJob(relayer)(context.Background()); err != nil {
+		t.Fatalf("outboxJob() unexpected error %v", err)
+	}
+	if relayer.calls != 3 {
+		t.Errorf("outboxJob() relayed %d batches, want 3 until the outbox is drained", relayer.calls)
+	}
+}
+
+// cacheInvalidatorMock records the invalidations, a nil id for a flush.
+type cacheInvalidatorMock struct {
+	invalidated []*primitive.ObjectID
+}
+
+func (a *cacheInvalidatorMock) Invalidate(id primitive.ObjectID) {
+	a.invalidated = append(a.invalidated, &id)
+}
+
+func (a *cacheInvalidatorMock) Flush() {
+	a.invalidated = append(a.invalidated, nil)
+}
+
+func TestApp_cacheJob(t *testing.T) {
+	var app App
+	hoth := primitive.NewObjectID()
+	naboo := primitive.NewObjectID()
+	watcher := &planetWatcherMock{events: make(chan planet.StreamedEvent, 3)}
+	watcher.events <- planet.StreamedEvent{Token: "1", Event: planet.Event{Type: planet.PlanetUpdated, PlanetID: hoth}}
+	watcher.events <- planet.StreamedEvent{Token: "2", Reset: true}
+	watcher.events <- planet.StreamedEvent{Token: "3", Event: planet.Event{Type: planet.PlanetDeleted, PlanetID: naboo}}
+	close(watcher.events)
+	invalidator := &cacheInvalidatorMock{}
+
+	if err := app.cacheJob(watcher, invalidator)(context.Background()); err != nil {
+		t.Fatalf("cacheJob() unexpected error %v", err)
+	}
+	want := []*primitive.ObjectID{nil, &hoth, nil, &naboo}
+	assert.Equal(t, want, invalidator.invalidated, "cacheJob() should flush on start and reset, and invalidate the planet of every event")
+	assert.Equal(t, "", watcher.after, "cacheJob() should watch from now on")
+
+	failing := &planetWatcherMock{err: errors.New("no change stream")}
+	if err := app.cacheJob(failing, invalidator)(context.Background()); err == nil {
+		t.Errorf("cacheJob() expected the watch error")
+	}
+}
+
+type changeRelayerMock struct {
+	relayed []planet.StreamedEvent
+}
+
+func (a *changeRelayerMock) Relay(streamed planet.StreamedEvent) {
+	a.relayed = append(a.relayed, streamed)
+}
+
+func TestApp_changesJob(t *testing.T) {
+	var app App
+	watcher := &planetWatcherMock{events: make(chan planet.StreamedEvent, 2)}
+	watcher.events <- planet.StreamedEvent{Token: "1", Event: planet.Event{Type: planet.PlanetCreated}}
+	watcher.events <- planet.StreamedEvent{Token: "2", Event: planet.Event{Type: planet.PlanetUpdated}}
+	close(watcher.events)
+	relayer := &changeRelayerMock{}
+	job := app.changesJob(watcher, relayer)
+
+	if err := job(context.Background()); !errors.Is(err, errChangesEnded) {
+		t.Errorf("changesJob() errorType = %v, wantErrorType %v", err, errChangesEnded)
+	}
+	assert.Equal(t, "", watcher.after, "changesJob() should first watch from now on")
+	assert.Len(t, relayer.relayed, 2, "changesJob() should relay every change")
+
+	watcher.events = make(chan planet.StreamedEvent)
+	close(watcher.events)
+	job(context.Background())
+	assert.Equal(t, "2", watcher.after, "changesJob() should resume after the last change relayed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := job(ctx); err != nil {
+		t.Errorf("changesJob() a stream stopped with the job should not fail, got %v", err)
+	}
+
+	failing := &planetWatcherMock{err: errors.New("no change stream")}
+	if err := app.changesJob(failing, relayer)(context.Background()); err == nil {
+		t.Errorf("changesJob() expected the watch error")
+	}
+}
+
+func TestApp_Shutdown_stopsJobs(t *testing.T) {
+	jobs := newJobGroup()
+	ctx := context.Background()
+	started := make(chan struct{})
+	var finished int32
+	jobs.run(ctx, "slow", time.Millisecond, func(ctx context.Context) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		// A job still writes a little after it is cancelled.
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		return ctx.Err()
+	})
+	<-started
+
+	var finishedBeforeHook int32
+	app := App{server: &http.Server{}, jobs: jobs}
+	app.onShutdown = append(app.onShutdown, func(ctx context.Context) error {
+		finishedBeforeHook = atomic.LoadInt32(&finished)
+		return nil
+	})
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() unexpected error %v", err)
+	}
+	if finishedBeforeHook != 1 {
+		t.Errorf("Shutdown() ran the hooks before the jobs returned")
+	}
+}
+
+func TestApp_Shutdown_beforeStart(t *testing.T) {
+	app := App{server: &http.Server{Addr: "127.0.0.1:0"}, jobs: newJobGroup(), container: &container{}}
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() unexpected error %v", err)
+	}
+
+	started := make(chan struct{})
+	go func() {
+		app.Start(context.Background())
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Start() should return once the app was shut down")
+	}
+	if len(app.jobs.cancels) > 0 {
+		t.Errorf("Start() should not run the jobs once the app was shut down")
+	}
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	jsonPatchMediaType = "application/json-patch+json"
+)
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// decodeJSONPatch reads a JSON Patch document, which must be an array of
+// operations.
+func decodeJSONPatch(r io.Reader) ([]patchOperation, error) {
+	var operations []patchOperation
+	if err := json.NewDecoder(r).Decode(&operations); err != nil {
+		return nil, err
+	}
+	if operations == nil {
+		return nil, fmt.Errorf("a JSON Patch must be an array of operations")
+	}
+	return operations, nil
+}
+
+// jsonPatchError tells which operation of a JSON Patch could not be applied.
+type jsonPatchError struct {
+	Index      int
+	Op         string
+	Path       string
+	Reason     string
+	FailedTest bool
+}
+
+func (e *jsonPatchError) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Reason)
+}
+
+func (e *jsonPatchError) details() []map[string]string {
+	return []map[string]string{{
+		"index":  strconv.Itoa(e.Index),
+		"op":     e.Op,
+		"path":   e.Path,
+		"reason": e.Reason,
+	}}
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch to document. Operations are
+// applied in order and the first one failing aborts the whole patch, so the
+// caller only ever sees the fully patched document or an error.
+func applyJSONPatch(document interface{}, operations []patchOperation) (interface{}, error) {
+	for i, operation := range operations {
+		var err error
+		document, err = applyOperation(document, operation)
+		if err != nil {
+			patchErr := &jsonPatchError{Index: i, Op: operation.Op, Path: operation.Path, Reason: err.Error()}
+			if _, ok := err.(testFailedError); ok {
+				patchErr.FailedTest = true
+			}
+			return nil, patchErr
+		}
+	}
+	return document, nil
+}
+
+type testFailedError struct{}
+
+func (testFailedError) Error() string {
+	return "tested value does not match"
+}
+
+func applyOperation(document interface{}, operation patchOperation) (interface{}, error) {
+	path, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation.Op {
+	case "add", "replace", "test":
+		value, err := operationValue(operation)
+		if err != nil {
+			return nil, err
+		}
+		switch operation.Op {
+		case "add":
+			return addValue(document, path, value)
+		case "replace":
+			if _, err := getValue(document, path); err != nil {
+				return nil, err
+			}
+			document, _, err = removeValue(document, path)
+			if err != nil {
+				return nil, err
+			}
+			return addValue(document, path, value)
+		default:
+			current, err := getValue(document, path)
+			if err != nil {
+				return nil, err
+			}
+			if !jsonEqual(current, value) {
+				return nil, testFailedError{}
+			}
+			return document, nil
+		}
+	case "remove":
+		document, _, err = removeValue(document, path)
+		return document, err
+	case "move", "copy":
+		from, err := parsePointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := getValue(document, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		if operation.Op == "move" {
+			if isPrefix(from, path) {
+				return nil, fmt.Errorf("cannot move a value into one of its children")
+			}
+			if document, _, err = removeValue(document, from); err != nil {
+				return nil, err
+			}
+		} else if value, err = deepCopy(value); err != nil {
+			return nil, err
+		}
+		return addValue(document, path, value)
+	}
+
+	return nil, fmt.Errorf("unknown operation '%s'", operation.Op)
+}
+
+func operationValue(operation patchOperation) (interface{}, error) {
+	if len(operation.Value) == 0 {
+		return nil, fmt.Errorf("value is required")
+	}
+	var value interface{}
+	err := json.Unmarshal(operation.Value, &value)
+	return value, err
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path must start with '/'")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func getValue(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			node = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[index]
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	}
+	return node, nil
+}
+
+// addValue returns node with value added at path. Containers are returned
+// rather than changed in place because inserting into an array may move it.
+func addValue(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("path not found")
+		}
+		updated, err := addValue(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+	case []interface{}:
+		if len(rest) == 0 {
+			index := len(n)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(n)); err != nil {
+					return nil, err
+				}
+			}
+			n = append(n, nil)
+			copy(n[index+1:], n[index:])
+			n[index] = value
+			return n, nil
+		}
+		index, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := addValue(n[index], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[index] = updated
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("path not found")
+}
+
+func removeValue(node interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("path not found")
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+		updated, removed, err := removeValue(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = updated
+		return n, removed, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[index]
+			return append(n[:index], n[index+1:]...), removed, nil
+		}
+		updated, removed, err := removeValue(n[index], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[index] = updated
+		return n, removed, nil
+	}
+
+	return nil, nil, fmt.Errorf("path not found")
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d out of bounds", index)
+	}
+	return index, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func deepCopy(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var copied interface{}
+	err = json.Unmarshal(raw, &copied)
+	return copied, err
+}
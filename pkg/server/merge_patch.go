@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+)
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+)
+
+// mergePatch applies an RFC 7396 JSON Merge Patch to target: null removes a
+// member, objects are merged recursively and anything else replaces the
+// target value.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// toJSONDocument renders v the way it is sent over the wire, as generic JSON
+// values patches can be applied to.
+func toJSONDocument(v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	err = json.Unmarshal(raw, &document)
+	return document, err
+}
+
+// fromJSONDocument decodes a patched document into dest, rejecting members
+// dest does not know about.
+func fromJSONDocument(document interface{}, dest interface{}) error {
+	raw, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(dest)
+}
+
+// changedMembers lists the top-level members whose value differs between two
+// documents, in a stable order.
+func changedMembers(before, after map[string]interface{}) []string {
+	changed := []string{}
+	for key, value := range after {
+		if previous, ok := before[key]; !ok || !jsonEqual(previous, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func jsonEqual(a, b interface{}) bool {
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+func mediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType
+}
+
+func decodeJSONDocument(body io.Reader) (interface{}, error) {
+	var document interface{}
+	err := json.NewDecoder(body).Decode(&document)
+	return document, err
+}
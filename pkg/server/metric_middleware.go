@@ -1,6 +1,9 @@
 package server
 
 import (
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -42,6 +45,27 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush lets streaming handlers flush through the wrapper.
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSockets take the connection over through the wrapper. The
+// request is counted as switching protocols.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err == nil {
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
 func (a App) HTTPServerMetricMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		route := mux.CurrentRoute(r)
@@ -56,6 +80,23 @@ func (a App) HTTPServerMetricMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// metricTransport counts the requests this service makes to other services.
+type metricTransport struct {
+	next http.RoundTripper
+}
+
+func (t metricTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+
+	code := errorLevelLabelValue
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+	httpRequestsTotalIncrement(strings.ToLower(req.Method), req.URL.Host+req.URL.Path, code, outgoingHTTPRequestKindLabelValue)
+
+	return res, err
+}
+
 func httpRequestsTotalIncrement(method, path, code, kind string) {
 	getHTTPRequestsTotalCounterInstance().With(prometheus.Labels{
 		httpMethodLabelKey: method,
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// unknownValue is how SWAPI represents attributes nobody has measured.
+const unknownValue = "unknown"
+
+// optionalInt64 is an integer attribute that may be unknown. It decodes from
+// a number, null or "unknown" and always encodes an unknown value as "unknown".
+type optionalInt64 struct {
+	value *int64
+}
+
+func (o optionalInt64) MarshalJSON() ([]byte, error) {
+	if o.value == nil {
+		return json.Marshal(unknownValue)
+	}
+	return []byte(strconv.FormatInt(*o.value, 10)), nil
+}
+
+func (o *optionalInt64) UnmarshalJSON(data []byte) error {
+	o.value = nil
+	if isUnknownJSON(data) {
+		return nil
+	}
+	var value int64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("expected an integer or %q: %w", unknownValue, err)
+	}
+	o.value = &value
+	return nil
+}
+
+// optionalFloat64 is the decimal counterpart of optionalInt64.
+type optionalFloat64 struct {
+	value *float64
+}
+
+func (o optionalFloat64) MarshalJSON() ([]byte, error) {
+	if o.value == nil {
+		return json.Marshal(unknownValue)
+	}
+	return []byte(strconv.FormatFloat(*o.value, 'f', -1, 64)), nil
+}
+
+func (o *optionalFloat64) UnmarshalJSON(data []byte) error {
+	o.value = nil
+	if isUnknownJSON(data) {
+		return nil
+	}
+	var value float64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("expected a number or %q: %w", unknownValue, err)
+	}
+	o.value = &value
+	return nil
+}
+
+func isUnknownJSON(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return bytes.Equal(data, []byte("null")) || bytes.Equal(data, []byte(`"`+unknownValue+`"`))
+}
+
+// optionalValue exposes the known value to the validator, so that tags such
+// as min and max apply to it, and nil so that omitempty skips unknown ones.
+func optionalValue(field reflect.Value) interface{} {
+	switch v := field.Interface().(type) {
+	case optionalInt64:
+		if v.value != nil {
+			return *v.value
+		}
+	case optionalFloat64:
+		if v.value != nil {
+			return *v.value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	resultLabelKey = "result"
+	reasonLabelKey = "reason"
+
+	cacheHitLabelValue  = "hit"
+	cacheMissLabelValue = "miss"
+	capacityLabelValue  = "capacity"
+	expiredLabelValue   = "expired"
+)
+
+var (
+	promCacheLookupsCounter   *prometheus.CounterVec
+	promCacheEvictionsCounter *prometheus.CounterVec
+	onceCacheMetrics          sync.Once
+)
+
+// cachedPlanets is what planetCache reads planets from and writes them to.
+type cachedPlanets interface {
+	PlanetGetter
+	PlanetUpdater
+	PlanetPatcher
+	PlanetDeleter
+	PlanetRestorer
+	PlanetReverter
+}
+
+// planetCache reads the planets by id through a bounded LRU cache whose
+// entries expire after a TTL, zero meaning never. The planets written
+// through it have their entry invalidated right away, and the ones changed
+// elsewhere once their event is streamed, see cacheJob. A size of zero
+// disables caching.
+type planetCache struct {
+	planets cachedPlanets
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[primitive.ObjectID]*list.Element
+	// recent orders the entries from the most to the least recently used.
+	recent *list.List
+	// generation changes with every invalidation, so a planet read before
+	// one is not cached after it.
+	generation uint64
+
+	hits, misses, evictions int64
+}
+
+type cacheEntry struct {
+	id        primitive.ObjectID
+	planet    planet.Planet
+	expiresAt time.Time
+}
+
+// cacheStats tells how full and how useful the cache is.
+type cacheStats struct {
+	Size      int
+	Capacity  int
+	TTL       time.Duration
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+func newPlanetCache(planets cachedPlanets, size int, ttl time.Duration) *planetCache {
+	return &planetCache{
+		planets: planets,
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[primitive.ObjectID]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+func planetCacheFromConfig(planets cachedPlanets) *planetCache {
+	return newPlanetCache(planets, viper.GetInt("PLANET_CACHE_SIZE"), viper.GetDuration("PLANET_CACHE_TTL"))
+}
+
+// GetByID returns the cached planet, or reads and caches it. Failures are
+// not cached.
+func (c *planetCache) GetByID(ctx context.Context, id string) (planet.Planet, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return c.planets.GetByID(ctx, id)
+	}
+
+	cached, generation, ok := c.lookup(objectID)
+	if ok {
+		return cached, nil
+	}
+
+	got, err := c.planets.GetByID(ctx, id)
+	if err != nil {
+		return got, err
+	}
+	c.store(objectID, got, generation)
+	return got, nil
+}
+
+func (c *planetCache) Update(ctx context.Context, planetDocument planet.Planet) (planet.Planet, error) {
+	updated, err := c.planets.Update(ctx, planetDocument)
+	c.invalidate(planetDocument.ID)
+	return updated, err
+}
+
+func (c *planetCache) Patch(ctx context.Context, id string, changes planet.Planet, fields []string) (planet.Planet, error) {
+	patched, err := c.planets.Patch(ctx, id, changes, fields)
+	c.invalidateHex(id)
+	return patched, err
+}
+
+func (c *planetCache) Revert(ctx context.Context, id string, version int64, expected int64) (planet.Planet, error) {
+	reverted, err := c.planets.Revert(ctx, id, version, expected)
+	c.invalidateHex(id)
+	return reverted, err
+}
+
+func (c *planetCache) Delete(ctx context.Context, id string) error {
+	err := c.planets.Delete(ctx, id)
+	c.invalidateHex(id)
+	return err
+}
+
+func (c *planetCache) Restore(ctx context.Context, id string) (planet.Planet, error) {
+	restored, err := c.planets.Restore(ctx, id)
+	c.invalidateHex(id)
+	return restored, err
+}
+
+// Invalidate drops a planet changed without going through the cache, by an
+// enrichment, an import or another instance.
+func (c *planetCache) Invalidate(id primitive.ObjectID) {
+	c.invalidate(id)
+}
+
+// Flush drops every cached planet.
+func (c *planetCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[primitive.ObjectID]*list.Element{}
+	c.recent.Init()
+	c.generation++
+}
+
+func (c *planetCache) Stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return cacheStats{
+		Size:      c.recent.Len(),
+		Capacity:  c.size,
+		TTL:       c.ttl,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// lookup returns a copy of the cached planet, and else the generation to
+// store the planet read instead with.
+func (c *planetCache) lookup(id primitive.ObjectID) (planet.Planet, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		entry := element.Value.(*cacheEntry)
+		if entry.expiresAt.IsZero() || c.now().Before(entry.expiresAt) {
+			c.recent.MoveToFront(element)
+			c.hits++
+			cacheLookupsIncrement(cacheHitLabelValue)
+			return entry.planet.Clone(), c.generation, true
+		}
+		c.evict(element, expiredLabelValue)
+	}
+
+	c.misses++
+	cacheLookupsIncrement(cacheMissLabelValue)
+	return planet.Planet{}, c.generation, false
+}
+
+// store caches a planet unless an invalidation happened since the
+// generation it was read at, evicting the least recently used planet when
+// the cache is full.
+func (c *planetCache) store(id primitive.ObjectID, got planet.Planet, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 || generation != c.generation {
+		return
+	}
+
+	entry := &cacheEntry{id: id, planet: got.Clone()}
+	if c.ttl > 0 {
+		entry.expiresAt = c.now().Add(c.ttl)
+	}
+	if element, ok := c.entries[id]; ok {
+		element.Value = entry
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.entries[id] = c.recent.PushFront(entry)
+	if c.recent.Len() > c.size {
+		c.evict(c.recent.Back(), capacityLabelValue)
+	}
+}
+
+func (c *planetCache) invalidateHex(id string) {
+	if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+		c.invalidate(objectID)
+	}
+}
+
+func (c *planetCache) invalidate(id primitive.ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		c.recent.Remove(element)
+		delete(c.entries, id)
+	}
+	c.generation++
+}
+
+// evict drops an entry for the given reason. The caller must hold the lock.
+func (c *planetCache) evict(element *list.Element, reason string) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).id)
+	c.evictions++
+	_, evictions := getCacheMetricsInstance()
+	evictions.With(prometheus.Labels{reasonLabelKey: reason}).Inc()
+}
+
+func cacheLookupsIncrement(result string) {
+	lookups, _ := getCacheMetricsInstance()
+	lookups.With(prometheus.Labels{resultLabelKey: result}).Inc()
+}
+
+func getCacheMetricsInstance() (*prometheus.CounterVec, *prometheus.CounterVec) {
+	onceCacheMetrics.Do(func() {
+		constLabels := prometheus.Labels{
+			environmentLabelKey: viper.GetString("ENVIRONMENT"),
+			appNameLabelKey:     viper.GetString("APP_NAME"),
+		}
+		promCacheLookupsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:        "planet_cache_lookups_total",
+			Help:        "Total number of planets looked up in the cache, by hit or miss.",
+			ConstLabels: constLabels,
+		}, []string{resultLabelKey})
+		promCacheEvictionsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:        "planet_cache_evictions_total",
+			Help:        "Total number of planets evicted from the cache, by capacity or expiry.",
+			ConstLabels: constLabels,
+		}, []string{reasonLabelKey})
+	})
+
+	return promCacheLookupsCounter, promCacheEvictionsCounter
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cachedPlanetsMock serves the planets it holds and counts the reads. Its
+// writes only succeed.
+type cachedPlanetsMock struct {
+	planets map[string]planet.Planet
+	reads   int
+	// whileReading runs in the middle of a read, as a concurrent write would.
+	whileReading func()
+}
+
+func (a *cachedPlanetsMock) GetByID(ctx context.Context, id string) (planet.Planet, error) {
+	a.reads++
+	if a.whileReading != nil {
+		a.whileReading()
+	}
+	got, ok := a.planets[id]
+	if !ok {
+		return planet.Planet{}, planet.ErrPlanetNotFound
+	}
+	return got, nil
+}
+
+func (a *cachedPlanetsMock) Update(ctx context.Context, planetDocument planet.Planet) (planet.Planet, error) {
+	return planetDocument, nil
+}
+
+func (a *cachedPlanetsMock) Patch(ctx context.Context, id string, changes planet.Planet, fields []string) (planet.Planet, error) {
+	return changes, nil
+}
+
+func (a *cachedPlanetsMock) Delete(ctx context.Context, id string) error {
+	return nil
+}
+
+func (a *cachedPlanetsMock) Restore(ctx context.Context, id string) (planet.Planet, error) {
+	return planet.Planet{}, nil
+}
+
+func (a *cachedPlanetsMock) Revert(ctx context.Context, id string, version int64, expected int64) (planet.Planet, error) {
+	return planet.Planet{}, nil
+}
+
+const (
+	hothID  = "5f165e2e4de9b442e60b3904"
+	nabooID = "5f165e2e4de9b442e60b3905"
+	tatooID = "5f165e2e4de9b442e60b3906"
+)
+
+func newCachedPlanetsMock() *cachedPlanetsMock {
+	planets := map[string]planet.Planet{}
+	for name, id := range map[string]string{"Hoth": hothID, "Naboo": nabooID, "Tatooine": tatooID} {
+		objectID, _ := primitive.ObjectIDFromHex(id)
+		planets[id] = planet.Planet{ID: objectID, Name: name, Climate: []string{"frozen"}}
+	}
+	return &cachedPlanetsMock{planets: planets}
+}
+
+func Test_planetCache_GetByID(t *testing.T) {
+	t.Parallel()
+
+	t.Run("when a planet is read twice, then it should be read once and copied", func(t *testing.T) {
+		planets := newCachedPlanetsMock()
+		cache := newPlanetCache(planets, 10, time.Minute)
+
+		first, _ := cache.GetByID(context.Background(), hothID)
+		first.Climate[0] = "temperate"
+		second, err := cache.GetByID(context.Background(), hothID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "frozen", second.Climate[0])
+		assert.Equal(t, 1, planets.reads)
+		assert.Equal(t, cacheStats{Size: 1, Capacity: 10, TTL: time.Minute, Hits: 1, Misses: 1}, cache.Stats())
+	})
+
+	t.Run("when a planet expired, then it should be read again", func(t *testing.T) {
+		planets := newCachedPlanetsMock()
+		cache := newPlanetCache(planets, 10, time.Minute)
+		now := time.Date(2021, 12, 27, 10, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+
+		cache.GetByID(context.Background(), hothID)
+		now = now.Add(time.Minute)
+		cache.GetByID(context.Background(), hothID)
+
+		assert.Equal(t, 2, planets.reads)
+		assert.Equal(t, cacheStats{Size: 1, Capacity: 10, TTL: time.Minute, Misses: 2, Evictions: 1}, cache.Stats())
+	})
+
+	t.Run("when the cache is full, then it should evict the least recently used planet", func(t *testing.T) {
+		planets := newCachedPlanetsMock()
+		cache := newPlanetCache(planets, 2, 0)
+
+		for _, id := range []string{hothID, nabooID, hothID, tatooID, hothID, nabooID} {
+			cache.GetByID(context.Background(), id)
+		}
+
+		assert.Equal(t, 4, planets.reads, "Naboo should be evicted by Tatooine, and read again")
+		assert.Equal(t, cacheStats{Size: 2, Capacity: 2, Hits: 2, Misses: 4, Evictions: 2}, cache.Stats())
+	})
+
+	t.Run("when a planet cannot be read, then it should not be cached", func(t *testing.T) {
+		planets := newCachedPlanetsMock()
+		cache := newPlanetCache(planets, 10, 0)
+
+		cache.GetByID(context.Background(), "5f165e2e4de9b442e60b3999")
+		_, err := cache.GetByID(context.Background(), "5f165e2e4de9b442e60b3999")
+
+		assert.True(t, errors.Is(err, planet.ErrPlanetNotFound))
+		assert.Equal(t, 2, planets.reads)
+	})
+
+	t.Run("when the size is zero, then it should cache nothing", func(t *testing.T) {
+		planets := newCachedPlanetsMock()
+		cache := newPlanetCache(planets, 0, 0)
+
+		cache.GetByID(context.Background(), hothID)
+		cache.GetByID(context.Background(), hothID)
+
+		assert.Equal(t, 2, planets.reads)
+	})
+
+	t.Run("when a planet is invalidated while it is read, then it should not be cached", func(t *testing.T) {
+		planets := newCachedPlanetsMock()
+		cache := newPlanetCache(planets, 10, 0)
+		planets.whileReading = func() {
+			planets.whileReading = nil
+			cache.Delete(context.Background(), hothID)
+		}
+
+		cache.GetByID(context.Background(), hothID)
+		cache.GetByID(context.Background(), hothID)
+
+		assert.Equal(t, 2, planets.reads)
+	})
+}
+
+func Test_planetCache_invalidation(t *testing.T) {
+	t.Parallel()
+
+	hoth, _ := primitive.ObjectIDFromHex(hothID)
+	tests := []struct {
+		name  string
+		write func(cache *planetCache)
+	}{
+		{
+			name:  "when a planet is updated, then it should be read again",
+			write: func(cache *planetCache) { cache.Update(context.Background(), planet.Planet{ID: hoth}) },
+		},
+		{
+			name:  "when a planet is patched, then it should be read again",
+			write: func(cache *planetCache) { cache.Patch(context.Background(), hothID, planet.Planet{}, nil) },
+		},
+		{
+			name:  "when a planet is reverted, then it should be read again",
+			write: func(cache *planetCache) { cache.Revert(context.Background(), hothID, 1, 0) },
+		},
+		{
+			name:  "when a planet is deleted, then it should be read again",
+			write: func(cache *planetCache) { cache.Delete(context.Background(), hothID) },
+		},
+		{
+			name:  "when a planet is restored, then it should be read again",
+			write: func(cache *planetCache) { cache.Restore(context.Background(), hothID) },
+		},
+		{
+			name:  "when the planet is changed elsewhere, then it should be read again",
+			write: func(cache *planetCache) { cache.Invalidate(hoth) },
+		},
+		{
+			name:  "when the cache is flushed, then it should be read again",
+			write: func(cache *planetCache) { cache.Flush() },
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			planets := newCachedPlanetsMock()
+			cache := newPlanetCache(planets, 10, 0)
+			cache.GetByID(context.Background(), hothID)
+			cache.GetByID(context.Background(), nabooID)
+
+			tc.write(cache)
+			cache.GetByID(context.Background(), hothID)
+			cache.GetByID(context.Background(), hothID)
+
+			assert.Equal(t, 3, planets.reads)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/spf13/viper"
+)
+
+var (
+	promCoalescedLookupsCounter prometheus.Counter
+	onceCoalescedLookupsCounter sync.Once
+)
+
+// planetCoalescer collapses the concurrent reads of a planet by id into a
+// single read, whose result every caller shares. The read is made on behalf
+// of all of them: a caller giving up only stops waiting for it, and it is
+// cancelled once every caller gave up. A read in progress may return a
+// planet as it was before a write, so the reads after a write made through
+// the coalescer start a read of their own.
+type planetCoalescer struct {
+	cachedPlanets
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a read of a planet in progress.
+type flight struct {
+	done    chan struct{}
+	planet  planet.Planet
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newPlanetCoalescer(planets cachedPlanets) *planetCoalescer {
+	return &planetCoalescer{
+		cachedPlanets: planets,
+		flights:       map[string]*flight{},
+	}
+}
+
+// GetByID joins the read of the planet in progress, or starts one. It
+// returns as soon as ctx is done, without waiting for the read.
+func (c *planetCoalescer) GetByID(ctx context.Context, id string) (planet.Planet, error) {
+	c.mu.Lock()
+	f, ok := c.flights[id]
+	if ok {
+		f.waiters++
+		c.mu.Unlock()
+		getCoalescedLookupsCounterInstance().Inc()
+	} else {
+		flightCtx, cancel := context.WithCancel(detachedContext{ctx})
+		f = &flight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		c.flights[id] = f
+		c.mu.Unlock()
+		go c.fly(flightCtx, id, f)
+	}
+
+	select {
+	case <-f.done:
+		return f.planet.Clone(), f.err
+	case <-ctx.Done():
+		c.leave(id, f)
+		return planet.Planet{}, ctx.Err()
+	}
+}
+
+// fly reads the planet for the callers of a flight. A read that panics fails
+// for all of them instead of bringing the server down, its goroutine not
+// being one the HTTP server recovers.
+func (c *planetCoalescer) fly(ctx context.Context, id string, f *flight) {
+	defer func() {
+		if r := recover(); r != nil {
+			loggerFromContext(ctx).Errorf("planet read panicked: %v\n%s", r, debug.Stack())
+			f.planet, f.err = planet.Planet{}, fmt.Errorf("planet read panicked: %v", r)
+		}
+
+		c.forget(id, f)
+		f.cancel()
+		close(f.done)
+	}()
+
+	f.planet, f.err = c.cachedPlanets.GetByID(ctx, id)
+}
+
+// leave stops a caller waiting for a read, and cancels the read when no one
+// waits for it anymore.
+func (c *planetCoalescer) leave(id string, f *flight) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f.waiters--
+	if f.waiters == 0 {
+		f.cancel()
+		if c.flights[id] == f {
+			delete(c.flights, id)
+		}
+	}
+}
+
+// forget makes the next reads of a planet start afresh instead of joining
+// the read in progress. With a flight given, only that one is forgotten, not
+// a newer one.
+func (c *planetCoalescer) forget(id string, f *flight) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if current, ok := c.flights[id]; ok && (f == nil || current == f) {
+		delete(c.flights, id)
+	}
+}
+
+func (c *planetCoalescer) Update(ctx context.Context, planetDocument planet.Planet) (planet.Planet, error) {
+	updated, err := c.cachedPlanets.Update(ctx, planetDocument)
+	c.forget(planetDocument.ID.Hex(), nil)
+	return updated, err
+}
+
+func (c *planetCoalescer) Patch(ctx context.Context, id string, changes planet.Planet, fields []string) (planet.Planet, error) {
+	patched, err := c.cachedPlanets.Patch(ctx, id, changes, fields)
+	c.forget(id, nil)
+	return patched, err
+}
+
+func (c *planetCoalescer) Revert(ctx context.Context, id string, version int64, expected int64) (planet.Planet, error) {
+	reverted, err := c.cachedPlanets.Revert(ctx, id, version, expected)
+	c.forget(id, nil)
+	return reverted, err
+}
+
+func (c *planetCoalescer) Delete(ctx context.Context, id string) error {
+	err := c.cachedPlanets.Delete(ctx, id)
+	c.forget(id, nil)
+	return err
+}
+
+func (c *planetCoalescer) Restore(ctx context.Context, id string) (planet.Planet, error) {
+	restored, err := c.cachedPlanets.Restore(ctx, id)
+	c.forget(id, nil)
+	return restored, err
+}
+
+// detachedContext keeps the values of a context, like its logger, but not
+// its cancellation nor its deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+func getCoalescedLookupsCounterInstance() prometheus.Counter {
+	onceCoalescedLookupsCounter.Do(func() {
+		promCoalescedLookupsCounter = promauto.NewCounter(prometheus.CounterOpts{
+			Name: "planet_lookups_coalesced_total",
+			Help: "Total number of planet reads by id answered by joining a read already in progress.",
+			ConstLabels: prometheus.Labels{
+				environmentLabelKey: viper.GetString("ENVIRONMENT"),
+				appNameLabelKey:     viper.GetString("APP_NAME"),
+			},
+		})
+	})
+
+	return promCoalescedLookupsCounter
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowPlanetsMock holds every read until it is released, and counts them.
+type slowPlanetsMock struct {
+	cachedPlanetsMock
+	started chan context.Context
+	release chan struct{}
+
+	mu    sync.Mutex
+	reads int
+}
+
+func newSlowPlanetsMock() *slowPlanetsMock {
+	return &slowPlanetsMock{
+		cachedPlanetsMock: *newCachedPlanetsMock(),
+		started:           make(chan context.Context, 10),
+		release:           make(chan struct{}),
+	}
+}
+
+func (a *slowPlanetsMock) GetByID(ctx context.Context, id string) (planet.Planet, error) {
+	a.mu.Lock()
+	a.reads++
+	a.mu.Unlock()
+	a.started <- ctx
+
+	select {
+	case <-a.release:
+		return a.planets[id], nil
+	case <-ctx.Done():
+		return planet.Planet{}, ctx.Err()
+	}
+}
+
+// panickingPlanetsMock panics on every read once it is released.
+type panickingPlanetsMock struct {
+	cachedPlanetsMock
+	release chan struct{}
+}
+
+func (a *panickingPlanetsMock) GetByID(ctx context.Context, id string) (planet.Planet, error) {
+	<-a.release
+	panic("nil map")
+}
+
+type lookup struct {
+	planet planet.Planet
+	err    error
+}
+
+// lookupAsync reads a planet in the background.
+func lookupAsync(ctx context.Context, getter PlanetGetter, id string) <-chan lookup {
+	result := make(chan lookup, 1)
+	go func() {
+		got, err := getter.GetByID(ctx, id)
+		result <- lookup{got, err}
+	}()
+	return result
+}
+
+// waitForWaiters waits until the read of the planet in progress has that many
+// callers waiting for it.
+func waitForWaiters(t *testing.T, coalescer *planetCoalescer, id string, waiters int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		coalescer.mu.Lock()
+		f, ok := coalescer.flights[id]
+		joined := ok && f.waiters == waiters
+		coalescer.mu.Unlock()
+		if joined {
+			return
+		}
+	}
+	t.Fatalf("the read of %s never had %d callers", id, waiters)
+}
+
+func Test_planetCoalescer_GetByID(t *testing.T) {
+	t.Run("when a planet is read concurrently, then it should be read once for every caller", func(t *testing.T) {
+		planets := newSlowPlanetsMock()
+		coalescer := newPlanetCoalescer(planets)
+		coalesced := testutil.ToFloat64(getCoalescedLookupsCounterInstance())
+
+		var results []<-chan lookup
+		for i := 0; i < 3; i++ {
+			results = append(results, lookupAsync(context.Background(), coalescer, hothID))
+		}
+		<-planets.started
+		waitForWaiters(t, coalescer, hothID, 3)
+		close(planets.release)
+
+		for _, result := range results {
+			got := <-result
+			assert.NoError(t, got.err)
+			assert.Equal(t, "Hoth", got.planet.Name)
+		}
+		assert.Equal(t, 1, planets.reads)
+		assert.Equal(t, float64(2), testutil.ToFloat64(getCoalescedLookupsCounterInstance())-coalesced)
+	})
+
+	t.Run("when a caller gives up, then it should return at once and the others should still get the planet", func(t *testing.T) {
+		planets := newSlowPlanetsMock()
+		coalescer := newPlanetCoalescer(planets)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		impatient := lookupAsync(ctx, coalescer, hothID)
+		readCtx := <-planets.started
+		patient := lookupAsync(context.Background(), coalescer, hothID)
+		waitForWaiters(t, coalescer, hothID, 2)
+
+		cancel()
+		got := <-impatient
+		assert.True(t, errors.Is(got.err, context.Canceled))
+		assert.NoError(t, readCtx.Err(), "the read should go on for the other caller")
+
+		close(planets.release)
+		got = <-patient
+		assert.NoError(t, got.err)
+		assert.Equal(t, "Hoth", got.planet.Name)
+	})
+
+	t.Run("when every caller gives up, then it should cancel the read", func(t *testing.T) {
+		planets := newSlowPlanetsMock()
+		coalescer := newPlanetCoalescer(planets)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		result := lookupAsync(ctx, coalescer, hothID)
+		readCtx := <-planets.started
+		cancel()
+
+		assert.True(t, errors.Is((<-result).err, context.Canceled))
+		select {
+		case <-readCtx.Done():
+		case <-time.After(5 * time.Second):
+			t.Errorf("the read should be cancelled")
+		}
+	})
+
+	t.Run("when a planet is written during a read, then the reads after it should not join it", func(t *testing.T) {
+		planets := newSlowPlanetsMock()
+		coalescer := newPlanetCoalescer(planets)
+
+		before := lookupAsync(context.Background(), coalescer, hothID)
+		<-planets.started
+		coalescer.Delete(context.Background(), hothID)
+		after := lookupAsync(context.Background(), coalescer, hothID)
+		<-planets.started
+		close(planets.release)
+
+		<-before
+		<-after
+		assert.Equal(t, 2, planets.reads)
+	})
+
+	t.Run("when the read panics, then every caller should get an error", func(t *testing.T) {
+		planets := &panickingPlanetsMock{cachedPlanetsMock: *newCachedPlanetsMock(), release: make(chan struct{})}
+		coalescer := newPlanetCoalescer(planets)
+
+		first := lookupAsync(context.Background(), coalescer, hothID)
+		second := lookupAsync(context.Background(), coalescer, hothID)
+		waitForWaiters(t, coalescer, hothID, 2)
+		close(planets.release)
+
+		for _, result := range []lookup{<-first, <-second} {
+			if assert.Error(t, result.err) {
+				assert.Contains(t, result.err.Error(), "nil map")
+			}
+		}
+		assert.Empty(t, coalescer.flights, "the failed read should be forgotten")
+	})
+}
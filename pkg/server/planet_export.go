@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"star-wars/pkg/planet"
+)
+
+// exportFlushEvery is how many planets are written between two flushes, so
+// the client receives the export while it is being read.
+const exportFlushEvery = 100
+
+// exportMediaTypes are the export formats, in order of preference.
+var exportMediaTypes = []string{ndjsonMediaType, csvMediaType}
+
+// exportColumns are the CSV columns, named like the import expects them.
+var exportColumns = []string{"id", "name", "rotation_period", "orbital_period", "diameter", "climate", "gravity", "terrain", "surface_water", "population", "film_count"}
+
+// negotiateExport picks the export format from the Accept header. Without
+// Accept, or when both are accepted equally, NDJSON is used.
+func negotiateExport(r *http.Request) (string, bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return exportMediaTypes[0], true
+	}
+
+	best, bestQuality := "", 0.0
+	for _, offer := range exportMediaTypes {
+		if quality := acceptQuality(accept, offer); quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best, best != ""
+}
+
+// acceptQuality is the quality the Accept header gives to mediaType, taken
+// from its most specific matching range.
+func acceptQuality(accept, mediaType string) float64 {
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		var matched int
+		switch {
+		case accepted == mediaType:
+			matched = 2
+		case accepted == "*/*":
+			matched = 0
+		case strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*")):
+			matched = 1
+		default:
+			continue
+		}
+		if matched <= specificity {
+			continue
+		}
+
+		specificity, quality = matched, 1
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+	}
+	return quality
+}
+
+// exportWriter writes planets in the negotiated format. flush pushes what was
+// written so far to the client.
+type exportWriter interface {
+	write(p planet.Planet) error
+	flush() error
+}
+
+func newExportWriter(w http.ResponseWriter, mediaType string) exportWriter {
+	if mediaType == csvMediaType {
+		return &csvExportWriter{w: w, writer: csv.NewWriter(w)}
+	}
+	return &ndjsonExportWriter{w: w, encoder: json.NewEncoder(w)}
+}
+
+type ndjsonExportWriter struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+}
+
+func (e *ndjsonExportWriter) write(p planet.Planet) error {
+	return e.encoder.Encode(newPlanetDTO(p))
+}
+
+func (e *ndjsonExportWriter) flush() error {
+	flushResponse(e.w)
+	return nil
+}
+
+type csvExportWriter struct {
+	w             http.ResponseWriter
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (e *csvExportWriter) write(p planet.Planet) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	dto := newPlanetDTO(p)
+	return e.writer.Write([]string{
+		dto.ID.Hex(),
+		dto.Name,
+		csvCell(dto.RotationPeriod),
+		csvCell(dto.OrbitalPeriod),
+		csvCell(dto.Diameter),
+		strings.Join(dto.Climate, ", "),
+		csvCell(dto.Gravity),
+		strings.Join(dto.Terrain, ", "),
+		csvCell(dto.SurfaceWater),
+		csvCell(dto.Population),
+		strconv.Itoa(dto.FilmCount),
+	})
+}
+
+// flush also writes the header, so an empty export still has its columns.
+func (e *csvExportWriter) flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	flushResponse(e.w)
+	return e.writer.Error()
+}
+
+func (e *csvExportWriter) writeHeader() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+	return e.writer.Write(exportColumns)
+}
+
+// csvCell writes an optional value the way the API does, a number or unknown.
+func csvCell(value json.Marshaler) string {
+	raw, _ := value.MarshalJSON()
+	return strings.Trim(string(raw), `"`)
+}
+
+func flushResponse(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -2,18 +2,108 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"star-wars/pkg/planet"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type PlanetDTO struct {
-	ID   primitive.ObjectID `json:"id"`
-	Name string             `json:"name"`
+	ID             primitive.ObjectID `json:"id"`
+	Name           string             `json:"name"`
+	RotationPeriod optionalInt64      `json:"rotation_period"`
+	OrbitalPeriod  optionalInt64      `json:"orbital_period"`
+	Diameter       optionalInt64      `json:"diameter"`
+	Climate        []string           `json:"climate"`
+	Gravity        optionalFloat64    `json:"gravity"`
+	Terrain        []string           `json:"terrain"`
+	SurfaceWater   optionalFloat64    `json:"surface_water"`
+	Population     optionalInt64      `json:"population"`
+	FilmCount      int                `json:"film_count"`
+	Films          []string           `json:"films"`
+	Enrichment     string             `json:"enrichment_status,omitempty"`
+	DeletedAt      *time.Time         `json:"deleted_at,omitempty"`
+}
+
+// planetRequest is the payload accepted to create or replace a planet.
+// Numeric attributes take either a number or "unknown".
+type planetRequest struct {
+	Name           string          `json:"name" validate:"required"`
+	RotationPeriod optionalInt64   `json:"rotation_period" validate:"omitempty,min=0"`
+	OrbitalPeriod  optionalInt64   `json:"orbital_period" validate:"omitempty,min=0"`
+	Diameter       optionalInt64   `json:"diameter" validate:"omitempty,min=0"`
+	Climate        []string        `json:"climate" validate:"omitempty,max=10,dive,required,max=50"`
+	Gravity        optionalFloat64 `json:"gravity" validate:"omitempty,min=0"`
+	Terrain        []string        `json:"terrain" validate:"omitempty,max=10,dive,required,max=50"`
+	SurfaceWater   optionalFloat64 `json:"surface_water" validate:"omitempty,min=0,max=100"`
+	Population     optionalInt64   `json:"population" validate:"omitempty,min=0"`
+}
+
+func (p planetRequest) toPlanet(id primitive.ObjectID) planet.Planet {
+	return planet.Planet{
+		ID:             id,
+		Name:           p.Name,
+		RotationPeriod: p.RotationPeriod.value,
+		OrbitalPeriod:  p.OrbitalPeriod.value,
+		Diameter:       p.Diameter.value,
+		Climate:        p.Climate,
+		Gravity:        p.Gravity.value,
+		Terrain:        p.Terrain,
+		SurfaceWater:   p.SurfaceWater.value,
+		Population:     p.Population.value,
+	}
+}
+
+func newPlanetRequest(p planet.Planet) planetRequest {
+	return planetRequest{
+		Name:           p.Name,
+		RotationPeriod: optionalInt64{p.RotationPeriod},
+		OrbitalPeriod:  optionalInt64{p.OrbitalPeriod},
+		Diameter:       optionalInt64{p.Diameter},
+		Climate:        p.Climate,
+		Gravity:        optionalFloat64{p.Gravity},
+		Terrain:        p.Terrain,
+		SurfaceWater:   optionalFloat64{p.SurfaceWater},
+		Population:     optionalInt64{p.Population},
+	}
+}
+
+func newPlanetDTO(p planet.Planet) PlanetDTO {
+	return PlanetDTO{
+		ID:             p.ID,
+		Name:           p.Name,
+		RotationPeriod: optionalInt64{p.RotationPeriod},
+		OrbitalPeriod:  optionalInt64{p.OrbitalPeriod},
+		Diameter:       optionalInt64{p.Diameter},
+		Climate:        nonNil(p.Climate),
+		Gravity:        optionalFloat64{p.Gravity},
+		Terrain:        nonNil(p.Terrain),
+		SurfaceWater:   optionalFloat64{p.SurfaceWater},
+		Population:     optionalInt64{p.Population},
+		FilmCount:      p.FilmCount,
+		Films:          nonNil(p.Films),
+		Enrichment:     p.Enrichment,
+		DeletedAt:      p.DeletedAt,
+	}
+}
+
+// nonNil makes unknown or missing lists render as [] rather than null.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
 }
 
 const (
@@ -27,10 +117,6 @@ type PlanetInserter interface {
 }
 
 func (a *App) handleCreatePlanet(saver PlanetInserter) http.Handler {
-	type planetRequest struct {
-		Name string `json:"name" validate:"required"`
-	}
-
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := loggerFromRequest(r)
@@ -42,33 +128,313 @@ func (a *App) handleCreatePlanet(saver PlanetInserter) http.Handler {
 			return
 		}
 
-		doc := planet.Planet{
-			Name: planetRequest.Name,
-		}
+		doc := planetRequest.toPlanet(primitive.NilObjectID)
 		saved, err := saver.Insert(ctx, doc)
 		if err != nil {
 			logger.Error(err.Error())
-			writeJsonResponse(w, http.StatusInternalServerError, errorMessage{Message: "failed to insert the planet", ErrorCode: "WA:002"})
+			if errors.Is(err, planet.ErrPlanetAlreadyExists) {
+				writeJsonResponse(w, http.StatusConflict, errorMessage{Message: "planet already exists", ErrorCode: "WA:012", Details: conflictDetails(err)})
+				return
+			}
+			writePlanetFailure(w, err, errorMessage{Message: "failed to insert the planet", ErrorCode: "WA:002"})
 			return
 		}
 
-		dto := PlanetDTO{
-			ID:   saved.ID,
-			Name: saved.Name,
-		}
-		writeJsonResponse(w, http.StatusCreated, dto)
+		w.Header().Set("ETag", planetETag(saved))
+		writeJsonResponse(w, http.StatusCreated, newPlanetDTO(saved))
 	})
 }
 
-type PlanetUpdater interface {
-	Update(ctx context.Context, planetDocument planet.Planet) (int64, error)
+// conflictDetails points the client to the planet that already holds the name.
+func conflictDetails(err error) []map[string]string {
+	var conflict *planet.AlreadyExistsError
+	if !errors.As(err, &conflict) {
+		return nil
+	}
+	detail := map[string]string{"name": "name", "reason": "already taken by another planet"}
+	if !conflict.ExistingID.IsZero() {
+		detail["id"] = conflict.ExistingID.Hex()
+	}
+	return []map[string]string{detail}
 }
 
-func (a *App) handleUpdatePlanet(planetUpdater PlanetUpdater) http.HandlerFunc {
-	type planetRequest struct {
-		Name string `json:"name" validate:"required"`
+// timeoutMessage answers the requests the storage did not serve in time.
+var timeoutMessage = errorMessage{ErrorCode: "WA:034", Message: "planet storage timed out"}
+
+// writePlanetFailure answers a failed planet operation with a 504 when the
+// storage timed out, and with the given failure otherwise.
+func writePlanetFailure(w http.ResponseWriter, err error, failure errorMessage) {
+	if errors.Is(err, planet.ErrTimeout) {
+		writeJsonResponse(w, http.StatusGatewayTimeout, timeoutMessage)
+		return
 	}
+	writeJsonResponse(w, http.StatusInternalServerError, failure)
+}
+
+type PlanetBatchInserter interface {
+	InsertBatch(ctx context.Context, planets []planet.Planet, ordered bool) ([]planet.BatchResult, error)
+}
+
+// handleCreatePlanetBatch creates many planets in one request. Every item is
+// validated like in handleCreatePlanet and gets its own status in the 207
+// response, so one bad planet doesn't fail the others. Batches are ordered
+// unless ordered=false is given: nothing after the first failing item is
+// inserted.
+func (a *App) handleCreatePlanetBatch(batchInserter PlanetBatchInserter) http.HandlerFunc {
+	type itemResult struct {
+		Index  int           `json:"index"`
+		Status int           `json:"status"`
+		ID     string        `json:"id,omitempty"`
+		Error  *errorMessage `json:"error,omitempty"`
+	}
+	type response struct {
+		Results []itemResult `json:"results"`
+	}
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+
+		ordered := true
+		if raw := r.URL.Query().Get("ordered"); raw != "" {
+			parsed, err := strconv.ParseBool(raw)
+			if err != nil {
+				writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:008", Message: "invalid query parameter", Details: []map[string]string{
+					{"name": "ordered", "reason": "must be true or false"},
+				}})
+				return
+			}
+			ordered = parsed
+		}
+
+		var items []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			logger.Error(err.Error())
+			writeJsonResponse(rw, http.StatusBadRequest, errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"})
+			return
+		}
+		if len(items) == 0 || len(items) > planet.MaxBatchSize {
+			writeJsonResponse(rw, http.StatusUnprocessableEntity, errorMessage{ErrorCode: "WA:018", Message: "invalid batch", Details: []map[string]string{
+				{"name": "planets", "reason": "must hold between 1 and " + strconv.Itoa(planet.MaxBatchSize) + " planets"},
+			}})
+			return
+		}
+
+		results := make([]itemResult, len(items))
+		planets := make([]planet.Planet, 0, len(items))
+		positions := make([]int, 0, len(items))
+		failed := false
+		for i, item := range items {
+			results[i].Index = i
+
+			var planetRequest planetRequest
+			if err := json.Unmarshal(item, &planetRequest); err != nil {
+				results[i].Status, results[i].Error = http.StatusBadRequest, &errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"}
+				failed = true
+				continue
+			}
+			if code, message, err := validationFailure(&planetRequest); err != nil {
+				results[i].Status, results[i].Error = code, &message
+				failed = true
+				continue
+			}
+			if ordered && failed {
+				results[i].Status, results[i].Error = http.StatusFailedDependency, &errorMessage{ErrorCode: "WA:019", Message: planet.ErrBatchAborted.Error()}
+				continue
+			}
+
+			planets = append(planets, planetRequest.toPlanet(primitive.NilObjectID))
+			positions = append(positions, i)
+		}
+
+		if len(planets) > 0 {
+			saved, err := batchInserter.InsertBatch(ctx, planets, ordered)
+			if err != nil {
+				logger.Error(err.Error())
+				writePlanetFailure(rw, err, errorMessage{Message: "failed to insert the planet", ErrorCode: "WA:002"})
+				return
+			}
 
+			for j, result := range saved {
+				item := &results[positions[j]]
+				switch {
+				case result.Err == nil:
+					item.Status, item.ID = http.StatusCreated, result.Planet.ID.Hex()
+				case errors.Is(result.Err, planet.ErrPlanetAlreadyExists):
+					item.Status, item.Error = http.StatusConflict, &errorMessage{Message: "planet already exists", ErrorCode: "WA:012", Details: conflictDetails(result.Err)}
+				case errors.Is(result.Err, planet.ErrBatchAborted):
+					item.Status, item.Error = http.StatusFailedDependency, &errorMessage{ErrorCode: "WA:019", Message: planet.ErrBatchAborted.Error()}
+				case errors.Is(result.Err, planet.ErrTimeout):
+					logger.Error(result.Err.Error())
+					item.Status, item.Error = http.StatusGatewayTimeout, &errorMessage{ErrorCode: "WA:034", Message: "planet storage timed out"}
+				default:
+					logger.Error(result.Err.Error())
+					item.Status, item.Error = http.StatusInternalServerError, &errorMessage{Message: "failed to insert the planet", ErrorCode: "WA:002"}
+				}
+			}
+		}
+
+		writeJsonResponse(rw, http.StatusMultiStatus, response{Results: results})
+	}
+}
+
+type PlanetImporter interface {
+	UpsertByName(ctx context.Context, planets []planet.Planet) ([]planet.UpsertResult, error)
+}
+
+// importBatchSize is how many planets of an upload are upserted at once.
+const importBatchSize = 100
+
+// handleImportPlanets upserts the planets of a CSV or NDJSON upload by name.
+// The body is read as a stream and written in batches, and lines that can't
+// be imported are reported in the summary without stopping the import. When
+// the storage fails a batch, the import stops there: the summary of the
+// batches written before it is returned with a 500, or a 504 on a timeout,
+// along with the lines of the failed batch, and nothing after them is read.
+func (a *App) handleImportPlanets(planetImporter PlanetImporter) http.HandlerFunc {
+	type lineError struct {
+		Line int `json:"line"`
+		errorMessage
+	}
+	type linesError struct {
+		FromLine int `json:"from_line"`
+		ToLine   int `json:"to_line"`
+		errorMessage
+	}
+	type response struct {
+		Inserted int         `json:"inserted"`
+		Updated  int         `json:"updated"`
+		Skipped  int         `json:"skipped"`
+		Errors   []lineError `json:"errors"`
+		Failed   *linesError `json:"failed,omitempty"`
+	}
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+
+		var reader importReader
+		switch mediaType(r) {
+		case csvMediaType:
+			csvReader, details, err := newCSVImportReader(r.Body, r.URL.Query())
+			if err != nil {
+				logger.Error(err.Error())
+				writeJsonResponse(rw, http.StatusBadRequest, errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"})
+				return
+			}
+			if len(details) > 0 {
+				writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:020", Message: "invalid import columns", Details: details})
+				return
+			}
+			reader = csvReader
+		case ndjsonMediaType:
+			reader = newNDJSONImportReader(r.Body)
+		default:
+			writeJsonResponse(rw, http.StatusUnsupportedMediaType, errorMessage{ErrorCode: "WA:013", Message: "unsupported media type", Details: []map[string]string{
+				{"name": "Content-Type", "reason": "expected " + csvMediaType + " or " + ndjsonMediaType},
+			}})
+			return
+		}
+
+		res := response{Errors: []lineError{}}
+		batch := make([]planet.Planet, 0, importBatchSize)
+		lines := make([]int, 0, importBatchSize)
+
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			results, err := planetImporter.UpsertByName(ctx, batch)
+			if err != nil {
+				return err
+			}
+			for i, result := range results {
+				switch {
+				case result.Outcome == planet.UpsertInserted:
+					res.Inserted++
+				case result.Outcome == planet.UpsertUpdated:
+					res.Updated++
+				case result.Outcome == planet.UpsertSkipped:
+					res.Skipped++
+				case errors.Is(result.Err, planet.ErrPlanetAlreadyExists):
+					res.Errors = append(res.Errors, lineError{lines[i], errorMessage{Message: "planet already exists", ErrorCode: "WA:012", Details: conflictDetails(result.Err)}})
+				case errors.Is(result.Err, planet.ErrVersionConflict):
+					res.Errors = append(res.Errors, lineError{lines[i], errorMessage{ErrorCode: "WA:017", Message: "planet was modified by another request"}})
+				case errors.Is(result.Err, planet.ErrTimeout):
+					logger.Error(result.Err.Error())
+					res.Errors = append(res.Errors, lineError{lines[i], timeoutMessage})
+				default:
+					logger.Error(result.Err.Error())
+					res.Errors = append(res.Errors, lineError{lines[i], errorMessage{Message: "failed to insert the planet", ErrorCode: "WA:002"}})
+				}
+			}
+			batch, lines = batch[:0], lines[:0]
+			return nil
+		}
+
+		// abort answers with what was imported before the batch that failed.
+		abort := func(err error) {
+			logger.Error(err.Error())
+			status, failure := http.StatusInternalServerError, errorMessage{ErrorCode: "WA:021", Message: "failed to import planets"}
+			if errors.Is(err, planet.ErrTimeout) {
+				status, failure = http.StatusGatewayTimeout, timeoutMessage
+			}
+			res.Failed = &linesError{FromLine: lines[0], ToLine: lines[len(lines)-1], errorMessage: failure}
+			sort.SliceStable(res.Errors, func(i, j int) bool { return res.Errors[i].Line < res.Errors[j].Line })
+			writeJsonResponse(rw, status, res)
+		}
+
+		for {
+			record, err := reader.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// The rest of the body can't be read, keep what was imported so far.
+				logger.Error(err.Error())
+				res.Errors = append(res.Errors, lineError{record.line, errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"}})
+				break
+			}
+
+			if record.err == nil {
+				if _, message, err := validationFailure(&record.request); err != nil {
+					record.err = &message
+				}
+			}
+			if record.err != nil {
+				res.Errors = append(res.Errors, lineError{record.line, *record.err})
+				continue
+			}
+
+			batch = append(batch, record.request.toPlanet(primitive.NilObjectID))
+			lines = append(lines, record.line)
+			if len(batch) == importBatchSize {
+				if err := flush(); err != nil {
+					abort(err)
+					return
+				}
+			}
+		}
+
+		if err := flush(); err != nil {
+			abort(err)
+			return
+		}
+
+		// Write failures are only known after their batch, after later lines.
+		sort.SliceStable(res.Errors, func(i, j int) bool { return res.Errors[i].Line < res.Errors[j].Line })
+		writeJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+type PlanetUpdater interface {
+	Update(ctx context.Context, planetDocument planet.Planet) (planet.Planet, error)
+}
+
+// handleUpdatePlanet replaces a planet. With If-Match the update only applies
+// to the version the client read, so concurrent writers can't overwrite each
+// other.
+func (a *App) handleUpdatePlanet(planetUpdater PlanetUpdater) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := loggerFromRequest(r)
@@ -76,6 +442,12 @@ func (a *App) handleUpdatePlanet(planetUpdater PlanetUpdater) http.HandlerFunc {
 
 		objectID, _ := primitive.ObjectIDFromHex(id)
 
+		version, ok := ifMatchVersion(r)
+		if !ok {
+			writeVersionConflict(w)
+			return
+		}
+
 		var planetRequest planetRequest
 
 		if err := decodeAndValidate(w, r, &planetRequest); err != nil {
@@ -83,21 +455,29 @@ func (a *App) handleUpdatePlanet(planetUpdater PlanetUpdater) http.HandlerFunc {
 			return
 		}
 
-		doc := planet.Planet{
-			ID:   objectID,
-			Name: planetRequest.Name,
-		}
-		
-		if _, err := planetUpdater.Update(ctx, doc); err != nil {
+		doc := planetRequest.toPlanet(objectID)
+		doc.Version = version
+
+		saved, err := planetUpdater.Update(ctx, doc)
+		if err != nil {
 			logger.Error(err.Error())
 			if errors.Is(err, planet.ErrPlanetNotFound) {
 				writeJsonResponse(w, http.StatusNotFound, errorMessage{Message: "planet not found", ErrorCode: "WA:003"})
 				return
 			}
-			writeJsonResponse(w, http.StatusInternalServerError, errorMessage{Message: "failed to update the planet", ErrorCode: "WA:001"})
+			if errors.Is(err, planet.ErrVersionConflict) {
+				writeVersionConflict(w)
+				return
+			}
+			if errors.Is(err, planet.ErrPlanetAlreadyExists) {
+				writeJsonResponse(w, http.StatusConflict, errorMessage{Message: "planet already exists", ErrorCode: "WA:012", Details: conflictDetails(err)})
+				return
+			}
+			writePlanetFailure(w, err, errorMessage{Message: "failed to update the planet", ErrorCode: "WA:001"})
 			return
 		}
 
+		w.Header().Set("ETag", planetETag(saved))
 		writeJsonResponse(w, http.StatusNoContent, nil)
 	})
 }
@@ -106,29 +486,668 @@ type PlanetGetter interface {
 	GetByID(context.Context, string) (planet.Planet, error)
 }
 
-func (a *App) handleGetPlanetByID(planetGetter PlanetGetter) http.HandlerFunc {
-	type planetDTO struct {
-		ID   primitive.ObjectID `json:"id"`
-		Name string             `json:"name"`
-	}
+type PlanetHistoryReader interface {
+	RevisionAt(ctx context.Context, id string, at time.Time) (planet.Revision, error)
+}
 
+// handleGetPlanetByID answers conditional requests with 304 when the client
+// already holds the current version of the planet. With as_of the planet is
+// read as it was at that time, and the response is marked as such and gets a
+// weak validator of its own.
+func (a *App) handleGetPlanetByID(planetGetter PlanetGetter, historyReader PlanetHistoryReader) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		id := mux.Vars(r)["id"]
 
-		got, err := planetGetter.GetByID(ctx, id)
+		asOf, err := parseAsOf(r.URL.Query())
+		if err != nil {
+			writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:008", Message: "invalid query parameter", Details: []map[string]string{
+				{"name": "as_of", "reason": err.Error()},
+			}})
+			return
+		}
+
+		var got planet.Planet
+		if asOf.IsZero() {
+			got, err = planetGetter.GetByID(ctx, id)
+		} else {
+			var revision planet.Revision
+			revision, err = historyReader.RevisionAt(ctx, id, asOf)
+			got = revision.After
+			setMementoDatetime(rw, asOf)
+		}
 		if err != nil {
 			if errors.Is(err, planet.ErrPlanetNotFound) {
 				writeJsonResponse(rw, http.StatusNotFound, errorMessage{ErrorCode: "WA:003", Message: "planet not found"})
 				return
 			}
-			writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:004", Message: "failed to retrieve a planet by id"})
+			writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:004", Message: "failed to retrieve a planet by id"})
+			return
+		}
+
+		etag, lastModified := planetETag(got), got.UpdatedAt
+		if !asOf.IsZero() {
+			// A past planet has its own validator, and its update time says
+			// nothing about what the client holds.
+			etag, lastModified = revisionETag(got), time.Time{}
+		}
+		rw.Header().Set("ETag", etag)
+		if !lastModified.IsZero() {
+			rw.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+		if notModified(r, etag, lastModified) {
+			writeNotModified(rw)
 			return
 		}
 
-		writeJsonResponse(rw, http.StatusOK, planetDTO{
-			ID:   got.ID,
-			Name: got.Name,
+		writeJsonResponse(rw, http.StatusOK, newPlanetDTO(got))
+	}
+}
+
+type PlanetLister interface {
+	List(ctx context.Context, opts planet.ListOptions) (planet.Page, error)
+}
+
+func (a *App) handleListPlanets(planetLister PlanetLister) http.HandlerFunc {
+	return a.listPlanetsHandler(func(ctx context.Context, opts planet.ListOptions) (planet.Page, error) {
+		return planetLister.List(ctx, opts)
+	})
+}
+
+func (a *App) listPlanetsHandler(list func(context.Context, planet.ListOptions) (planet.Page, error)) http.HandlerFunc {
+	type response struct {
+		Planets    []PlanetDTO `json:"planets"`
+		NextCursor string      `json:"next_cursor,omitempty"`
+	}
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+
+		opts, details := parseListOptions(r.URL.Query())
+		if len(details) > 0 {
+			writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:008", Message: "invalid query parameter", Details: details})
+			return
+		}
+
+		if !opts.AsOf.IsZero() {
+			setMementoDatetime(rw, opts.AsOf)
+		}
+
+		page, err := list(ctx, opts)
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, planet.ErrInvalidCursor) {
+				writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:008", Message: "invalid query parameter", Details: []map[string]string{
+					{"name": "cursor", "reason": err.Error()},
+				}})
+				return
+			}
+			writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:009", Message: "failed to list planets"})
+			return
+		}
+
+		res := response{Planets: make([]PlanetDTO, 0, len(page.Planets)), NextCursor: page.NextCursor}
+		for _, p := range page.Planets {
+			res.Planets = append(res.Planets, newPlanetDTO(p))
+		}
+
+		etag := pageETag(page, opts.AsOf)
+		rw.Header().Set("ETag", etag)
+		writeLinkHeader(rw, r, page.NextCursor)
+		if notModified(r, etag, time.Time{}) {
+			writeNotModified(rw)
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+type PlanetExporter interface {
+	Export(ctx context.Context, query planet.Query, at time.Time, each func(planet.Planet) error) error
+}
+
+// handleExportPlanets streams every planet matching the listing filters as
+// NDJSON or CSV, as negotiated with Accept. Planets are flushed to the client
+// as they are read instead of building the whole export in memory. Like the
+// listing, as_of exports the planets as they were at that time.
+func (a *App) handleExportPlanets(planetExporter PlanetExporter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+
+		mediaType, ok := negotiateExport(r)
+		if !ok {
+			writeJsonResponse(rw, http.StatusNotAcceptable, errorMessage{ErrorCode: "WA:022", Message: "not acceptable", Details: []map[string]string{
+				{"name": "Accept", "reason": "expected " + strings.Join(exportMediaTypes, " or ")},
+			}})
+			return
+		}
+
+		query, details := parsePlanetQuery(r.URL.Query())
+		asOf, err := parseAsOf(r.URL.Query())
+		if err != nil {
+			details = append(details, map[string]string{"name": "as_of", "reason": err.Error()})
+		}
+		if len(details) > 0 {
+			writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:008", Message: "invalid query parameter", Details: details})
+			return
+		}
+
+		rw.Header().Set("Content-Type", mediaType)
+		if !asOf.IsZero() {
+			setMementoDatetime(rw, asOf)
+		}
+		writer := newExportWriter(rw, mediaType)
+		written := 0
+		err = planetExporter.Export(ctx, query, asOf, func(p planet.Planet) error {
+			if err := writer.write(p); err != nil {
+				return err
+			}
+			written++
+			if written%exportFlushEvery == 0 {
+				return writer.flush()
+			}
+			return nil
 		})
+		if err != nil {
+			logger.Error(err.Error())
+			if written == 0 {
+				writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:023", Message: "failed to export planets"})
+				return
+			}
+			// The status is already sent, abort the connection so the client
+			// can tell the export is incomplete.
+			panic(http.ErrAbortHandler)
+		}
+
+		if err := writer.flush(); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
+type PlanetDeleter interface {
+	Delete(ctx context.Context, id string) error
+}
+
+func (a *App) handleDeletePlanet(planetDeleter PlanetDeleter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		id := mux.Vars(r)["id"]
+
+		if err := planetDeleter.Delete(ctx, id); err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, planet.ErrPlanetNotFound) {
+				writeJsonResponse(rw, http.StatusNotFound, errorMessage{ErrorCode: "WA:003", Message: "planet not found"})
+				return
+			}
+			writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:010", Message: "failed to delete the planet"})
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusNoContent, nil)
+	}
+}
+
+type TrashLister interface {
+	ListTrash(ctx context.Context, opts planet.ListOptions) (planet.Page, error)
+}
+
+func (a *App) handleListTrash(trashLister TrashLister) http.HandlerFunc {
+	return a.listPlanetsHandler(func(ctx context.Context, opts planet.ListOptions) (planet.Page, error) {
+		return trashLister.ListTrash(ctx, opts)
+	})
+}
+
+type PlanetRestorer interface {
+	Restore(ctx context.Context, id string) (planet.Planet, error)
+}
+
+func (a *App) handleRestorePlanet(planetRestorer PlanetRestorer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		id := mux.Vars(r)["id"]
+
+		restored, err := planetRestorer.Restore(ctx, id)
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, planet.ErrPlanetNotFound) {
+				writeJsonResponse(rw, http.StatusNotFound, errorMessage{ErrorCode: "WA:003", Message: "planet not found"})
+				return
+			}
+			writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:011", Message: "failed to restore the planet"})
+			return
+		}
+
+		rw.Header().Set("ETag", planetETag(restored))
+		writeJsonResponse(rw, http.StatusOK, newPlanetDTO(restored))
+	}
+}
+
+type PlanetPatcher interface {
+	Patch(ctx context.Context, id string, changes planet.Planet, fields []string) (planet.Planet, error)
+}
+
+// handlePatchPlanet applies a JSON Merge Patch or a JSON Patch to the planet.
+// The patched planet is validated with the same rules as a full update and
+// only the attributes that actually changed are written.
+func (a *App) handlePatchPlanet(planetGetter PlanetGetter, planetPatcher PlanetPatcher) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		id := mux.Vars(r)["id"]
+
+		var apply func(document interface{}) (interface{}, error)
+		switch mediaType(r) {
+		case mergePatchMediaType:
+			patch, err := decodeJSONDocument(r.Body)
+			if err != nil {
+				logger.Error(err.Error())
+				writeJsonResponse(rw, http.StatusBadRequest, errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"})
+				return
+			}
+			apply = func(document interface{}) (interface{}, error) {
+				return mergePatch(document, patch), nil
+			}
+		case jsonPatchMediaType:
+			operations, err := decodeJSONPatch(r.Body)
+			if err != nil {
+				logger.Error(err.Error())
+				writeJsonResponse(rw, http.StatusBadRequest, errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"})
+				return
+			}
+			apply = func(document interface{}) (interface{}, error) {
+				return applyJSONPatch(document, operations)
+			}
+		default:
+			writeJsonResponse(rw, http.StatusUnsupportedMediaType, errorMessage{ErrorCode: "WA:013", Message: "unsupported media type", Details: []map[string]string{
+				{"name": "Content-Type", "reason": "expected " + mergePatchMediaType + " or " + jsonPatchMediaType},
+			}})
+			return
+		}
+
+		current, err := planetGetter.GetByID(ctx, id)
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, planet.ErrPlanetNotFound) {
+				writeJsonResponse(rw, http.StatusNotFound, errorMessage{ErrorCode: "WA:003", Message: "planet not found"})
+				return
+			}
+			writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:004", Message: "failed to retrieve a planet by id"})
+			return
+		}
+		if version, ok := ifMatchVersion(r); !ok || (version != 0 && version != current.Version) {
+			writeVersionConflict(rw)
+			return
+		}
+
+		before, err := toJSONDocument(newPlanetRequest(current))
+		if err != nil {
+			logger.Error(err.Error())
+			writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:014", Message: "failed to patch the planet"})
+			return
+		}
+		// Patches modify their target, so they work on a copy of before.
+		target, _ := toJSONDocument(newPlanetRequest(current))
+		result, err := apply(target)
+		if err != nil {
+			logger.Error(err.Error())
+			var patchErr *jsonPatchError
+			if errors.As(err, &patchErr) && patchErr.FailedTest {
+				writeJsonResponse(rw, http.StatusConflict, errorMessage{ErrorCode: "WA:015", Message: "patch test failed", Details: patchErr.details()})
+				return
+			}
+			if errors.As(err, &patchErr) {
+				writeJsonResponse(rw, http.StatusUnprocessableEntity, errorMessage{ErrorCode: "WA:016", Message: "failed to apply the patch", Details: patchErr.details()})
+				return
+			}
+			writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:014", Message: "failed to patch the planet"})
+			return
+		}
+
+		var patched planetRequest
+		if err := fromJSONDocument(result, &patched); err != nil {
+			logger.Error(err.Error())
+			writeJsonResponse(rw, http.StatusBadRequest, errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"})
+			return
+		}
+		if err := validate(rw, &patched); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		after, _ := toJSONDocument(patched)
+		// The patch was computed from the version just read, so it must only
+		// apply to that version.
+		changes := patched.toPlanet(current.ID)
+		changes.Version = current.Version
+
+		saved, err := planetPatcher.Patch(ctx, id, changes, changedMembers(before, after))
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, planet.ErrPlanetNotFound) {
+				writeJsonResponse(rw, http.StatusNotFound, errorMessage{ErrorCode: "WA:003", Message: "planet not found"})
+				return
+			}
+			if errors.Is(err, planet.ErrVersionConflict) {
+				writeVersionConflict(rw)
+				return
+			}
+			if errors.Is(err, planet.ErrPlanetAlreadyExists) {
+				writeJsonResponse(rw, http.StatusConflict, errorMessage{ErrorCode: "WA:012", Message: "planet already exists", Details: conflictDetails(err)})
+				return
+			}
+			writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:014", Message: "failed to patch the planet"})
+			return
+		}
+
+		rw.Header().Set("ETag", planetETag(saved))
+		writeJsonResponse(rw, http.StatusOK, newPlanetDTO(saved))
+	}
+}
+
+type RevisionLister interface {
+	Revisions(ctx context.Context, id string) ([]planet.Revision, error)
+}
+
+// handleListRevisions lists the changes of a planet, oldest first, with the
+// attributes each one touched.
+func (a *App) handleListRevisions(revisionLister RevisionLister) http.HandlerFunc {
+	type response struct {
+		Revisions []revisionSummaryDTO `json:"revisions"`
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		id := mux.Vars(r)["id"]
+
+		revisions, err := revisionLister.Revisions(ctx, id)
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, planet.ErrPlanetNotFound) {
+				writeJsonResponse(rw, http.StatusNotFound, errorMessage{ErrorCode: "WA:003", Message: "planet not found"})
+				return
+			}
+			writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:025", Message: "failed to retrieve the revisions"})
+			return
+		}
+
+		res := response{Revisions: make([]revisionSummaryDTO, 0, len(revisions))}
+		for _, revision := range revisions {
+			summary, err := newRevisionSummaryDTO(revision)
+			if err != nil {
+				logger.Error(err.Error())
+				writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:025", Message: "failed to retrieve the revisions"})
+				return
+			}
+			res.Revisions = append(res.Revisions, summary)
+		}
+
+		writeJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+type RevisionGetter interface {
+	Revision(ctx context.Context, id string, version int64) (planet.Revision, error)
+}
+
+func (a *App) handleGetRevision(revisionGetter RevisionGetter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		id := mux.Vars(r)["id"]
+
+		version, ok := revisionVersion(r)
+		if !ok {
+			writeRevisionNotFound(rw)
+			return
+		}
+
+		revision, err := revisionGetter.Revision(ctx, id, version)
+		if err != nil {
+			logger.Error(err.Error())
+			writeRevisionError(rw, err)
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusOK, newRevisionDTO(revision))
+	}
+}
+
+// handleDiffRevisions compares the planet after a revision with the planet
+// after the revision named by the against query parameter. Without it, the
+// revision is compared with the planet right before it.
+func (a *App) handleDiffRevisions(revisionGetter RevisionGetter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		id := mux.Vars(r)["id"]
+
+		version, ok := revisionVersion(r)
+		if !ok {
+			writeRevisionNotFound(rw)
+			return
+		}
+
+		var against int64
+		if raw := r.URL.Query().Get("against"); raw != "" {
+			parsed, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || parsed < 1 {
+				writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:008", Message: "invalid query parameter", Details: []map[string]string{
+					{"name": "against", "reason": "must be a revision number"},
+				}})
+				return
+			}
+			against = parsed
+		}
+
+		revision, err := revisionGetter.Revision(ctx, id, version)
+		if err != nil {
+			logger.Error(err.Error())
+			writeRevisionError(rw, err)
+			return
+		}
+
+		from, diff := revision.Before, revisionDiffDTO{From: revision.Version - 1, To: revision.Version}
+		if against > 0 {
+			base, err := revisionGetter.Revision(ctx, id, against)
+			if err != nil {
+				logger.Error(err.Error())
+				writeRevisionError(rw, err)
+				return
+			}
+			from, diff.From = &base.After, base.Version
+		}
+
+		if diff.Changes, err = diffPlanets(from, revision.After); err != nil {
+			logger.Error(err.Error())
+			writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:025", Message: "failed to retrieve the revisions"})
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusOK, diff)
+	}
+}
+
+type PlanetReverter interface {
+	Revert(ctx context.Context, id string, version int64, expected int64) (planet.Planet, error)
+}
+
+// handleRevertPlanet gives a planet back the attributes it had after a
+// revision. The revert is a new change, so it gets a new version and honours
+// If-Match like an update.
+func (a *App) handleRevertPlanet(planetReverter PlanetReverter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		id := mux.Vars(r)["id"]
+
+		version, ok := revisionVersion(r)
+		if !ok {
+			writeRevisionNotFound(rw)
+			return
+		}
+
+		expected, ok := ifMatchVersion(r)
+		if !ok {
+			writeVersionConflict(rw)
+			return
+		}
+
+		reverted, err := planetReverter.Revert(ctx, id, version, expected)
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, planet.ErrPlanetNotFound) || errors.Is(err, planet.ErrRevisionNotFound) {
+				writeRevisionError(rw, err)
+				return
+			}
+			if errors.Is(err, planet.ErrVersionConflict) {
+				writeVersionConflict(rw)
+				return
+			}
+			if errors.Is(err, planet.ErrPlanetAlreadyExists) {
+				writeJsonResponse(rw, http.StatusConflict, errorMessage{ErrorCode: "WA:012", Message: "planet already exists", Details: conflictDetails(err)})
+				return
+			}
+			writePlanetFailure(rw, err, errorMessage{ErrorCode: "WA:026", Message: "failed to revert the planet"})
+			return
+		}
+
+		rw.Header().Set("ETag", planetETag(reverted))
+		writeJsonResponse(rw, http.StatusOK, newPlanetDTO(reverted))
+	}
+}
+
+type PlanetWatcher interface {
+	Watch(ctx context.Context, after string) (<-chan planet.StreamedEvent, error)
+}
+
+// handlePlanetEvents streams the planet events as Server-Sent Events, only
+// those of the planet_id parameters when given. A client reconnecting with
+// Last-Event-ID resumes after the last event it got, or is sent a reset event
+// when it cannot. Streams end when the client goes away or the application
+// shuts down.
+func (a *App) handlePlanetEvents(planetWatcher PlanetWatcher) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		logger := loggerFromRequest(r)
+
+		planetIDs, details := parsePlanetIDs(r.URL.Query())
+		if len(details) > 0 {
+			writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:008", Message: "invalid query parameter", Details: details})
+			return
+		}
+
+		events, err := planetWatcher.Watch(ctx, r.Header.Get("Last-Event-ID"))
+		if err != nil {
+			logger.Error(err.Error())
+			writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:032", Message: "failed to watch the planets"})
+			return
+		}
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Header().Set("X-Accel-Buffering", "no")
+		rw.WriteHeader(http.StatusOK)
+		flushResponse(rw)
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.streams.Done():
+				return
+			case <-heartbeat.C:
+				if _, err := io.WriteString(rw, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flushResponse(rw)
+			case streamed, ok := <-events:
+				if !ok {
+					return
+				}
+				if !streamed.Reset && len(planetIDs) > 0 && !planetIDs[streamed.Event.PlanetID] {
+					continue
+				}
+				if err := writeServerSentEvent(rw, streamed); err != nil {
+					logger.Error(err.Error())
+					return
+				}
+				flushResponse(rw)
+			}
+		}
+	}
+}
+
+// handlePlanetSocket upgrades to a WebSocket on which the client subscribes
+// to the changes of the planets it picks, see planet_socket.go for the
+// protocol. Sockets are closed when the client goes away, falls behind
+// under the disconnect policy or the application shuts down.
+func (a *App) handlePlanetSocket(planetWatcher PlanetWatcher) http.HandlerFunc {
+	policy := a.sockets.withDefaults()
+	var upgrader websocket.Upgrader
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		logger := loggerFromRequest(r)
+
+		if !websocket.IsWebSocketUpgrade(r) {
+			rw.Header().Set("Upgrade", "websocket")
+			writeJsonResponse(rw, http.StatusUpgradeRequired, errorMessage{ErrorCode: "WA:033", Message: "websocket upgrade required"})
+			return
+		}
+
+		events, err := planetWatcher.Watch(ctx, "")
+		if err != nil {
+			logger.Error(err.Error())
+			writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:032", Message: "failed to watch the planets"})
+			return
+		}
+
+		release := a.streams.hold()
+		defer release()
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			logger.Warn(err.Error())
+			return
+		}
+
+		connections, _ := getSocketMetricsInstance()
+		connections.Inc()
+		defer connections.Dec()
+
+		socket := newPlanetSocket(conn, policy)
+		go socket.write()
+		go socket.forward(events)
+		go func() {
+			select {
+			case <-a.streams.Done():
+				socket.close(websocket.CloseGoingAway, "server shutting down")
+			case <-socket.done:
+			}
+		}()
+
+		// A client closing the socket already got its close message back,
+		// its code is only kept for the logs.
+		code := websocket.CloseNormalClosure
+		var closeErr *websocket.CloseError
+		if err := socket.read(); errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure {
+			code = closeErr.Code
+		}
+		socket.close(code, "")
+
+		logger.WithField("close_code", socket.closeCode).
+			WithField("duration", time.Since(socket.opened).String()).
+			WithField("sent", atomic.LoadInt64(&socket.sent)).
+			WithField("received", atomic.LoadInt64(&socket.received)).
+			WithField("dropped", atomic.LoadInt64(&socket.dropped)).
+			Info("planet socket closed")
 	}
 }
@@ -4,15 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"star-wars/pkg/planet"
 
+	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -35,16 +40,39 @@ func Test_handleInsertPlanet(t *testing.T) {
 		planetInserterMock planetInserterMock
 		wantStatusCode     int
 		wantResponseBody   string
+		wantETag           string
 	}{
 		{
 			name:      "when payload is valid and no error then it should return 201 status",
 			givenBody: `{"name": "Mars"}`,
 			planetInserterMock: planetInserterMock{
-				result: planet.Planet{ID: objectID, Name: "Mars"},
+				result: planet.Planet{ID: objectID, Name: "Mars", Version: 1},
 				err:    nil,
 			},
 			wantStatusCode:   201,
-			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars"}`,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+			wantETag:         `"1"`,
+		},
+		{
+			name:      "when payload has every attribute then it should return 201 status with all of them",
+			givenBody: `{"name": "Tatooine", "rotation_period": 23, "orbital_period": 304, "diameter": 10465, "climate": ["arid"], "gravity": 1, "terrain": ["desert"], "surface_water": 1, "population": "unknown"}`,
+			planetInserterMock: planetInserterMock{
+				result: planet.Planet{
+					ID:             objectID,
+					Name:           "Tatooine",
+					RotationPeriod: int64Ptr(23),
+					OrbitalPeriod:  int64Ptr(304),
+					Diameter:       int64Ptr(10465),
+					Climate:        []string{"arid"},
+					Gravity:        float64Ptr(1),
+					Terrain:        []string{"desert"},
+					SurfaceWater:   float64Ptr(1),
+					Version:        1,
+				},
+			},
+			wantStatusCode:   201,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Tatooine","rotation_period":23,"orbital_period":304,"diameter":10465,"climate":["arid"],"gravity":1,"terrain":["desert"],"surface_water":1,"population":"unknown","film_count":0,"films":[]}`,
+			wantETag:         `"1"`,
 		},
 		{
 			name:      "when payload is invalid then it should return 400 status",
@@ -56,6 +84,20 @@ func Test_handleInsertPlanet(t *testing.T) {
 			wantStatusCode:   400,
 			wantResponseBody: `{"error_code":"WA:007","message":"failed to decode payload"}`,
 		},
+		{
+			name:               "when a numeric attribute is neither a number nor unknown then it should return 400 status",
+			givenBody:          `{"name": "Mars", "diameter": "big"}`,
+			planetInserterMock: planetInserterMock{},
+			wantStatusCode:     400,
+			wantResponseBody:   `{"error_code":"WA:007","message":"failed to decode payload"}`,
+		},
+		{
+			name:               "when an attribute is out of range then it should return 422 status",
+			givenBody:          `{"name": "Mars", "surface_water": 150}`,
+			planetInserterMock: planetInserterMock{},
+			wantStatusCode:     422,
+			wantResponseBody:   `{"error_code":"WA:001","message":"payload is invalid","details":[{"name":"SurfaceWater","reason":"Key: 'planetRequest.SurfaceWater' Error:Field validation for 'SurfaceWater' failed on the 'max' tag"}]}`,
+		},
 		{
 			name:      "when required field not sent then it should return 422 status",
 			givenBody: `{"test": "Mars"}`,
@@ -66,6 +108,15 @@ func Test_handleInsertPlanet(t *testing.T) {
 			wantStatusCode:   422,
 			wantResponseBody: `{"error_code":"WA:001","message":"payload is invalid","details":[{"name":"Name","reason":"Key: 'planetRequest.Name' Error:Field validation for 'Name' failed on the 'required' tag"}]}`,
 		},
+		{
+			name:      "when the name is already taken then it should return 409 status with the conflicting planet",
+			givenBody: `{"name": "mars"}`,
+			planetInserterMock: planetInserterMock{
+				err: &planet.AlreadyExistsError{Name: "mars", ExistingID: objectID},
+			},
+			wantStatusCode:   409,
+			wantResponseBody: `{"error_code":"WA:012","message":"planet already exists","details":[{"id":"5f165e2e4de9b442e60b3904","name":"name","reason":"already taken by another planet"}]}`,
+		},
 		{
 			name:      "when payload is valid and can't save it then it should return 500 status",
 			givenBody: `{"name": "Mars"}`,
@@ -76,6 +127,15 @@ func Test_handleInsertPlanet(t *testing.T) {
 			wantStatusCode:   500,
 			wantResponseBody: `{"error_code":"WA:002","message":"failed to insert the planet"}`,
 		},
+		{
+			name:      "when payload is valid but the database times out then it should return 504 status",
+			givenBody: `{"name": "Mars"}`,
+			planetInserterMock: planetInserterMock{
+				err: fmt.Errorf("%w: context deadline exceeded", planet.ErrTimeout),
+			},
+			wantStatusCode:   504,
+			wantResponseBody: `{"error_code":"WA:034","message":"planet storage timed out"}`,
+		},
 	}
 
 	for _, tc := range tests {
@@ -93,17 +153,377 @@ func Test_handleInsertPlanet(t *testing.T) {
 			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
 				t.Errorf("handleInsertPlanet() body = %v, want %v", string(got), tc.wantResponseBody)
 			}
+			if got := rr.Header().Get("ETag"); got != tc.wantETag {
+				t.Errorf("handleInsertPlanet() etag = %v, want %v", got, tc.wantETag)
+			}
+		})
+	}
+}
+
+type planetBatchInserterMock struct {
+	results    []planet.BatchResult
+	err        error
+	gotPlanets *[]planet.Planet
+	gotOrdered *bool
+}
+
+func (a planetBatchInserterMock) InsertBatch(ctx context.Context, planets []planet.Planet, ordered bool) ([]planet.BatchResult, error) {
+	if a.gotPlanets != nil {
+		*a.gotPlanets = planets
+	}
+	if a.gotOrdered != nil {
+		*a.gotOrdered = ordered
+	}
+	return a.results, a.err
+}
+
+func Test_handleCreatePlanetBatch(t *testing.T) {
+	t.Parallel()
+
+	marsID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	venusID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3905")
+	tests := []struct {
+		name              string
+		givenQuery        string
+		givenBody         string
+		batchInserterMock planetBatchInserterMock
+		wantStatusCode    int
+		wantResponseBody  string
+		wantPlanets       []string
+		wantOrdered       bool
+	}{
+		{
+			name:      "when every planet is valid then it should return 207 status with their ids",
+			givenBody: `[{"name": "Mars"}, {"name": "Venus", "diameter": 12104}]`,
+			batchInserterMock: planetBatchInserterMock{
+				results: []planet.BatchResult{{Planet: planet.Planet{ID: marsID}}, {Planet: planet.Planet{ID: venusID}}},
+			},
+			wantStatusCode:   207,
+			wantResponseBody: `{"results":[{"index":0,"status":201,"id":"5f165e2e4de9b442e60b3904"},{"index":1,"status":201,"id":"5f165e2e4de9b442e60b3905"}]}`,
+			wantPlanets:      []string{"Mars", "Venus"},
+			wantOrdered:      true,
+		},
+		{
+			name:       "when an unordered batch has invalid and taken planets then it should insert the others",
+			givenQuery: "?ordered=false",
+			givenBody:  `[{"diameter": 10}, {"name": "Mars"}, {"name": "Venus"}]`,
+			batchInserterMock: planetBatchInserterMock{
+				results: []planet.BatchResult{
+					{Err: &planet.AlreadyExistsError{Name: "Mars", ExistingID: marsID}},
+					{Planet: planet.Planet{ID: venusID}},
+				},
+			},
+			wantStatusCode:   207,
+			wantResponseBody: `{"results":[{"index":0,"status":422,"error":{"error_code":"WA:001","message":"payload is invalid","details":[{"name":"Name","reason":"Key: 'planetRequest.Name' Error:Field validation for 'Name' failed on the 'required' tag"}]}},{"index":1,"status":409,"error":{"error_code":"WA:012","message":"planet already exists","details":[{"id":"5f165e2e4de9b442e60b3904","name":"name","reason":"already taken by another planet"}]}},{"index":2,"status":201,"id":"5f165e2e4de9b442e60b3905"}]}`,
+			wantPlanets:      []string{"Mars", "Venus"},
+			wantOrdered:      false,
+		},
+		{
+			name:      "when an ordered batch has an invalid planet then it should not insert the ones after it",
+			givenBody: `[{"name": "Mars"}, {"name": 1}, {"name": "Venus"}]`,
+			batchInserterMock: planetBatchInserterMock{
+				results: []planet.BatchResult{{Planet: planet.Planet{ID: marsID}}},
+			},
+			wantStatusCode:   207,
+			wantResponseBody: `{"results":[{"index":0,"status":201,"id":"5f165e2e4de9b442e60b3904"},{"index":1,"status":400,"error":{"error_code":"WA:007","message":"failed to decode payload"}},{"index":2,"status":424,"error":{"error_code":"WA:019","message":"not inserted, an earlier planet of the ordered batch failed"}}]}`,
+			wantPlanets:      []string{"Mars"},
+			wantOrdered:      true,
+		},
+		{
+			name:      "when the database stops an ordered batch then it should report the planets after the failure",
+			givenBody: `[{"name": "Mars"}, {"name": "Venus"}]`,
+			batchInserterMock: planetBatchInserterMock{
+				results: []planet.BatchResult{{Err: errors.New("database error")}, {Err: planet.ErrBatchAborted}},
+			},
+			wantStatusCode:   207,
+			wantResponseBody: `{"results":[{"index":0,"status":500,"error":{"error_code":"WA:002","message":"failed to insert the planet"}},{"index":1,"status":424,"error":{"error_code":"WA:019","message":"not inserted, an earlier planet of the ordered batch failed"}}]}`,
+			wantPlanets:      []string{"Mars", "Venus"},
+			wantOrdered:      true,
+		},
+		{
+			name:      "when the database times out on a planet then it should report it with 504",
+			givenBody: `[{"name": "Mars"}, {"name": "Venus"}]`,
+			batchInserterMock: planetBatchInserterMock{
+				results: []planet.BatchResult{{Err: planet.ErrTimeout}, {Err: planet.ErrBatchAborted}},
+			},
+			wantStatusCode:   207,
+			wantResponseBody: `{"results":[{"index":0,"status":504,"error":{"error_code":"WA:034","message":"planet storage timed out"}},{"index":1,"status":424,"error":{"error_code":"WA:019","message":"not inserted, an earlier planet of the ordered batch failed"}}]}`,
+			wantPlanets:      []string{"Mars", "Venus"},
+			wantOrdered:      true,
+		},
+		{
+			name:             "when the batch is empty then it should return 422 status",
+			givenBody:        `[]`,
+			wantStatusCode:   422,
+			wantResponseBody: `{"error_code":"WA:018","message":"invalid batch","details":[{"name":"planets","reason":"must hold between 1 and 500 planets"}]}`,
+		},
+		{
+			name:             "when the payload is not an array then it should return 400 status",
+			givenBody:        `{"name": "Mars"}`,
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:007","message":"failed to decode payload"}`,
+		},
+		{
+			name:             "when ordered is not a boolean then it should return 400 status",
+			givenQuery:       "?ordered=maybe",
+			givenBody:        `[{"name": "Mars"}]`,
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"ordered","reason":"must be true or false"}]}`,
+		},
+		{
+			name:              "when the batch can't be saved then it should return 500 status",
+			givenBody:         `[{"name": "Mars"}]`,
+			batchInserterMock: planetBatchInserterMock{err: errors.New("database error")},
+			wantStatusCode:    500,
+			wantResponseBody:  `{"error_code":"WA:002","message":"failed to insert the planet"}`,
+			wantPlanets:       []string{"Mars"},
+			wantOrdered:       true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotPlanets []planet.Planet
+			var gotOrdered bool
+			inserter := tc.batchInserterMock
+			inserter.gotPlanets = &gotPlanets
+			inserter.gotOrdered = &gotOrdered
+
+			var app App
+			app.container = &container{batchInserter: inserter}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("POST", "/v1/planets:batch"+tc.givenQuery, strings.NewReader(tc.givenBody))
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleCreatePlanetBatch() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleCreatePlanetBatch() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+			var names []string
+			for _, p := range gotPlanets {
+				names = append(names, p.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tc.wantPlanets, ",") || gotOrdered != tc.wantOrdered {
+				t.Errorf("handleCreatePlanetBatch() inserted = %v ordered = %v, want %v ordered = %v", names, gotOrdered, tc.wantPlanets, tc.wantOrdered)
+			}
+		})
+	}
+}
+
+// planetImporterMock upserts by name: names in existing are updated, or
+// skipped when equal, names in errs fail and any other name is inserted.
+type planetImporterMock struct {
+	existing   map[string]planet.Planet
+	errs       map[string]error
+	err        error
+	okBatches  int
+	gotBatches *[][]planet.Planet
+}
+
+func (a planetImporterMock) UpsertByName(ctx context.Context, planets []planet.Planet) ([]planet.UpsertResult, error) {
+	if a.gotBatches != nil {
+		*a.gotBatches = append(*a.gotBatches, append([]planet.Planet(nil), planets...))
+	}
+	if a.err != nil && (a.gotBatches == nil || len(*a.gotBatches) > a.okBatches) {
+		return nil, a.err
+	}
+	results := make([]planet.UpsertResult, len(planets))
+	for i, p := range planets {
+		existing, ok := a.existing[p.Name]
+		switch {
+		case a.errs[p.Name] != nil:
+			results[i].Err = a.errs[p.Name]
+		case ok && reflect.DeepEqual(existing, p):
+			results[i].Outcome = planet.UpsertSkipped
+		case ok:
+			results[i].Outcome = planet.UpsertUpdated
+		default:
+			results[i].Outcome = planet.UpsertInserted
+		}
+	}
+	return results, nil
+}
+
+func Test_handleImportPlanets(t *testing.T) {
+	t.Parallel()
+
+	existing := map[string]planet.Planet{
+		"Tatooine": {Name: "Tatooine", Climate: []string{"arid"}, Diameter: int64Ptr(10465)},
+		"Hoth":     {Name: "Hoth", Climate: []string{"frozen"}},
+	}
+	tests := []struct {
+		name             string
+		givenContentType string
+		givenQuery       string
+		givenBody        string
+		importerMock     planetImporterMock
+		wantStatusCode   int
+		wantResponseBody string
+		wantPlanets      []planet.Planet
+	}{
+		{
+			name:             "when the csv upload is valid then it should upsert every row and return the summary",
+			givenContentType: "text/csv",
+			givenBody:        "Name,Climate,Diameter,Population\nTatooine,arid,10465,\nHoth,\"frozen, temperate\",7200,unknown\nMars,,6779,0\n",
+			importerMock:     planetImporterMock{existing: existing},
+			wantStatusCode:   200,
+			wantResponseBody: `{"inserted":1,"updated":1,"skipped":1,"errors":[]}`,
+			wantPlanets: []planet.Planet{
+				{Name: "Tatooine", Climate: []string{"arid"}, Diameter: int64Ptr(10465)},
+				{Name: "Hoth", Climate: []string{"frozen", "temperate"}, Diameter: int64Ptr(7200)},
+				{Name: "Mars", Climate: []string{}, Diameter: int64Ptr(6779), Population: int64Ptr(0)},
+			},
+		},
+		{
+			name:             "when csv headers are mapped then it should import the mapped columns",
+			givenContentType: "text/csv; charset=utf-8",
+			givenQuery:       "?column[Planet]=name&column[Notes]=-",
+			givenBody:        "Planet,Notes,Surface Water\nKamino,rainy,100\n",
+			importerMock:     planetImporterMock{},
+			wantStatusCode:   200,
+			wantResponseBody: `{"inserted":1,"updated":0,"skipped":0,"errors":[]}`,
+			wantPlanets:      []planet.Planet{{Name: "Kamino", SurfaceWater: float64Ptr(100)}},
+		},
+		{
+			name:             "when csv rows are invalid then it should report their lines and import the others",
+			givenContentType: "text/csv",
+			givenBody:        "name,diameter,surface_water\nMars,big,\nVenus,12104,150\nEarth,12742,71\nJupiter\n",
+			importerMock:     planetImporterMock{errs: map[string]error{"Earth": &planet.AlreadyExistsError{Name: "Earth"}}},
+			wantStatusCode:   200,
+			wantResponseBody: `{"inserted":0,"updated":0,"skipped":0,"errors":[{"line":2,"error_code":"WA:007","message":"failed to decode payload"},{"line":3,"error_code":"WA:001","message":"payload is invalid","details":[{"name":"SurfaceWater","reason":"Key: 'planetRequest.SurfaceWater' Error:Field validation for 'SurfaceWater' failed on the 'max' tag"}]},{"line":4,"error_code":"WA:012","message":"planet already exists","details":[{"name":"name","reason":"already taken by another planet"}]},{"line":5,"error_code":"WA:007","message":"failed to decode payload","details":[{"name":"row","reason":"wrong number of fields"}]}]}`,
+			wantPlanets:      []planet.Planet{{Name: "Earth", Diameter: int64Ptr(12742), SurfaceWater: float64Ptr(71)}},
+		},
+		{
+			name:             "when a csv column is unknown then it should return 400 status",
+			givenContentType: "text/csv",
+			givenBody:        "name,moons\nMars,2\n",
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:020","message":"invalid import columns","details":[{"name":"moons","reason":"unknown column, map it with column[moons]"}]}`,
+		},
+		{
+			name:             "when the ndjson upload is valid then it should upsert every line and skip blank ones",
+			givenContentType: "application/x-ndjson",
+			givenBody:        "{\"name\": \"Hoth\", \"climate\": [\"frozen\"]}\n\n{\"name\": \"Mars\", \"population\": \"unknown\"}\n{\"diameter\": 10}\n{oops\n",
+			importerMock:     planetImporterMock{existing: existing},
+			wantStatusCode:   200,
+			wantResponseBody: `{"inserted":1,"updated":0,"skipped":1,"errors":[{"line":4,"error_code":"WA:001","message":"payload is invalid","details":[{"name":"Name","reason":"Key: 'planetRequest.Name' Error:Field validation for 'Name' failed on the 'required' tag"}]},{"line":5,"error_code":"WA:007","message":"failed to decode payload"}]}`,
+			wantPlanets:      []planet.Planet{{Name: "Hoth", Climate: []string{"frozen"}}, {Name: "Mars"}},
+		},
+		{
+			name:             "when the upload is neither csv nor ndjson then it should return 415 status",
+			givenContentType: "application/json",
+			givenBody:        `[{"name": "Mars"}]`,
+			wantStatusCode:   415,
+			wantResponseBody: `{"error_code":"WA:013","message":"unsupported media type","details":[{"name":"Content-Type","reason":"expected text/csv or application/x-ndjson"}]}`,
+		},
+		{
+			name:             "when the planets can't be saved then it should return 500 status",
+			givenContentType: "application/x-ndjson",
+			givenBody:        `{"name": "Mars"}`,
+			importerMock:     planetImporterMock{err: errors.New("database error")},
+			wantStatusCode:   500,
+			wantResponseBody: `{"inserted":0,"updated":0,"skipped":0,"errors":[],"failed":{"from_line":1,"to_line":1,"error_code":"WA:021","message":"failed to import planets"}}`,
+			wantPlanets:      []planet.Planet{{Name: "Mars"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotBatches [][]planet.Planet
+			importer := tc.importerMock
+			importer.gotBatches = &gotBatches
+
+			var app App
+			app.container = &container{planetImporter: importer}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("POST", "/v1/planets/import"+tc.givenQuery, strings.NewReader(tc.givenBody))
+			req.Header.Set("Content-Type", tc.givenContentType)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleImportPlanets() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleImportPlanets() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+			var gotPlanets []planet.Planet
+			for _, batch := range gotBatches {
+				gotPlanets = append(gotPlanets, batch...)
+			}
+			if !reflect.DeepEqual(gotPlanets, tc.wantPlanets) {
+				t.Errorf("handleImportPlanets() planets = %+v, want %+v", gotPlanets, tc.wantPlanets)
+			}
 		})
 	}
 }
 
+func Test_handleImportPlanets_batches(t *testing.T) {
+	t.Parallel()
+
+	var body strings.Builder
+	for i := 0; i < importBatchSize+1; i++ {
+		fmt.Fprintf(&body, "{\"name\": \"Planet %d\"}\n", i)
+	}
+
+	var gotBatches [][]planet.Planet
+	var app App
+	app.container = &container{planetImporter: planetImporterMock{gotBatches: &gotBatches}}
+	app.RegisterRoutes()
+
+	req, _ := http.NewRequest("POST", "/v1/planets/import", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != 200 || len(gotBatches) != 2 || len(gotBatches[0]) != importBatchSize || len(gotBatches[1]) != 1 {
+		t.Errorf("handleImportPlanets() status code = %v, batches = %v, want 200 and batches of %v and 1", rr.Code, len(gotBatches), importBatchSize)
+	}
+}
+
+func Test_handleImportPlanets_partialFailure(t *testing.T) {
+	t.Parallel()
+
+	var body strings.Builder
+	for i := 0; i < 2*importBatchSize+1; i++ {
+		fmt.Fprintf(&body, "{\"name\": \"Planet %d\"}\n", i)
+	}
+
+	var gotBatches [][]planet.Planet
+	var app App
+	app.container = &container{planetImporter: planetImporterMock{err: planet.ErrTimeout, okBatches: 1, gotBatches: &gotBatches}}
+	app.RegisterRoutes()
+
+	req, _ := http.NewRequest("POST", "/v1/planets/import", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	if rr.Code != 504 || len(gotBatches) != 2 {
+		t.Errorf("handleImportPlanets() status code = %v, batches = %v, want 504 and 2 batches", rr.Code, len(gotBatches))
+	}
+	want := fmt.Sprintf(`{"inserted":%d,"updated":0,"skipped":0,"errors":[],"failed":{"from_line":%d,"to_line":%d,"error_code":"WA:034","message":"planet storage timed out"}}`,
+		importBatchSize, importBatchSize+1, 2*importBatchSize)
+	if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != want {
+		t.Errorf("handleImportPlanets() body = %v, want %v", string(got), want)
+	}
+}
+
 type planetUpdaterMock struct {
-	matchedCount int64
-	err          error
+	result planet.Planet
+	err    error
 }
 
-func (a planetUpdaterMock) Update(ctx context.Context, planet planet.Planet) (int64, error) {
-	return a.matchedCount, a.err
+// Update fails like the service does when the given version isn't the one
+// the planet is at.
+func (a planetUpdaterMock) Update(ctx context.Context, p planet.Planet) (planet.Planet, error) {
+	if a.err == nil && p.Version != 0 && p.Version+1 != a.result.Version {
+		return planet.Planet{}, planet.ErrVersionConflict
+	}
+	return a.result, a.err
 }
 
 func Test_handleUpdatePlanet(t *testing.T) {
@@ -113,19 +533,52 @@ func Test_handleUpdatePlanet(t *testing.T) {
 		name              string
 		givenPlanetID     string
 		givenBody         string
+		givenIfMatch      string
 		planetUpdaterMock planetUpdaterMock
 		wantStatusCode    int
 		wantResponseBody  string
+		wantETag          string
 	}{
 		{
 			name:          "when payload is valid and no error then it should return 204 status",
 			givenPlanetID: "5f165e2e4de9b442e60b3904",
 			givenBody:     `{"name": "Mars"}`,
 			planetUpdaterMock: planetUpdaterMock{
-				matchedCount: 1,
-				err:          nil,
+				result: planet.Planet{Name: "Mars", Version: 4},
+				err:    nil,
+			},
+			wantStatusCode: 204,
+			wantETag:       `"4"`,
+		},
+		{
+			name:          "when if-match holds the current version then it should return 204 status with the new etag",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			givenBody:     `{"name": "Mars"}`,
+			givenIfMatch:  `"3"`,
+			planetUpdaterMock: planetUpdaterMock{
+				result: planet.Planet{Name: "Mars", Version: 4},
 			},
 			wantStatusCode: 204,
+			wantETag:       `"4"`,
+		},
+		{
+			name:          "when if-match holds a stale version then it should return 412 status",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			givenBody:     `{"name": "Mars"}`,
+			givenIfMatch:  `"2"`,
+			planetUpdaterMock: planetUpdaterMock{
+				result: planet.Planet{Name: "Mars", Version: 4},
+			},
+			wantStatusCode:   412,
+			wantResponseBody: `{"error_code":"WA:017","message":"planet was modified by another request"}`,
+		},
+		{
+			name:             "when if-match is a weak etag then it should return 412 status",
+			givenPlanetID:    "5f165e2e4de9b442e60b3904",
+			givenBody:        `{"name": "Mars"}`,
+			givenIfMatch:     `W/"3"`,
+			wantStatusCode:   412,
+			wantResponseBody: `{"error_code":"WA:017","message":"planet was modified by another request"}`,
 		},
 		{
 			name:          "when payload is invalid then it should return 400 status",
@@ -152,23 +605,41 @@ func Test_handleUpdatePlanet(t *testing.T) {
 			givenPlanetID: "5f165e2e4de9b442e60b3905",
 			givenBody:     `{"name": "Mars"}`,
 			planetUpdaterMock: planetUpdaterMock{
-				matchedCount: 0,
-				err:          planet.ErrPlanetNotFound,
+				err: planet.ErrPlanetNotFound,
 			},
 			wantStatusCode:   404,
 			wantResponseBody: `{"error_code":"WA:003","message":"planet not found"}`,
 		},
+		{
+			name:          "when renaming onto a taken name then it should return 409 status with the conflicting planet",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			givenBody:     `{"name": "Venus"}`,
+			planetUpdaterMock: planetUpdaterMock{
+				err: &planet.AlreadyExistsError{Name: "Venus", ExistingID: primitive.ObjectID{0x5f, 0x16, 0x5e, 0x2e, 0x4d, 0xe9, 0xb4, 0x42, 0xe6, 0x0b, 0x39, 0x05}},
+			},
+			wantStatusCode:   409,
+			wantResponseBody: `{"error_code":"WA:012","message":"planet already exists","details":[{"id":"5f165e2e4de9b442e60b3905","name":"name","reason":"already taken by another planet"}]}`,
+		},
 		{
 			name:          "when payload is valid and can't save it then it should return 500 status",
 			givenPlanetID: "5f165e2e4de9b442e60b3904",
 			givenBody:     `{"name": "Mars"}`,
 			planetUpdaterMock: planetUpdaterMock{
-				matchedCount: 0,
-				err:          errors.New("Database Error"),
+				err: errors.New("Database Error"),
 			},
 			wantStatusCode:   500,
 			wantResponseBody: `{"error_code":"WA:001","message":"failed to update the planet"}`,
 		},
+		{
+			name:          "when payload is valid but the database times out then it should return 504 status",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			givenBody:     `{"name": "Mars"}`,
+			planetUpdaterMock: planetUpdaterMock{
+				err: fmt.Errorf("%w: context deadline exceeded", planet.ErrTimeout),
+			},
+			wantStatusCode:   504,
+			wantResponseBody: `{"error_code":"WA:034","message":"planet storage timed out"}`,
+		},
 	}
 
 	for _, tc := range tests {
@@ -178,11 +649,17 @@ func Test_handleUpdatePlanet(t *testing.T) {
 			app.RegisterRoutes()
 
 			req, _ := http.NewRequest("PUT", fmt.Sprintf("/v1/planets/%s", tc.givenPlanetID), strings.NewReader(tc.givenBody))
+			if tc.givenIfMatch != "" {
+				req.Header.Set("If-Match", tc.givenIfMatch)
+			}
 			rr := httptest.NewRecorder()
 			app.ServeHTTP(rr, req)
 			if rr.Code != tc.wantStatusCode {
 				t.Errorf("handleUpdatePlanet() status code = %v, want %v", rr.Code, tc.wantStatusCode)
 			}
+			if got := rr.Header().Get("ETag"); got != tc.wantETag {
+				t.Errorf("handleUpdatePlanet() etag = %v, want %v", got, tc.wantETag)
+			}
 			if got, _ := ioutil.ReadAll(rr.Result().Body); tc.wantResponseBody != "" && string(got) != tc.wantResponseBody {
 				t.Errorf("handleUpdatePlanet() body = %v, want %v", string(got), tc.wantResponseBody)
 			}
@@ -203,22 +680,70 @@ func Test_handleGetAccount(t *testing.T) {
 	t.Parallel()
 
 	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	updatedAt := time.Date(2021, 12, 27, 10, 30, 15, 500, time.UTC)
 	tests := []struct {
 		name             string
 		givenPlanetID    string
+		givenHeaders     map[string]string
 		planetGetterMock planetGetterMock
 		wantStatusCode   int
 		wantResponseBody string
+		wantETag         string
 	}{
 		{
 			name:          "when planet id is informed and no error then it should return 200 status",
 			givenPlanetID: "5f165e2e4de9b442e60b3904",
 			planetGetterMock: planetGetterMock{
-				result: planet.Planet{ID: objectID, Name: "Mars"},
+				result: planet.Planet{ID: objectID, Name: "Mars", Version: 3},
 				err:    nil,
 			},
 			wantStatusCode:   200,
-			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars"}`,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+			wantETag:         `"3"`,
+		},
+		{
+			name:          "when if-none-match holds the current version then it should return 304 status",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			givenHeaders:  map[string]string{"If-None-Match": `"2", W/"3"`},
+			planetGetterMock: planetGetterMock{
+				result: planet.Planet{ID: objectID, Name: "Mars", Version: 3, UpdatedAt: updatedAt},
+			},
+			wantStatusCode:   304,
+			wantResponseBody: "",
+			wantETag:         `"3"`,
+		},
+		{
+			name:          "when if-none-match holds a stale version then it should return 200 status",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			givenHeaders:  map[string]string{"If-None-Match": `"2"`, "If-Modified-Since": "Mon, 27 Dec 2021 10:30:15 GMT"},
+			planetGetterMock: planetGetterMock{
+				result: planet.Planet{ID: objectID, Name: "Mars", Version: 3, UpdatedAt: updatedAt},
+			},
+			wantStatusCode:   200,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+			wantETag:         `"3"`,
+		},
+		{
+			name:          "when the planet wasn't modified since if-modified-since then it should return 304 status",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			givenHeaders:  map[string]string{"If-Modified-Since": "Mon, 27 Dec 2021 10:30:15 GMT"},
+			planetGetterMock: planetGetterMock{
+				result: planet.Planet{ID: objectID, Name: "Mars", Version: 3, UpdatedAt: updatedAt},
+			},
+			wantStatusCode:   304,
+			wantResponseBody: "",
+			wantETag:         `"3"`,
+		},
+		{
+			name:          "when the planet was modified after if-modified-since then it should return 200 status",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			givenHeaders:  map[string]string{"If-Modified-Since": "Mon, 27 Dec 2021 10:30:14 GMT"},
+			planetGetterMock: planetGetterMock{
+				result: planet.Planet{ID: objectID, Name: "Mars", Version: 3, UpdatedAt: updatedAt},
+			},
+			wantStatusCode:   200,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+			wantETag:         `"3"`,
 		},
 		{
 			name:          "when planet id is informed but got generic error from database then it should return 500 status",
@@ -230,6 +755,15 @@ func Test_handleGetAccount(t *testing.T) {
 			wantStatusCode:   500,
 			wantResponseBody: `{"error_code":"WA:004","message":"failed to retrieve a planet by id"}`,
 		},
+		{
+			name:          "when planet id is informed but the database times out then it should return 504 status",
+			givenPlanetID: "5f165e2e4de9b442e60b3904",
+			planetGetterMock: planetGetterMock{
+				err: fmt.Errorf("%w: context deadline exceeded", planet.ErrTimeout),
+			},
+			wantStatusCode:   504,
+			wantResponseBody: `{"error_code":"WA:034","message":"planet storage timed out"}`,
+		},
 		{
 			name:          "when planet id is informed but couldn't find in database then it should return 404",
 			givenPlanetID: "5f165e2e4de9b442e60b3904",
@@ -248,14 +782,1289 @@ func Test_handleGetAccount(t *testing.T) {
 			app.RegisterRoutes()
 
 			req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/planets/%s", tc.givenPlanetID), nil)
+			for key, value := range tc.givenHeaders {
+				req.Header.Set(key, value)
+			}
 			rr := httptest.NewRecorder()
 			app.ServeHTTP(rr, req)
 			if rr.Code != tc.wantStatusCode {
 				t.Errorf("handleGetPlanet() status code = %v, want %v", rr.Code, tc.wantStatusCode)
 			}
-			if got, _ := ioutil.ReadAll(rr.Result().Body); tc.wantResponseBody != "" && string(got) != tc.wantResponseBody {
+			if got := rr.Header().Get("ETag"); got != tc.wantETag {
+				t.Errorf("handleGetPlanet() etag = %v, want %v", got, tc.wantETag)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); (tc.wantResponseBody != "" || tc.wantStatusCode == 304) && string(got) != tc.wantResponseBody {
 				t.Errorf("handleGetPlanet() body = %v, want %v", string(got), tc.wantResponseBody)
 			}
 		})
 	}
 }
+
+type planetHistoryReaderMock struct {
+	result planet.Revision
+	err    error
+	gotAt  *time.Time
+}
+
+func (a planetHistoryReaderMock) RevisionAt(ctx context.Context, id string, at time.Time) (planet.Revision, error) {
+	*a.gotAt = at
+	return a.result, a.err
+}
+
+func Test_handleGetPlanetAsOf(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	tests := []struct {
+		name                string
+		givenAsOf           string
+		givenHeaders        map[string]string
+		historyReaderMock   planetHistoryReaderMock
+		wantStatusCode      int
+		wantResponseBody    string
+		wantAt              time.Time
+		wantMementoDatetime string
+		wantETag            string
+	}{
+		{
+			name:                "when the planet existed at that time then it should return it as it was",
+			givenAsOf:           "2021-12-27T12:30:15+02:00",
+			historyReaderMock:   planetHistoryReaderMock{result: planet.Revision{Version: 2, After: planet.Planet{ID: objectID, Name: "Mars", Version: 2, UpdatedAt: time.Date(2021, 12, 26, 9, 0, 0, 0, time.UTC)}}},
+			wantStatusCode:      200,
+			wantResponseBody:    `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+			wantAt:              time.Date(2021, 12, 27, 10, 30, 15, 0, time.UTC),
+			wantMementoDatetime: "Mon, 27 Dec 2021 10:30:15 GMT",
+			wantETag:            `W/"r2"`,
+		},
+		{
+			name:                "when the client holds the live planet of the same version then it should not answer 304",
+			givenAsOf:           "2021-12-27T10:30:15Z",
+			givenHeaders:        map[string]string{"If-None-Match": `"2"`, "If-Modified-Since": "Mon, 27 Dec 2021 10:30:15 GMT"},
+			historyReaderMock:   planetHistoryReaderMock{result: planet.Revision{Version: 2, After: planet.Planet{ID: objectID, Name: "Mars", Version: 2}}},
+			wantStatusCode:      200,
+			wantResponseBody:    `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+			wantAt:              time.Date(2021, 12, 27, 10, 30, 15, 0, time.UTC),
+			wantMementoDatetime: "Mon, 27 Dec 2021 10:30:15 GMT",
+			wantETag:            `W/"r2"`,
+		},
+		{
+			name:                "when the client holds the same past planet then it should return 304 status",
+			givenAsOf:           "2021-12-27T10:30:15Z",
+			givenHeaders:        map[string]string{"If-None-Match": `W/"r2"`},
+			historyReaderMock:   planetHistoryReaderMock{result: planet.Revision{Version: 2, After: planet.Planet{ID: objectID, Name: "Mars", Version: 2}}},
+			wantStatusCode:      304,
+			wantAt:              time.Date(2021, 12, 27, 10, 30, 15, 0, time.UTC),
+			wantMementoDatetime: "Mon, 27 Dec 2021 10:30:15 GMT",
+			wantETag:            `W/"r2"`,
+		},
+		{
+			name:                "when the planet did not exist yet then it should return 404 status",
+			givenAsOf:           "2021-12-27T10:30:15Z",
+			historyReaderMock:   planetHistoryReaderMock{err: planet.ErrPlanetNotFound},
+			wantStatusCode:      404,
+			wantResponseBody:    `{"error_code":"WA:003","message":"planet not found"}`,
+			wantAt:              time.Date(2021, 12, 27, 10, 30, 15, 0, time.UTC),
+			wantMementoDatetime: "Mon, 27 Dec 2021 10:30:15 GMT",
+		},
+		{
+			name:             "when as_of is not a date-time then it should return 400 status",
+			givenAsOf:        "last-tuesday",
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"as_of","reason":"must be an RFC3339 date-time"}]}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotAt time.Time
+			reader := tc.historyReaderMock
+			reader.gotAt = &gotAt
+
+			var app App
+			app.container = &container{historyReader: reader}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", "/v1/planets/5f165e2e4de9b442e60b3904?as_of="+url.QueryEscape(tc.givenAsOf), nil)
+			for key, value := range tc.givenHeaders {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleGetPlanet() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleGetPlanet() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+			if !gotAt.Equal(tc.wantAt) {
+				t.Errorf("handleGetPlanet() as of = %v, want %v", gotAt, tc.wantAt)
+			}
+			if got := rr.Header().Get("Memento-Datetime"); got != tc.wantMementoDatetime {
+				t.Errorf("handleGetPlanet() memento datetime = %v, want %v", got, tc.wantMementoDatetime)
+			}
+			if got := rr.Header().Get("ETag"); got != tc.wantETag {
+				t.Errorf("handleGetPlanet() etag = %v, want %v", got, tc.wantETag)
+			}
+			if got := rr.Header().Get("Last-Modified"); got != "" {
+				t.Errorf("handleGetPlanet() last modified = %v, want none for a past planet", got)
+			}
+		})
+	}
+}
+
+type planetListerMock struct {
+	result planet.Page
+	err    error
+}
+
+func (a planetListerMock) List(ctx context.Context, opts planet.ListOptions) (planet.Page, error) {
+	return a.result, a.err
+}
+
+func Test_handleListPlanets(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	tests := []struct {
+		name             string
+		givenQuery       string
+		planetListerMock planetListerMock
+		wantStatusCode   int
+		wantResponseBody string
+		wantLinkHeader   string
+	}{
+		{
+			name:       "when there are more planets then it should return 200 status with the next cursor",
+			givenQuery: "?limit=1",
+			planetListerMock: planetListerMock{
+				result: planet.Page{Planets: []planet.Planet{{ID: objectID, Name: "Mars"}}, NextCursor: "XxZeLk3ptELmCzkE"},
+			},
+			wantStatusCode:   200,
+			wantResponseBody: `{"planets":[{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}],"next_cursor":"XxZeLk3ptELmCzkE"}`,
+			wantLinkHeader:   `</v1/planets?limit=1>; rel="first", </v1/planets?cursor=XxZeLk3ptELmCzkE&limit=1>; rel="next"`,
+		},
+		{
+			name:       "when there are no planets then it should return 200 status with an empty list",
+			givenQuery: "",
+			planetListerMock: planetListerMock{
+				result: planet.Page{Planets: []planet.Planet{}},
+			},
+			wantStatusCode:   200,
+			wantResponseBody: `{"planets":[]}`,
+			wantLinkHeader:   `</v1/planets>; rel="first"`,
+		},
+		{
+			name:             "when limit is not a number then it should return 400 status",
+			givenQuery:       "?limit=abc",
+			planetListerMock: planetListerMock{},
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"limit","reason":"must be an integer between 1 and 100"}]}`,
+		},
+		{
+			name:             "when filtering by an unknown field then it should return 400 status",
+			givenQuery:       "?color=red",
+			planetListerMock: planetListerMock{},
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"color","reason":"unknown field 'color'"}]}`,
+		},
+		{
+			name:       "when cursor is invalid then it should return 400 status",
+			givenQuery: "?cursor=abc",
+			planetListerMock: planetListerMock{
+				err: planet.ErrInvalidCursor,
+			},
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"cursor","reason":"invalid cursor"}]}`,
+		},
+		{
+			name:       "when got generic error from database then it should return 500 status",
+			givenQuery: "",
+			planetListerMock: planetListerMock{
+				err: errors.New("database error"),
+			},
+			wantStatusCode:   500,
+			wantResponseBody: `{"error_code":"WA:009","message":"failed to list planets"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{planetLister: tc.planetListerMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", "/v1/planets"+tc.givenQuery, nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleListPlanets() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleListPlanets() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+			if got := rr.Header().Get("Link"); got != tc.wantLinkHeader {
+				t.Errorf("handleListPlanets() link = %v, want %v", got, tc.wantLinkHeader)
+			}
+		})
+	}
+}
+
+type planetListerAsOfMock struct {
+	gotOpts *planet.ListOptions
+}
+
+func (a planetListerAsOfMock) List(ctx context.Context, opts planet.ListOptions) (planet.Page, error) {
+	*a.gotOpts = opts
+	return planet.Page{Planets: []planet.Planet{}}, nil
+}
+
+func Test_handleListPlanets_asOf(t *testing.T) {
+	t.Parallel()
+
+	var gotOpts planet.ListOptions
+	var app App
+	app.container = &container{planetLister: planetListerAsOfMock{gotOpts: &gotOpts}}
+	app.RegisterRoutes()
+
+	req, _ := http.NewRequest("GET", "/v1/planets?as_of=2021-12-27T10:30:15Z&climate=arid", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("handleListPlanets() status code = %v, want 200", rr.Code)
+	}
+	if want := time.Date(2021, 12, 27, 10, 30, 15, 0, time.UTC); !gotOpts.AsOf.Equal(want) {
+		t.Errorf("handleListPlanets() as of = %v, want %v", gotOpts.AsOf, want)
+	}
+	if len(gotOpts.Query.Filters) != 1 {
+		t.Errorf("handleListPlanets() as_of should not be read as a filter, got %v", gotOpts.Query.Filters)
+	}
+	if got := rr.Header().Get("Memento-Datetime"); got != "Mon, 27 Dec 2021 10:30:15 GMT" {
+		t.Errorf("handleListPlanets() memento datetime = %v, want Mon, 27 Dec 2021 10:30:15 GMT", got)
+	}
+	if got, live := rr.Header().Get("ETag"), pageETag(planet.Page{}, time.Time{}); got == live {
+		t.Errorf("handleListPlanets() etag = %v, want a tag differing from the live page", got)
+	}
+}
+
+func Test_handleListPlanets_notModified(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	page := planet.Page{Planets: []planet.Planet{{ID: objectID, Name: "Mars", Version: 1}}}
+
+	list := func(page planet.Page, ifNoneMatch string) *httptest.ResponseRecorder {
+		var app App
+		app.container = &container{planetLister: planetListerMock{result: page}}
+		app.RegisterRoutes()
+
+		req, _ := http.NewRequest("GET", "/v1/planets", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		app.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := list(page, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != 200 || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("handleListPlanets() status code = %v, etag = %v, want 200 and a weak etag", first.Code, etag)
+	}
+
+	if got := list(page, etag); got.Code != 304 || got.Body.Len() != 0 {
+		t.Errorf("handleListPlanets() status code = %v, want 304 without body", got.Code)
+	}
+
+	changed := planet.Page{Planets: []planet.Planet{{ID: objectID, Name: "Red Mars", Version: 2}}}
+	if got := list(changed, etag); got.Code != 200 || got.Header().Get("ETag") == etag {
+		t.Errorf("handleListPlanets() status code = %v, want 200 with a new etag", got.Code)
+	}
+}
+
+type planetExporterMock struct {
+	planets  []planet.Planet
+	err      error
+	gotQuery *planet.Query
+	gotAt    *time.Time
+}
+
+func (a planetExporterMock) Export(ctx context.Context, query planet.Query, at time.Time, each func(planet.Planet) error) error {
+	if a.gotQuery != nil {
+		*a.gotQuery = query
+	}
+	if a.gotAt != nil {
+		*a.gotAt = at
+	}
+	for _, p := range a.planets {
+		if err := each(p); err != nil {
+			return err
+		}
+	}
+	return a.err
+}
+
+func Test_handleExportPlanets(t *testing.T) {
+	t.Parallel()
+
+	marsID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	hothID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3905")
+	planets := []planet.Planet{
+		{ID: marsID, Name: "Mars", Diameter: int64Ptr(6779), Gravity: float64Ptr(0.38)},
+		{ID: hothID, Name: "Hoth", Climate: []string{"frozen", "icy"}, FilmCount: 1, Films: []string{"https://swapi.dev/api/films/2/"}},
+	}
+	tests := []struct {
+		name             string
+		givenQuery       string
+		givenAccept      string
+		exporterMock     planetExporterMock
+		wantStatusCode   int
+		wantContentType  string
+		wantResponseBody string
+		wantQuery        planet.Query
+		wantAt           time.Time
+	}{
+		{
+			name:            "when no format is asked then it should stream ndjson",
+			exporterMock:    planetExporterMock{planets: planets},
+			wantStatusCode:  200,
+			wantContentType: "application/x-ndjson",
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":6779,"climate":[],"gravity":0.38,"terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}` + "\n" +
+				`{"id":"5f165e2e4de9b442e60b3905","name":"Hoth","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":["frozen","icy"],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":1,"films":["https://swapi.dev/api/films/2/"]}` + "\n",
+		},
+		{
+			name:            "when csv is preferred then it should stream csv with the listing filters",
+			givenQuery:      "?climate=frozen&sort=-diameter",
+			givenAccept:     "application/x-ndjson;q=0.5, text/csv",
+			exporterMock:    planetExporterMock{planets: planets},
+			wantStatusCode:  200,
+			wantContentType: "text/csv",
+			wantResponseBody: "id,name,rotation_period,orbital_period,diameter,climate,gravity,terrain,surface_water,population,film_count\n" +
+				"5f165e2e4de9b442e60b3904,Mars,unknown,unknown,6779,,0.38,,unknown,unknown,0\n" +
+				"5f165e2e4de9b442e60b3905,Hoth,unknown,unknown,unknown,\"frozen, icy\",unknown,,unknown,unknown,1\n",
+			wantQuery: planet.Query{
+				Filters: []planet.Filter{{Field: "climate", Operator: planet.OpEq, Value: "frozen"}},
+				Sort:    []planet.SortField{{Field: "diameter", Descending: true}},
+			},
+		},
+		{
+			name:             "when there are no planets then the csv should only have the header",
+			givenAccept:      "text/*",
+			exporterMock:     planetExporterMock{},
+			wantStatusCode:   200,
+			wantContentType:  "text/csv",
+			wantResponseBody: "id,name,rotation_period,orbital_period,diameter,climate,gravity,terrain,surface_water,population,film_count\n",
+		},
+		{
+			name:             "when no export format is acceptable then it should return 406 status",
+			givenAccept:      "application/xml, text/csv;q=0",
+			wantStatusCode:   406,
+			wantContentType:  "application/json",
+			wantResponseBody: `{"error_code":"WA:022","message":"not acceptable","details":[{"name":"Accept","reason":"expected application/x-ndjson or text/csv"}]}`,
+		},
+		{
+			name:             "when a filter is invalid then it should return 400 status",
+			givenQuery:       "?moons=2",
+			wantStatusCode:   400,
+			wantContentType:  "application/json",
+			wantResponseBody: `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"moons","reason":"unknown field 'moons'"}]}`,
+		},
+		{
+			name:             "when as_of is given then it should export the planets as they were at that time",
+			givenQuery:       "?as_of=2021-12-27T10:30:14Z",
+			exporterMock:     planetExporterMock{planets: planets[:1]},
+			wantStatusCode:   200,
+			wantContentType:  "application/x-ndjson",
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":6779,"climate":[],"gravity":0.38,"terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}` + "\n",
+			wantAt:           time.Date(2021, 12, 27, 10, 30, 14, 0, time.UTC),
+		},
+		{
+			name:             "when as_of is not a date-time then it should return 400 status",
+			givenQuery:       "?as_of=yesterday",
+			wantStatusCode:   400,
+			wantContentType:  "application/json",
+			wantResponseBody: `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"as_of","reason":"must be an RFC3339 date-time"}]}`,
+		},
+		{
+			name:             "when the export fails before any planet then it should return 500 status",
+			exporterMock:     planetExporterMock{err: errors.New("database error")},
+			wantStatusCode:   500,
+			wantContentType:  "application/json",
+			wantResponseBody: `{"error_code":"WA:023","message":"failed to export planets"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotQuery planet.Query
+			var gotAt time.Time
+			exporter := tc.exporterMock
+			exporter.gotQuery = &gotQuery
+			exporter.gotAt = &gotAt
+
+			var app App
+			app.container = &container{planetExporter: exporter}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", "/v1/planets/export"+tc.givenQuery, nil)
+			if tc.givenAccept != "" {
+				req.Header.Set("Accept", tc.givenAccept)
+			}
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleExportPlanets() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tc.wantContentType {
+				t.Errorf("handleExportPlanets() content type = %v, want %v", got, tc.wantContentType)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleExportPlanets() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+			if !reflect.DeepEqual(gotQuery, tc.wantQuery) {
+				t.Errorf("handleExportPlanets() query = %+v, want %+v", gotQuery, tc.wantQuery)
+			}
+			if !gotAt.Equal(tc.wantAt) {
+				t.Errorf("handleExportPlanets() at = %v, want %v", gotAt, tc.wantAt)
+			}
+		})
+	}
+}
+
+type planetDeleterMock struct {
+	err error
+}
+
+func (a planetDeleterMock) Delete(ctx context.Context, id string) error {
+	return a.err
+}
+
+func Test_handleDeletePlanet(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		planetDeleterMock planetDeleterMock
+		wantStatusCode    int
+		wantResponseBody  string
+	}{
+		{
+			name:              "when the planet exists then it should return 204 status",
+			planetDeleterMock: planetDeleterMock{},
+			wantStatusCode:    204,
+		},
+		{
+			name:              "when the planet not found then it should return 404 status",
+			planetDeleterMock: planetDeleterMock{err: planet.ErrPlanetNotFound},
+			wantStatusCode:    404,
+			wantResponseBody:  `{"error_code":"WA:003","message":"planet not found"}`,
+		},
+		{
+			name:              "when got generic error from database then it should return 500 status",
+			planetDeleterMock: planetDeleterMock{err: errors.New("database error")},
+			wantStatusCode:    500,
+			wantResponseBody:  `{"error_code":"WA:010","message":"failed to delete the planet"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{planetDeleter: tc.planetDeleterMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("DELETE", "/v1/planets/5f165e2e4de9b442e60b3904", nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleDeletePlanet() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); tc.wantResponseBody != "" && string(got) != tc.wantResponseBody {
+				t.Errorf("handleDeletePlanet() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+type trashListerMock struct {
+	result planet.Page
+	err    error
+}
+
+func (a trashListerMock) ListTrash(ctx context.Context, opts planet.ListOptions) (planet.Page, error) {
+	return a.result, a.err
+}
+
+func Test_handleListTrash(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	deletedAt := time.Date(2021, 12, 27, 10, 0, 0, 0, time.UTC)
+
+	var app App
+	app.container = &container{trashLister: trashListerMock{
+		result: planet.Page{Planets: []planet.Planet{{ID: objectID, Name: "Mars", DeletedAt: &deletedAt}}},
+	}}
+	app.RegisterRoutes()
+
+	req, _ := http.NewRequest("GET", "/v1/planets/trash", nil)
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+
+	wantResponseBody := `{"planets":[{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[],"deleted_at":"2021-12-27T10:00:00Z"}]}`
+	if rr.Code != 200 {
+		t.Errorf("handleListTrash() status code = %v, want %v", rr.Code, 200)
+	}
+	if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != wantResponseBody {
+		t.Errorf("handleListTrash() body = %v, want %v", string(got), wantResponseBody)
+	}
+}
+
+type planetRestorerMock struct {
+	result planet.Planet
+	err    error
+}
+
+func (a planetRestorerMock) Restore(ctx context.Context, id string) (planet.Planet, error) {
+	return a.result, a.err
+}
+
+func Test_handleRestorePlanet(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	tests := []struct {
+		name               string
+		planetRestorerMock planetRestorerMock
+		wantStatusCode     int
+		wantResponseBody   string
+	}{
+		{
+			name:               "when the planet is in the trash then it should return 200 status",
+			planetRestorerMock: planetRestorerMock{result: planet.Planet{ID: objectID, Name: "Mars"}},
+			wantStatusCode:     200,
+			wantResponseBody:   `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+		},
+		{
+			name:               "when the planet is not in the trash then it should return 404 status",
+			planetRestorerMock: planetRestorerMock{err: planet.ErrPlanetNotFound},
+			wantStatusCode:     404,
+			wantResponseBody:   `{"error_code":"WA:003","message":"planet not found"}`,
+		},
+		{
+			name:               "when got generic error from database then it should return 500 status",
+			planetRestorerMock: planetRestorerMock{err: errors.New("database error")},
+			wantStatusCode:     500,
+			wantResponseBody:   `{"error_code":"WA:011","message":"failed to restore the planet"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{planetRestorer: tc.planetRestorerMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("POST", "/v1/planets/5f165e2e4de9b442e60b3904/restore", nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleRestorePlanet() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleRestorePlanet() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+type planetPatcherMock struct {
+	result     planet.Planet
+	err        error
+	gotFields  *[]string
+	gotChanges *planet.Planet
+}
+
+func (a planetPatcherMock) Patch(ctx context.Context, id string, changes planet.Planet, fields []string) (planet.Planet, error) {
+	if a.gotFields != nil {
+		*a.gotFields = fields
+	}
+	if a.gotChanges != nil {
+		*a.gotChanges = changes
+	}
+	return a.result, a.err
+}
+
+func Test_handlePatchPlanet(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	mars := planet.Planet{ID: objectID, Name: "Mars", Climate: []string{"frozen"}, Population: int64Ptr(0), Version: 3}
+
+	tests := []struct {
+		name             string
+		givenContentType string
+		givenBody        string
+		givenIfMatch     string
+		planetGetterMock planetGetterMock
+		planetPatcher    planetPatcherMock
+		wantStatusCode   int
+		wantResponseBody string
+		wantFields       []string
+		wantETag         string
+		wantVersion      int64
+	}{
+		{
+			name:             "when the merge patch is valid then it should write only the changed fields and return 200 status",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"diameter": 6779, "climate": null, "population": 0}`,
+			planetGetterMock: planetGetterMock{result: mars},
+			planetPatcher: planetPatcherMock{
+				result: planet.Planet{ID: objectID, Name: "Mars", Diameter: int64Ptr(6779), Population: int64Ptr(0), Version: 4},
+			},
+			wantStatusCode:   200,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":6779,"climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":0,"film_count":0,"films":[]}`,
+			wantFields:       []string{"climate", "diameter"},
+			wantETag:         `"4"`,
+			wantVersion:      3,
+		},
+		{
+			name:             "when if-match holds the current version then it should patch and return the new etag",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"diameter": 6779}`,
+			givenIfMatch:     `"3"`,
+			planetGetterMock: planetGetterMock{result: mars},
+			planetPatcher: planetPatcherMock{
+				result: planet.Planet{ID: objectID, Name: "Mars", Diameter: int64Ptr(6779), Population: int64Ptr(0), Version: 4},
+			},
+			wantStatusCode:   200,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":6779,"climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":0,"film_count":0,"films":[]}`,
+			wantETag:         `"4"`,
+			wantVersion:      3,
+		},
+		{
+			name:             "when if-match holds a stale version then it should return 412 status",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"diameter": 6779}`,
+			givenIfMatch:     `"2"`,
+			planetGetterMock: planetGetterMock{result: mars},
+			wantStatusCode:   412,
+			wantResponseBody: `{"error_code":"WA:017","message":"planet was modified by another request"}`,
+		},
+		{
+			name:             "when the planet changes while patching then it should return 412 status",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"diameter": 6779}`,
+			planetGetterMock: planetGetterMock{result: mars},
+			planetPatcher:    planetPatcherMock{err: planet.ErrVersionConflict},
+			wantStatusCode:   412,
+			wantResponseBody: `{"error_code":"WA:017","message":"planet was modified by another request"}`,
+		},
+		{
+			name:             "when the content type is not a patch then it should return 415 status",
+			givenContentType: "application/json",
+			givenBody:        `{"diameter": 6779}`,
+			wantStatusCode:   415,
+			wantResponseBody: `{"error_code":"WA:013","message":"unsupported media type","details":[{"name":"Content-Type","reason":"expected application/merge-patch+json or application/json-patch+json"}]}`,
+		},
+		{
+			name:             "when the json patch is valid then it should write only the changed fields and return 200 status",
+			givenContentType: "application/json-patch+json",
+			givenBody:        `[{"op":"test","path":"/climate/0","value":"frozen"},{"op":"add","path":"/climate/-","value":"arid"},{"op":"add","path":"/terrain","value":["desert"]},{"op":"replace","path":"/population","value":"unknown"}]`,
+			planetGetterMock: planetGetterMock{result: mars},
+			planetPatcher: planetPatcherMock{
+				result: planet.Planet{ID: objectID, Name: "Mars", Climate: []string{"frozen", "arid"}, Terrain: []string{"desert"}, Version: 4},
+			},
+			wantStatusCode:   200,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":["frozen","arid"],"gravity":"unknown","terrain":["desert"],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+			wantFields:       []string{"climate", "population", "terrain"},
+			wantETag:         `"4"`,
+		},
+		{
+			name:             "when a json patch test fails then it should return 409 status with the operation index",
+			givenContentType: "application/json-patch+json",
+			givenBody:        `[{"op":"add","path":"/climate/-","value":"arid"},{"op":"test","path":"/climate/0","value":"temperate"}]`,
+			planetGetterMock: planetGetterMock{result: mars},
+			wantStatusCode:   409,
+			wantResponseBody: `{"error_code":"WA:015","message":"patch test failed","details":[{"index":"1","op":"test","path":"/climate/0","reason":"tested value does not match"}]}`,
+		},
+		{
+			name:             "when a json patch operation can't be applied then it should return 422 status with the operation index",
+			givenContentType: "application/json-patch+json",
+			givenBody:        `[{"op":"remove","path":"/climate/3"}]`,
+			planetGetterMock: planetGetterMock{result: mars},
+			wantStatusCode:   422,
+			wantResponseBody: `{"error_code":"WA:016","message":"failed to apply the patch","details":[{"index":"0","op":"remove","path":"/climate/3","reason":"array index 3 out of bounds"}]}`,
+		},
+		{
+			name:             "when the json patch is not an array then it should return 400 status",
+			givenContentType: "application/json-patch+json",
+			givenBody:        `{"op":"remove","path":"/climate"}`,
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:007","message":"failed to decode payload"}`,
+		},
+		{
+			name:             "when the patch removes the name then it should return 422 status",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"name": null}`,
+			planetGetterMock: planetGetterMock{result: mars},
+			wantStatusCode:   422,
+			wantResponseBody: `{"error_code":"WA:001","message":"payload is invalid","details":[{"name":"Name","reason":"Key: 'planetRequest.Name' Error:Field validation for 'Name' failed on the 'required' tag"}]}`,
+		},
+		{
+			name:             "when the patch has an unknown member then it should return 400 status",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"film_count": 10}`,
+			planetGetterMock: planetGetterMock{result: mars},
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:007","message":"failed to decode payload"}`,
+		},
+		{
+			name:             "when the planet not found then it should return 404 status",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"diameter": 6779}`,
+			planetGetterMock: planetGetterMock{err: planet.ErrPlanetNotFound},
+			wantStatusCode:   404,
+			wantResponseBody: `{"error_code":"WA:003","message":"planet not found"}`,
+		},
+		{
+			name:             "when renaming onto a taken name then it should return 409 status",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"name": "Venus"}`,
+			planetGetterMock: planetGetterMock{result: mars},
+			planetPatcher:    planetPatcherMock{err: &planet.AlreadyExistsError{Name: "Venus"}},
+			wantStatusCode:   409,
+			wantResponseBody: `{"error_code":"WA:012","message":"planet already exists","details":[{"name":"name","reason":"already taken by another planet"}]}`,
+		},
+		{
+			name:             "when the patch can't be saved then it should return 500 status",
+			givenContentType: "application/merge-patch+json",
+			givenBody:        `{"diameter": 6779}`,
+			planetGetterMock: planetGetterMock{result: mars},
+			planetPatcher:    planetPatcherMock{err: errors.New("database error")},
+			wantStatusCode:   500,
+			wantResponseBody: `{"error_code":"WA:014","message":"failed to patch the planet"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotFields []string
+			var gotChanges planet.Planet
+			patcher := tc.planetPatcher
+			patcher.gotFields = &gotFields
+			patcher.gotChanges = &gotChanges
+
+			var app App
+			app.container = &container{uncachedGetter: tc.planetGetterMock, planetPatcher: patcher}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("PATCH", "/v1/planets/5f165e2e4de9b442e60b3904", strings.NewReader(tc.givenBody))
+			req.Header.Set("Content-Type", tc.givenContentType)
+			if tc.givenIfMatch != "" {
+				req.Header.Set("If-Match", tc.givenIfMatch)
+			}
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handlePatchPlanet() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handlePatchPlanet() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+			if tc.wantFields != nil && strings.Join(gotFields, ",") != strings.Join(tc.wantFields, ",") {
+				t.Errorf("handlePatchPlanet() fields = %v, want %v", gotFields, tc.wantFields)
+			}
+			if got := rr.Header().Get("ETag"); got != tc.wantETag {
+				t.Errorf("handlePatchPlanet() etag = %v, want %v", got, tc.wantETag)
+			}
+			if tc.wantVersion != 0 && gotChanges.Version != tc.wantVersion {
+				t.Errorf("handlePatchPlanet() version = %v, want %v", gotChanges.Version, tc.wantVersion)
+			}
+		})
+	}
+}
+
+type revisionListerMock struct {
+	result []planet.Revision
+	err    error
+}
+
+func (a revisionListerMock) Revisions(ctx context.Context, id string) ([]planet.Revision, error) {
+	return a.result, a.err
+}
+
+func Test_handleListRevisions(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	created := time.Date(2021, 7, 21, 12, 0, 0, 0, time.UTC)
+	mars := planet.Planet{ID: objectID, Name: "Mars", Version: 1}
+	frozenMars := planet.Planet{ID: objectID, Name: "Mars", Climate: []string{"frozen"}, Version: 2}
+	tests := []struct {
+		name               string
+		revisionListerMock revisionListerMock
+		wantStatusCode     int
+		wantResponseBody   string
+	}{
+		{
+			name: "when the planet has revisions then it should list them with the fields they changed",
+			revisionListerMock: revisionListerMock{result: []planet.Revision{
+				{PlanetID: objectID, Version: 1, Timestamp: created, After: mars},
+				{PlanetID: objectID, Version: 2, Timestamp: created.Add(time.Hour), RequestID: "abc123", Actor: "rebels", Before: &mars, After: frozenMars},
+			}},
+			wantStatusCode:   200,
+			wantResponseBody: `{"revisions":[{"version":1,"timestamp":"2021-07-21T12:00:00Z","changed_fields":["climate","diameter","film_count","films","gravity","id","name","orbital_period","population","rotation_period","surface_water","terrain"]},{"version":2,"timestamp":"2021-07-21T13:00:00Z","request_id":"abc123","actor":"rebels","changed_fields":["climate"]}]}`,
+		},
+		{
+			name:               "when the planet does not exist then it should return 404 status",
+			revisionListerMock: revisionListerMock{err: planet.ErrPlanetNotFound},
+			wantStatusCode:     404,
+			wantResponseBody:   `{"error_code":"WA:003","message":"planet not found"}`,
+		},
+		{
+			name:               "when got generic error from database then it should return 500 status",
+			revisionListerMock: revisionListerMock{err: errors.New("database error")},
+			wantStatusCode:     500,
+			wantResponseBody:   `{"error_code":"WA:025","message":"failed to retrieve the revisions"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{revisionLister: tc.revisionListerMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", "/v1/planets/5f165e2e4de9b442e60b3904/revisions", nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleListRevisions() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleListRevisions() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+type revisionGetterMock struct {
+	revisions []planet.Revision
+	err       error
+}
+
+func (a revisionGetterMock) Revision(ctx context.Context, id string, version int64) (planet.Revision, error) {
+	if a.err != nil {
+		return planet.Revision{}, a.err
+	}
+	for _, revision := range a.revisions {
+		if revision.Version == version {
+			return revision, nil
+		}
+	}
+	return planet.Revision{}, planet.ErrRevisionNotFound
+}
+
+func Test_handleGetRevision(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	created := time.Date(2021, 7, 21, 12, 0, 0, 0, time.UTC)
+	mars := planet.Planet{ID: objectID, Name: "Mars", Diameter: int64Ptr(6779), Version: 1}
+	frozenMars := planet.Planet{ID: objectID, Name: "Mars", Climate: []string{"frozen"}, Version: 2}
+	redMars := planet.Planet{ID: objectID, Name: "Red Mars", Climate: []string{"frozen"}, Version: 3}
+	revisions := revisionGetterMock{revisions: []planet.Revision{
+		{PlanetID: objectID, Version: 1, Timestamp: created, After: mars},
+		{PlanetID: objectID, Version: 2, Timestamp: created, Actor: "rebels", Before: &mars, After: frozenMars},
+		{PlanetID: objectID, Version: 3, Timestamp: created, Before: &frozenMars, After: redMars},
+	}}
+	tests := []struct {
+		name               string
+		givenPath          string
+		revisionGetterMock revisionGetterMock
+		wantStatusCode     int
+		wantResponseBody   string
+	}{
+		{
+			name:               "when the revision exists then it should return the planet before and after it",
+			givenPath:          "/v1/planets/5f165e2e4de9b442e60b3904/revisions/2",
+			revisionGetterMock: revisions,
+			wantStatusCode:     200,
+			wantResponseBody:   `{"planet_id":"5f165e2e4de9b442e60b3904","version":2,"timestamp":"2021-07-21T12:00:00Z","actor":"rebels","before":{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":6779,"climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]},"after":{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":["frozen"],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}}`,
+		},
+		{
+			name:               "when the revision is diffed then it should compare it with the planet before it",
+			givenPath:          "/v1/planets/5f165e2e4de9b442e60b3904/revisions/2/diff",
+			revisionGetterMock: revisions,
+			wantStatusCode:     200,
+			wantResponseBody:   `{"from":1,"to":2,"changes":[{"field":"climate","from":[],"to":["frozen"]},{"field":"diameter","from":6779,"to":"unknown"}]}`,
+		},
+		{
+			name:               "when the revision is diffed against another then it should compare the planet after both",
+			givenPath:          "/v1/planets/5f165e2e4de9b442e60b3904/revisions/3/diff?against=1",
+			revisionGetterMock: revisions,
+			wantStatusCode:     200,
+			wantResponseBody:   `{"from":1,"to":3,"changes":[{"field":"climate","from":[],"to":["frozen"]},{"field":"diameter","from":6779,"to":"unknown"},{"field":"name","from":"Mars","to":"Red Mars"}]}`,
+		},
+		{
+			name:               "when the revision to diff against is not a number then it should return 400 status",
+			givenPath:          "/v1/planets/5f165e2e4de9b442e60b3904/revisions/3/diff?against=first",
+			revisionGetterMock: revisions,
+			wantStatusCode:     400,
+			wantResponseBody:   `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"against","reason":"must be a revision number"}]}`,
+		},
+		{
+			name:               "when the revision to diff against does not exist then it should return 404 status",
+			givenPath:          "/v1/planets/5f165e2e4de9b442e60b3904/revisions/3/diff?against=7",
+			revisionGetterMock: revisions,
+			wantStatusCode:     404,
+			wantResponseBody:   `{"error_code":"WA:024","message":"revision not found"}`,
+		},
+		{
+			name:               "when the revision does not exist then it should return 404 status",
+			givenPath:          "/v1/planets/5f165e2e4de9b442e60b3904/revisions/7",
+			revisionGetterMock: revisions,
+			wantStatusCode:     404,
+			wantResponseBody:   `{"error_code":"WA:024","message":"revision not found"}`,
+		},
+		{
+			name:               "when the planet does not exist then it should return 404 status",
+			givenPath:          "/v1/planets/5f165e2e4de9b442e60b3904/revisions/1",
+			revisionGetterMock: revisionGetterMock{err: planet.ErrPlanetNotFound},
+			wantStatusCode:     404,
+			wantResponseBody:   `{"error_code":"WA:003","message":"planet not found"}`,
+		},
+		{
+			name:               "when got generic error from database then it should return 500 status",
+			givenPath:          "/v1/planets/5f165e2e4de9b442e60b3904/revisions/1",
+			revisionGetterMock: revisionGetterMock{err: errors.New("database error")},
+			wantStatusCode:     500,
+			wantResponseBody:   `{"error_code":"WA:025","message":"failed to retrieve the revisions"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{revisionGetter: tc.revisionGetterMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", tc.givenPath, nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleGetRevision() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleGetRevision() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+type planetReverterMock struct {
+	result planet.Planet
+	err    error
+}
+
+// Revert fails like the service does when the expected version isn't the
+// one the planet is at.
+func (a planetReverterMock) Revert(ctx context.Context, id string, version int64, expected int64) (planet.Planet, error) {
+	if a.err == nil && expected != 0 && expected+1 != a.result.Version {
+		return planet.Planet{}, planet.ErrVersionConflict
+	}
+	return a.result, a.err
+}
+
+func Test_handleRevertPlanet(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	tests := []struct {
+		name               string
+		givenIfMatch       string
+		planetReverterMock planetReverterMock
+		wantStatusCode     int
+		wantResponseBody   string
+		wantETag           string
+	}{
+		{
+			name:               "when the revision exists then it should return 200 status with the new version",
+			givenIfMatch:       `"3"`,
+			planetReverterMock: planetReverterMock{result: planet.Planet{ID: objectID, Name: "Mars", Version: 4}},
+			wantStatusCode:     200,
+			wantResponseBody:   `{"id":"5f165e2e4de9b442e60b3904","name":"Mars","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}`,
+			wantETag:           `"4"`,
+		},
+		{
+			name:               "when the planet moved past the expected version then it should return 412 status",
+			givenIfMatch:       `"2"`,
+			planetReverterMock: planetReverterMock{result: planet.Planet{ID: objectID, Name: "Mars", Version: 4}},
+			wantStatusCode:     412,
+			wantResponseBody:   `{"error_code":"WA:017","message":"planet was modified by another request"}`,
+		},
+		{
+			name:               "when the revision does not exist then it should return 404 status",
+			planetReverterMock: planetReverterMock{err: planet.ErrRevisionNotFound},
+			wantStatusCode:     404,
+			wantResponseBody:   `{"error_code":"WA:024","message":"revision not found"}`,
+		},
+		{
+			name:               "when the planet does not exist then it should return 404 status",
+			planetReverterMock: planetReverterMock{err: planet.ErrPlanetNotFound},
+			wantStatusCode:     404,
+			wantResponseBody:   `{"error_code":"WA:003","message":"planet not found"}`,
+		},
+		{
+			name:               "when the old name is taken by another planet then it should return 409 status",
+			planetReverterMock: planetReverterMock{err: &planet.AlreadyExistsError{Name: "Mars", ExistingID: objectID}},
+			wantStatusCode:     409,
+			wantResponseBody:   `{"error_code":"WA:012","message":"planet already exists","details":[{"id":"5f165e2e4de9b442e60b3904","name":"name","reason":"already taken by another planet"}]}`,
+		},
+		{
+			name:               "when got generic error from database then it should return 500 status",
+			planetReverterMock: planetReverterMock{err: errors.New("database error")},
+			wantStatusCode:     500,
+			wantResponseBody:   `{"error_code":"WA:026","message":"failed to revert the planet"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{planetReverter: tc.planetReverterMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("POST", "/v1/planets/5f165e2e4de9b442e60b3904/revisions/1:revert", nil)
+			if tc.givenIfMatch != "" {
+				req.Header.Set("If-Match", tc.givenIfMatch)
+			}
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleRevertPlanet() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleRevertPlanet() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+			if got := rr.Header().Get("ETag"); got != tc.wantETag {
+				t.Errorf("handleRevertPlanet() etag = %v, want %v", got, tc.wantETag)
+			}
+		})
+	}
+}
+
+type planetWatcherMock struct {
+	events chan planet.StreamedEvent
+	after  string
+	err    error
+}
+
+func (a *planetWatcherMock) Watch(ctx context.Context, after string) (<-chan planet.StreamedEvent, error) {
+	a.after = after
+	return a.events, a.err
+}
+
+func Test_handlePlanetEvents(t *testing.T) {
+	hoth, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	naboo, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3905")
+	occurredAt := time.Date(2021, 7, 21, 10, 0, 0, 0, time.UTC)
+
+	watcher := &planetWatcherMock{events: make(chan planet.StreamedEvent, 3)}
+	watcher.events <- planet.StreamedEvent{Token: "1", Reset: true}
+	watcher.events <- planet.StreamedEvent{Token: "2", Event: planet.Event{Type: planet.PlanetCreated, PlanetID: naboo, Version: 1, Planet: planet.Planet{ID: naboo, Name: "Naboo"}}}
+	watcher.events <- planet.StreamedEvent{Token: "3", Event: planet.Event{Type: planet.PlanetDeleted, PlanetID: hoth, Version: 3, OccurredAt: occurredAt, Planet: planet.Planet{ID: hoth, Name: "Hoth"}}}
+	app := App{server: &http.Server{}, streams: newStreamCloser()}
+	app.container = &container{planetWatcher: watcher}
+	app.RegisterRoutes()
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/v1/planets/events?planet_id=5f165e2e4de9b442e60b3904", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("handlePlanetEvents() unexpected error %v", err)
+	}
+	defer res.Body.Close()
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("handlePlanetEvents() content type = %v, want text/event-stream", got)
+	}
+	if watcher.after != "0" {
+		t.Errorf("handlePlanetEvents() resumed after %q, want the Last-Event-ID", watcher.after)
+	}
+
+	want := "id: 1\nevent: reset\ndata: {\"type\":\"reset\"}\n\n" +
+		"id: 3\nevent: PlanetDeleted\n" +
+		`data: {"type":"PlanetDeleted","planet_id":"5f165e2e4de9b442e60b3904","version":3,"occurred_at":"2021-07-21T10:00:00Z","planet":{"id":"5f165e2e4de9b442e60b3904","name":"Hoth","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}}` +
+		"\n\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(res.Body, got); err != nil {
+		t.Fatalf("handlePlanetEvents() unexpected error reading the stream %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("handlePlanetEvents() body = %v, want %v, the reset and only the events of the planet", string(got), want)
+	}
+
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() unexpected error %v", err)
+	}
+	if rest, err := ioutil.ReadAll(res.Body); err != nil || len(rest) > 0 {
+		t.Errorf("handlePlanetEvents() the stream should end on shutdown, got %q and %v", rest, err)
+	}
+}
+
+func Test_handlePlanetEvents_errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		givenPath         string
+		planetWatcherMock *planetWatcherMock
+		wantStatusCode    int
+		wantResponseBody  string
+	}{
+		{
+			name:              "when a planet id is invalid then it should return 400 status",
+			givenPath:         "/v1/planets/events?planet_id=5f165e2e4de9b442e60b3904,mars",
+			planetWatcherMock: &planetWatcherMock{},
+			wantStatusCode:    400,
+			wantResponseBody:  `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"planet_id","reason":"must be planet ids"}]}`,
+		},
+		{
+			name:              "when the planets cannot be watched then it should return 500 status",
+			givenPath:         "/v1/planets/events",
+			planetWatcherMock: &planetWatcherMock{err: errors.New("database error")},
+			wantStatusCode:    500,
+			wantResponseBody:  `{"error_code":"WA:032","message":"failed to watch the planets"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{planetWatcher: tc.planetWatcherMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", tc.givenPath, nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handlePlanetEvents() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handlePlanetEvents() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+func Test_handlePlanetSocket(t *testing.T) {
+	hoth, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	naboo, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3905")
+	occurredAt := time.Date(2021, 7, 21, 10, 0, 0, 0, time.UTC)
+
+	hub := planet.NewEventHub(10)
+	app := App{server: &http.Server{}, streams: newStreamCloser()}
+	app.container = &container{planetWatcher: hub}
+	app.RegisterRoutes()
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/v1/ws", nil)
+	if err != nil {
+		t.Fatalf("handlePlanetSocket() unexpected error %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	exchange := func(request, want string) {
+		t.Helper()
+		if request != "" {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+				t.Fatalf("handlePlanetSocket() unexpected error sending %s: %v", request, err)
+			}
+		}
+		_, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("handlePlanetSocket() unexpected error reading the answer to %s: %v", request, err)
+		}
+		if string(got) != want {
+			t.Errorf("handlePlanetSocket() message = %v, want %v", string(got), want)
+		}
+	}
+
+	exchange(`{"id":"1","type":"subscribe","planet_ids":["5f165e2e4de9b442e60b3904"],"event_types":["PlanetUpdated","PlanetDeleted"]}`,
+		`{"type":"subscription","id":"1","subscription":{"all_planets":false,"planet_ids":["5f165e2e4de9b442e60b3904"],"event_types":["PlanetDeleted","PlanetUpdated"]}}`)
+
+	hub.Publish(context.Background(), planet.Event{ID: primitive.NewObjectID(), Type: planet.PlanetUpdated, PlanetID: naboo, Version: 2, Planet: planet.Planet{ID: naboo, Name: "Naboo"}})
+	hub.Publish(context.Background(), planet.Event{ID: primitive.NewObjectID(), Type: planet.PlanetCreated, PlanetID: hoth, Version: 1, Planet: planet.Planet{ID: hoth, Name: "Hoth"}})
+	deleted := primitive.NewObjectID()
+	hub.Publish(context.Background(), planet.Event{ID: deleted, Type: planet.PlanetDeleted, PlanetID: hoth, Version: 3, OccurredAt: occurredAt, Planet: planet.Planet{ID: hoth, Name: "Hoth"}})
+	exchange("", `{"type":"event","event_id":"`+deleted.Hex()+`","event":{"type":"PlanetDeleted","planet_id":"5f165e2e4de9b442e60b3904","version":3,"occurred_at":"2021-07-21T10:00:00Z","planet":{"id":"5f165e2e4de9b442e60b3904","name":"Hoth","rotation_period":"unknown","orbital_period":"unknown","diameter":"unknown","climate":[],"gravity":"unknown","terrain":[],"surface_water":"unknown","population":"unknown","film_count":0,"films":[]}}}`)
+
+	exchange(`{"id":"2","type":"ping"}`, `{"type":"pong","id":"2"}`)
+	exchange(`{"id":"3","type":"subscribe","planet_ids":["mars"]}`,
+		`{"type":"error","id":"3","error_code":"WA:001","message":"payload is invalid","details":[{"name":"PlanetIDs[0]","reason":"Key: 'socketRequest.PlanetIDs[0]' Error:Field validation for 'PlanetIDs[0]' failed on the 'object_id' tag"}]}`)
+	exchange(`{"type":`, `{"type":"error","error_code":"WA:007","message":"failed to decode payload"}`)
+	exchange(`{"id":"4","type":"unsubscribe"}`,
+		`{"type":"subscription","id":"4","subscription":{"all_planets":false,"planet_ids":[],"event_types":[]}}`)
+
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() unexpected error %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("handlePlanetSocket() the socket should be closed as going away on shutdown, got %v", err)
+	}
+}
+
+func Test_handlePlanetSocket_errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		givenHeaders      map[string]string
+		planetWatcherMock *planetWatcherMock
+		wantStatusCode    int
+		wantResponseBody  string
+	}{
+		{
+			name:              "when the request is no websocket upgrade then it should return 426 status",
+			planetWatcherMock: &planetWatcherMock{},
+			wantStatusCode:    426,
+			wantResponseBody:  `{"error_code":"WA:033","message":"websocket upgrade required"}`,
+		},
+		{
+			name: "when the planets cannot be watched then it should return 500 status",
+			givenHeaders: map[string]string{
+				"Connection":            "Upgrade",
+				"Upgrade":               "websocket",
+				"Sec-WebSocket-Version": "13",
+				"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+			},
+			planetWatcherMock: &planetWatcherMock{err: errors.New("database error")},
+			wantStatusCode:    500,
+			wantResponseBody:  `{"error_code":"WA:032","message":"failed to watch the planets"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{planetWatcher: tc.planetWatcherMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", "/v1/ws", nil)
+			for key, value := range tc.givenHeaders {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handlePlanetSocket() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handlePlanetSocket() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"star-wars/pkg/planet"
+)
+
+const (
+	csvMediaType    = "text/csv"
+	ndjsonMediaType = "application/x-ndjson"
+
+	// maxImportLine bounds the memory a single NDJSON line can take.
+	maxImportLine = 1 << 20
+)
+
+// readOnlyColumns are read-only attributes found in exports. They are
+// ignored so an export can be imported back as it is.
+var readOnlyColumns = map[string]bool{
+	"id":         true,
+	"film_count": true,
+	"films":      true,
+}
+
+// importRecord is a planet read from one line of an upload, or the reason
+// the line could not be read.
+type importRecord struct {
+	line    int
+	request planetRequest
+	err     *errorMessage
+}
+
+// importReader reads an upload one record at a time, so the body is never
+// held in memory as a whole. next returns io.EOF after the last record.
+type importReader interface {
+	next() (importRecord, error)
+}
+
+type ndjsonImportReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONImportReader(body io.Reader) *ndjsonImportReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+	return &ndjsonImportReader{scanner: scanner}
+}
+
+func (r *ndjsonImportReader) next() (importRecord, error) {
+	for r.scanner.Scan() {
+		r.line++
+		raw := bytes.TrimSpace(r.scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		record := importRecord{line: r.line}
+		if err := json.Unmarshal(raw, &record.request); err != nil {
+			record.err = &errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"}
+		}
+		return record, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return importRecord{line: r.line + 1}, err
+	}
+	return importRecord{}, io.EOF
+}
+
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns []string
+	line    int
+}
+
+// newCSVImportReader reads the header of a CSV upload. Columns are matched to
+// planet attributes by name, ignoring case and treating spaces and dashes as
+// underscores, so "Rotation Period" is rotation_period. Other headers can be
+// mapped with column[<header>]=<attribute>, or ignored with
+// column[<header>]=-. Unknown columns are reported as details.
+func newCSVImportReader(body io.Reader, values url.Values) (*csvImportReader, []map[string]string, error) {
+	mapping := map[string]string{}
+	for key, value := range values {
+		if strings.HasPrefix(key, "column[") && strings.HasSuffix(key, "]") {
+			mapping[columnName(key[len("column["):len(key)-1])] = value[0]
+		}
+	}
+
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var details []map[string]string
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		column := columnName(name)
+		if mapped, ok := mapping[column]; ok {
+			column = mapped
+		}
+		if column == "-" || readOnlyColumns[column] {
+			continue
+		}
+		if !isImportable(column) {
+			details = append(details, map[string]string{"name": name, "reason": "unknown column, map it with column[" + name + "]"})
+			continue
+		}
+		if seen[column] {
+			details = append(details, map[string]string{"name": name, "reason": fmt.Sprintf("'%s' is mapped more than once", column)})
+			continue
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	if !seen["name"] && len(details) == 0 {
+		details = append(details, map[string]string{"name": "name", "reason": "a name column is required"})
+	}
+	sort.Slice(details, func(i, j int) bool { return details[i]["name"] < details[j]["name"] })
+
+	reader.FieldsPerRecord = len(header)
+	return &csvImportReader{reader: reader, columns: columns, line: 1}, details, nil
+}
+
+func (r *csvImportReader) next() (importRecord, error) {
+	row, err := r.reader.Read()
+	r.line++
+	if err == io.EOF {
+		return importRecord{}, io.EOF
+	}
+
+	record := importRecord{line: r.line}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		record.err = &errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007", Details: []map[string]string{
+			{"name": "row", "reason": parseErr.Err.Error()},
+		}}
+		return record, nil
+	}
+	if err != nil {
+		return record, err
+	}
+
+	document := map[string]interface{}{}
+	for i, column := range r.columns {
+		if column != "" {
+			document[column] = csvValue(planetQueryFields[column].kind, row[i])
+		}
+	}
+	if err := fromJSONDocument(document, &record.request); err != nil {
+		record.err = &errorMessage{Message: "failed to decode payload", ErrorCode: "WA:007"}
+	}
+	return record, nil
+}
+
+// csvValue converts a cell to the JSON value planetRequest expects. Lists are
+// comma separated like in SWAPI and empty numbers are unknown.
+func csvValue(kind queryFieldKind, cell string) interface{} {
+	cell = strings.TrimSpace(cell)
+
+	switch kind {
+	case listField:
+		list := []string{}
+		for _, item := range strings.Split(cell, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	case integerField, numberField:
+		if cell == "" {
+			return unknownValue
+		}
+		if _, err := strconv.ParseFloat(cell, 64); err == nil {
+			return json.Number(cell)
+		}
+	}
+
+	return cell
+}
+
+func columnName(header string) string {
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(header)))
+}
+
+func isImportable(column string) bool {
+	for _, field := range planet.PatchableFields {
+		if field == column {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"star-wars/pkg/planet"
+)
+
+type queryFieldKind int
+
+const (
+	textField queryFieldKind = iota
+	listField
+	integerField
+	numberField
+)
+
+type queryField struct {
+	kind     queryFieldKind
+	sortable bool
+}
+
+// planetQueryFields is the whitelist of attributes a listing can be filtered
+// and sorted by. Lists can be filtered but not sorted.
+var planetQueryFields = map[string]queryField{
+	"name":            {kind: textField, sortable: true},
+	"climate":         {kind: listField},
+	"terrain":         {kind: listField},
+	"rotation_period": {kind: integerField, sortable: true},
+	"orbital_period":  {kind: integerField, sortable: true},
+	"diameter":        {kind: integerField, sortable: true},
+	"gravity":         {kind: numberField, sortable: true},
+	"surface_water":   {kind: numberField, sortable: true},
+	"population":      {kind: integerField, sortable: true},
+	"film_count":      {kind: integerField, sortable: true},
+}
+
+var queryOperators = map[queryFieldKind][]planet.Operator{
+	textField:    {planet.OpEq, planet.OpNe, planet.OpIn},
+	listField:    {planet.OpEq, planet.OpNe, planet.OpIn},
+	integerField: {planet.OpEq, planet.OpNe, planet.OpGt, planet.OpGte, planet.OpLt, planet.OpLte, planet.OpIn},
+	numberField:  {planet.OpEq, planet.OpNe, planet.OpGt, planet.OpGte, planet.OpLt, planet.OpLte, planet.OpIn},
+}
+
+// queryParameters are not filters, they drive pagination, ordering and the
+// time the planets are read at.
+var queryParameters = map[string]bool{
+	"limit":  true,
+	"cursor": true,
+	"sort":   true,
+	"as_of":  true,
+}
+
+// parseListOptions reads the pagination, filter and sort parameters of a
+// listing request.
+func parseListOptions(values url.Values) (planet.ListOptions, []map[string]string) {
+	opts := planet.ListOptions{Cursor: values.Get("cursor")}
+	var details []map[string]string
+
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || parsed < 1 || parsed > planet.MaxListLimit {
+			details = append(details, map[string]string{"name": "limit", "reason": "must be an integer between 1 and " + strconv.Itoa(planet.MaxListLimit)})
+		}
+		opts.Limit = parsed
+	}
+
+	asOf, err := parseAsOf(values)
+	if err != nil {
+		details = append(details, map[string]string{"name": "as_of", "reason": err.Error()})
+	}
+	opts.AsOf = asOf
+
+	query, queryDetails := parsePlanetQuery(values)
+	opts.Query = query
+
+	return opts, append(details, queryDetails...)
+}
+
+// parseAsOf reads the time a point-in-time read asks for. It is zero when
+// the planets are read as they are now.
+func parseAsOf(values url.Values) (time.Time, error) {
+	raw := values.Get("as_of")
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, errors.New("must be an RFC3339 date-time")
+	}
+	return asOf.UTC(), nil
+}
+
+// parsePlanetQuery compiles query parameters such as climate=arid,
+// population[gt]=1000000 and sort=-diameter,name into a planet.Query. Every
+// problem found is reported as a detail naming the offending parameter.
+func parsePlanetQuery(values url.Values) (planet.Query, []map[string]string) {
+	var query planet.Query
+	var details []map[string]string
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if queryParameters[key] {
+			continue
+		}
+		for _, raw := range values[key] {
+			filter, err := parseFilter(key, raw)
+			if err != nil {
+				details = append(details, map[string]string{"name": key, "reason": err.Error()})
+				continue
+			}
+			query.Filters = append(query.Filters, filter)
+		}
+	}
+
+	if raw := values.Get("sort"); raw != "" {
+		sortFields, err := parseSort(raw)
+		if err != nil {
+			details = append(details, map[string]string{"name": "sort", "reason": err.Error()})
+		}
+		query.Sort = sortFields
+	}
+
+	return query, details
+}
+
+func parseFilter(key, raw string) (planet.Filter, error) {
+	name, operator := key, planet.OpEq
+	if open := strings.Index(key, "["); open >= 0 {
+		if !strings.HasSuffix(key, "]") {
+			return planet.Filter{}, fmt.Errorf("malformed filter, expected field[operator]")
+		}
+		name, operator = key[:open], planet.Operator(key[open+1:len(key)-1])
+	}
+
+	field, ok := planetQueryFields[name]
+	if !ok {
+		return planet.Filter{}, fmt.Errorf("unknown field '%s'", name)
+	}
+	if !supportsOperator(field.kind, operator) {
+		return planet.Filter{}, fmt.Errorf("unsupported operator '%s' for field '%s'", operator, name)
+	}
+
+	if operator != planet.OpIn {
+		value, err := parseFilterValue(field.kind, operator, raw)
+		return planet.Filter{Field: name, Operator: operator, Value: value}, err
+	}
+
+	parts := strings.Split(raw, ",")
+	list := make([]interface{}, 0, len(parts))
+	for _, part := range parts {
+		value, err := parseFilterValue(field.kind, operator, part)
+		if err != nil {
+			return planet.Filter{}, err
+		}
+		list = append(list, value)
+	}
+	return planet.Filter{Field: name, Operator: operator, Value: list}, nil
+}
+
+// parseFilterValue converts a raw value to the type the field is stored with.
+// Equality against "unknown" matches the planets missing that attribute.
+func parseFilterValue(kind queryFieldKind, operator planet.Operator, raw string) (interface{}, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("value is required")
+	}
+
+	switch kind {
+	case integerField, numberField:
+		if raw == unknownValue {
+			if operator != planet.OpEq && operator != planet.OpNe && operator != planet.OpIn {
+				return nil, fmt.Errorf("'%s' can only be compared with eq, ne or in", unknownValue)
+			}
+			return nil, nil
+		}
+		if kind == integerField {
+			value, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("expected an integer, got '%s'", raw)
+			}
+			return value, nil
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got '%s'", raw)
+		}
+		return value, nil
+	}
+
+	return raw, nil
+}
+
+func parseSort(raw string) ([]planet.SortField, error) {
+	var sortFields []planet.SortField
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(raw, ",") {
+		sortField := planet.SortField{Field: part}
+		if strings.HasPrefix(part, "-") {
+			sortField = planet.SortField{Field: part[1:], Descending: true}
+		}
+
+		field, ok := planetQueryFields[sortField.Field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field '%s'", sortField.Field)
+		}
+		if !field.sortable {
+			return nil, fmt.Errorf("field '%s' is not sortable", sortField.Field)
+		}
+		if seen[sortField.Field] {
+			return nil, fmt.Errorf("field '%s' is sorted more than once", sortField.Field)
+		}
+		seen[sortField.Field] = true
+
+		sortFields = append(sortFields, sortField)
+	}
+
+	return sortFields, nil
+}
+
+func supportsOperator(kind queryFieldKind, operator planet.Operator) bool {
+	for _, supported := range queryOperators[kind] {
+		if supported == operator {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"star-wars/pkg/planet"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parsePlanetQuery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		givenQuery  string
+		wantQuery   planet.Query
+		wantDetails []map[string]string
+	}{
+		{
+			name:       "when filters and sort are valid, then it should compile them to a typed query",
+			givenQuery: "climate=arid&population[gt]=1000000&sort=-diameter,name&limit=10",
+			wantQuery: planet.Query{
+				Filters: []planet.Filter{
+					{Field: "climate", Operator: planet.OpEq, Value: "arid"},
+					{Field: "population", Operator: planet.OpGt, Value: int64(1000000)},
+				},
+				Sort: []planet.SortField{
+					{Field: "diameter", Descending: true},
+					{Field: "name"},
+				},
+			},
+		},
+		{
+			name:       "when filtering with in and unknown, then it should convert every value",
+			givenQuery: "gravity[in]=1,unknown&terrain[ne]=desert",
+			wantQuery: planet.Query{
+				Filters: []planet.Filter{
+					{Field: "gravity", Operator: planet.OpIn, Value: []interface{}{float64(1), nil}},
+					{Field: "terrain", Operator: planet.OpNe, Value: "desert"},
+				},
+			},
+		},
+		{
+			name:        "when the field is not whitelisted, then it should report it",
+			givenQuery:  "_id=5f165e2e4de9b442e60b3904",
+			wantDetails: []map[string]string{{"name": "_id", "reason": "unknown field '_id'"}},
+		},
+		{
+			name:        "when the operator is not supported by the field, then it should report it",
+			givenQuery:  "climate[gt]=arid",
+			wantDetails: []map[string]string{{"name": "climate[gt]", "reason": "unsupported operator 'gt' for field 'climate'"}},
+		},
+		{
+			name:        "when the value does not match the field type, then it should report it",
+			givenQuery:  "population[gte]=many",
+			wantDetails: []map[string]string{{"name": "population[gte]", "reason": "expected an integer, got 'many'"}},
+		},
+		{
+			name:        "when unknown is compared by order, then it should report it",
+			givenQuery:  "diameter[lt]=unknown",
+			wantDetails: []map[string]string{{"name": "diameter[lt]", "reason": "'unknown' can only be compared with eq, ne or in"}},
+		},
+		{
+			name:        "when sorting by a list, then it should report it",
+			givenQuery:  "sort=climate",
+			wantDetails: []map[string]string{{"name": "sort", "reason": "field 'climate' is not sortable"}},
+		},
+		{
+			name:        "when the limit is out of range, then it should report it",
+			givenQuery:  "limit=1000",
+			wantDetails: []map[string]string{{"name": "limit", "reason": "must be an integer between 1 and 100"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tc.givenQuery)
+			opts, details := parseListOptions(values)
+			assert.Equal(t, tc.wantDetails, details, "parseListOptions() unexpected details")
+			if len(tc.wantDetails) == 0 {
+				assert.Equal(t, tc.wantQuery, opts.Query, "parseListOptions() unexpected query")
+			}
+		})
+	}
+}
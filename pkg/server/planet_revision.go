@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// revisionSummaryDTO lists a revision without the planet it holds, naming
+// the attributes the change touched instead.
+type revisionSummaryDTO struct {
+	Version       int64     `json:"version"`
+	Timestamp     time.Time `json:"timestamp"`
+	RequestID     string    `json:"request_id,omitempty"`
+	Actor         string    `json:"actor,omitempty"`
+	ChangedFields []string  `json:"changed_fields"`
+}
+
+type revisionDTO struct {
+	PlanetID  primitive.ObjectID `json:"planet_id"`
+	Version   int64              `json:"version"`
+	Timestamp time.Time          `json:"timestamp"`
+	RequestID string             `json:"request_id,omitempty"`
+	Actor     string             `json:"actor,omitempty"`
+	Before    *PlanetDTO         `json:"before"`
+	After     PlanetDTO          `json:"after"`
+}
+
+// revisionDiffDTO compares the planet after two revisions. From is zero when
+// the comparison starts before the planet was created.
+type revisionDiffDTO struct {
+	From    int64         `json:"from"`
+	To      int64         `json:"to"`
+	Changes []fieldChange `json:"changes"`
+}
+
+type fieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+func newRevisionDTO(r planet.Revision) revisionDTO {
+	dto := revisionDTO{
+		PlanetID:  r.PlanetID,
+		Version:   r.Version,
+		Timestamp: r.Timestamp,
+		RequestID: r.RequestID,
+		Actor:     r.Actor,
+		After:     newPlanetDTO(r.After),
+	}
+	if r.Before != nil {
+		before := newPlanetDTO(*r.Before)
+		dto.Before = &before
+	}
+	return dto
+}
+
+func newRevisionSummaryDTO(r planet.Revision) (revisionSummaryDTO, error) {
+	changes, err := diffPlanets(r.Before, r.After)
+	if err != nil {
+		return revisionSummaryDTO{}, err
+	}
+
+	fields := make([]string, len(changes))
+	for i, change := range changes {
+		fields[i] = change.Field
+	}
+	return revisionSummaryDTO{
+		Version:       r.Version,
+		Timestamp:     r.Timestamp,
+		RequestID:     r.RequestID,
+		Actor:         r.Actor,
+		ChangedFields: fields,
+	}, nil
+}
+
+// diffPlanets lists the attributes that differ between two planets, as the
+// API renders them and sorted by name. A nil from is a planet that did not
+// exist yet, so every attribute of to is reported.
+func diffPlanets(from *planet.Planet, to planet.Planet) ([]fieldChange, error) {
+	before := map[string]interface{}{}
+	if from != nil {
+		document, err := toJSONDocument(newPlanetDTO(*from))
+		if err != nil {
+			return nil, err
+		}
+		before = document
+	}
+	after, err := toJSONDocument(newPlanetDTO(to))
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []fieldChange{}
+	for _, field := range changedMembers(before, after) {
+		changes = append(changes, fieldChange{Field: field, From: before[field], To: after[field]})
+	}
+	return changes, nil
+}
+
+// revisionVersion reads the revision a request names. Versions too large to
+// be stored report false.
+func revisionVersion(r *http.Request) (int64, bool) {
+	version, err := strconv.ParseInt(mux.Vars(r)["rev"], 10, 64)
+	return version, err == nil
+}
+
+func writeRevisionNotFound(w http.ResponseWriter) {
+	writeJsonResponse(w, http.StatusNotFound, errorMessage{ErrorCode: "WA:024", Message: "revision not found"})
+}
+
+// writeRevisionError answers a failed revision lookup.
+func writeRevisionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, planet.ErrPlanetNotFound):
+		writeJsonResponse(w, http.StatusNotFound, errorMessage{ErrorCode: "WA:003", Message: "planet not found"})
+	case errors.Is(err, planet.ErrRevisionNotFound):
+		writeRevisionNotFound(w)
+	default:
+		writePlanetFailure(w, err, errorMessage{ErrorCode: "WA:025", Message: "failed to retrieve the revisions"})
+	}
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A planet socket speaks JSON text messages. The client sends:
+//
+//	{"id": "1", "type": "subscribe", "planet_ids": ["..."], "event_types": ["PlanetUpdated"]}
+//	{"id": "2", "type": "unsubscribe", "planet_ids": ["..."]}
+//	{"id": "3", "type": "ping"}
+//
+// Subscribing without planet ids follows every planet, and the event types,
+// when given, replace the ones followed. Unsubscribing without planet ids
+// stops everything. Each request is answered with its id, by the resulting
+// "subscription", a "pong" or an "error", and the matching changes are sent
+// as "event" messages.
+const (
+	socketSubscribe   = "subscribe"
+	socketUnsubscribe = "unsubscribe"
+	socketPing        = "ping"
+
+	socketSubscription = "subscription"
+	socketPong         = "pong"
+	socketEvent        = "event"
+	socketError        = "error"
+)
+
+const (
+	// slowConsumerDrop skips the events a client is too slow to take.
+	slowConsumerDrop = "drop"
+	// slowConsumerDisconnect closes the socket of a client too slow to take
+	// its events, so it knows it missed some.
+	slowConsumerDisconnect = "disconnect"
+)
+
+const (
+	socketWriteWait           = 10 * time.Second
+	socketMaxMessageSize      = 64 << 10
+	defaultSocketPingInterval = 30 * time.Second
+	defaultSocketSendBuffer   = 64
+)
+
+const (
+	socketSentLabelValue     = "sent"
+	socketReceivedLabelValue = "received"
+	socketDroppedLabelValue  = "dropped"
+	directionLabelKey        = "direction"
+)
+
+var (
+	promSocketConnectionsGauge prometheus.Gauge
+	promSocketMessagesCounter  *prometheus.CounterVec
+	onceSocketMetrics          sync.Once
+)
+
+// socketPolicy tunes the planet sockets.
+type socketPolicy struct {
+	// pingInterval is how often the client is pinged. It is disconnected when
+	// nothing comes back for two intervals.
+	pingInterval time.Duration
+	// sendBuffer is how many messages can wait for a slow client.
+	sendBuffer int
+	// slowConsumer is what happens to the events a full buffer has no room
+	// for, slowConsumerDrop or slowConsumerDisconnect.
+	slowConsumer string
+}
+
+func socketPolicyFromConfig() socketPolicy {
+	return socketPolicy{
+		pingInterval: viper.GetDuration("WS_PING_INTERVAL"),
+		sendBuffer:   viper.GetInt("WS_SEND_BUFFER"),
+		slowConsumer: viper.GetString("WS_SLOW_CONSUMER"),
+	}
+}
+
+func (p socketPolicy) withDefaults() socketPolicy {
+	if p.pingInterval <= 0 {
+		p.pingInterval = defaultSocketPingInterval
+	}
+	if p.sendBuffer <= 0 {
+		p.sendBuffer = defaultSocketSendBuffer
+	}
+	if p.slowConsumer != slowConsumerDrop {
+		p.slowConsumer = slowConsumerDisconnect
+	}
+	return p
+}
+
+// socketRequest is a message sent by the client of a planet socket.
+type socketRequest struct {
+	ID         string   `json:"id" validate:"max=64"`
+	Type       string   `json:"type" validate:"required,oneof=subscribe unsubscribe ping"`
+	PlanetIDs  []string `json:"planet_ids" validate:"max=100,dive,object_id"`
+	EventTypes []string `json:"event_types" validate:"omitempty,max=3,dive,oneof=PlanetCreated PlanetUpdated PlanetDeleted"`
+}
+
+// socketMessage is a message sent to the client of a planet socket. Errors
+// carry the error code and message of the HTTP API.
+type socketMessage struct {
+	Type         string                 `json:"type"`
+	ID           string                 `json:"id,omitempty"`
+	Subscription *socketSubscriptionDTO `json:"subscription,omitempty"`
+	EventID      string                 `json:"event_id,omitempty"`
+	Event        *planetEventDTO        `json:"event,omitempty"`
+	*errorMessage
+}
+
+type socketSubscriptionDTO struct {
+	AllPlanets bool     `json:"all_planets"`
+	PlanetIDs  []string `json:"planet_ids"`
+	EventTypes []string `json:"event_types"`
+}
+
+// socketFilter is what a planet socket is subscribed to. Nothing is sent
+// until the client subscribes.
+type socketFilter struct {
+	allPlanets bool
+	planetIDs  map[primitive.ObjectID]bool
+	eventTypes map[planet.EventType]bool
+}
+
+func (f *socketFilter) subscribe(request socketRequest) {
+	if len(request.PlanetIDs) == 0 {
+		f.allPlanets = true
+	}
+	for _, raw := range request.PlanetIDs {
+		id, _ := primitive.ObjectIDFromHex(raw)
+		f.planetIDs[id] = true
+	}
+	if len(request.EventTypes) > 0 {
+		f.eventTypes = map[planet.EventType]bool{}
+		for _, eventType := range request.EventTypes {
+			f.eventTypes[planet.EventType(eventType)] = true
+		}
+	}
+}
+
+func (f *socketFilter) unsubscribe(request socketRequest) {
+	if len(request.PlanetIDs) == 0 {
+		f.allPlanets = false
+		f.planetIDs = map[primitive.ObjectID]bool{}
+		f.eventTypes = nil
+	}
+	for _, raw := range request.PlanetIDs {
+		id, _ := primitive.ObjectIDFromHex(raw)
+		delete(f.planetIDs, id)
+	}
+}
+
+func (f *socketFilter) matches(event planet.Event) bool {
+	if len(f.eventTypes) > 0 && !f.eventTypes[event.Type] {
+		return false
+	}
+	return f.allPlanets || f.planetIDs[event.PlanetID]
+}
+
+func (f *socketFilter) dto() *socketSubscriptionDTO {
+	dto := &socketSubscriptionDTO{AllPlanets: f.allPlanets, PlanetIDs: []string{}, EventTypes: []string{}}
+	for id := range f.planetIDs {
+		dto.PlanetIDs = append(dto.PlanetIDs, id.Hex())
+	}
+	for eventType := range f.eventTypes {
+		dto.EventTypes = append(dto.EventTypes, string(eventType))
+	}
+	sort.Strings(dto.PlanetIDs)
+	sort.Strings(dto.EventTypes)
+	return dto
+}
+
+// planetSocket is the connection of a client subscribed to planet changes.
+// Only write sends data messages, so there is a single writer as the
+// connection requires, while control messages can be sent from anywhere.
+type planetSocket struct {
+	// sent, received and dropped count the messages, first for the atomic
+	// operations to be aligned.
+	sent, received, dropped int64
+
+	conn   *websocket.Conn
+	policy socketPolicy
+	send   chan []byte
+	done   chan struct{}
+	once   sync.Once
+	opened time.Time
+
+	mu     sync.Mutex
+	filter socketFilter
+
+	closeCode int
+}
+
+func newPlanetSocket(conn *websocket.Conn, policy socketPolicy) *planetSocket {
+	return &planetSocket{
+		conn:   conn,
+		policy: policy,
+		send:   make(chan []byte, policy.sendBuffer),
+		done:   make(chan struct{}),
+		opened: time.Now(),
+		filter: socketFilter{planetIDs: map[primitive.ObjectID]bool{}},
+	}
+}
+
+// close sends the client a close message with the code and reason, then
+// closes the connection. Only the first call has any effect.
+func (s *planetSocket) close(code int, reason string) {
+	s.once.Do(func() {
+		s.closeCode = code
+		close(s.done)
+		s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(socketWriteWait))
+		s.conn.Close()
+	})
+}
+
+// read handles the client requests until the connection fails or closes.
+// Any message, pongs included, shows the client is still there.
+func (s *planetSocket) read() error {
+	pongWait := 2 * s.policy.pingInterval
+	s.conn.SetReadLimit(socketMaxMessageSize)
+	s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		s.conn.SetReadDeadline(time.Now().Add(pongWait))
+		atomic.AddInt64(&s.received, 1)
+		socketMessagesIncrement(socketReceivedLabelValue)
+		s.handle(data)
+	}
+}
+
+func (s *planetSocket) handle(data []byte) {
+	var request socketRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		s.reply(socketMessage{Type: socketError, errorMessage: &errorMessage{ErrorCode: "WA:007", Message: "failed to decode payload"}})
+		return
+	}
+	if _, message, err := validationFailure(&request); err != nil {
+		s.reply(socketMessage{Type: socketError, ID: request.ID, errorMessage: &message})
+		return
+	}
+
+	switch request.Type {
+	case socketPing:
+		s.reply(socketMessage{Type: socketPong, ID: request.ID})
+	case socketSubscribe, socketUnsubscribe:
+		s.mu.Lock()
+		if request.Type == socketSubscribe {
+			s.filter.subscribe(request)
+		} else {
+			s.filter.unsubscribe(request)
+		}
+		subscription := s.filter.dto()
+		s.mu.Unlock()
+		s.reply(socketMessage{Type: socketSubscription, ID: request.ID, Subscription: subscription})
+	}
+}
+
+// reply queues the answer to a client request. Answers are never dropped,
+// reading waits for room instead.
+func (s *planetSocket) reply(message socketMessage) {
+	data, _ := json.Marshal(message)
+	select {
+	case s.send <- data:
+	case <-s.done:
+	}
+}
+
+// forward queues the planet events the client is subscribed to, until the
+// socket is closed. The socket is closed when the events stop coming.
+func (s *planetSocket) forward(events <-chan planet.StreamedEvent) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case streamed, ok := <-events:
+			if !ok {
+				s.close(websocket.CloseTryAgainLater, "planet events interrupted")
+				return
+			}
+			s.mu.Lock()
+			matches := s.filter.matches(streamed.Event)
+			s.mu.Unlock()
+			if !matches {
+				continue
+			}
+
+			event := newPlanetEventDTO(streamed.Event)
+			data, err := json.Marshal(socketMessage{Type: socketEvent, EventID: streamed.Token, Event: &event})
+			if err != nil {
+				s.close(websocket.CloseInternalServerErr, "failed to encode a planet event")
+				return
+			}
+			s.push(data)
+		}
+	}
+}
+
+// push queues an event without waiting. When the client is too slow to make
+// room for it, the event is dropped or the client disconnected, as the
+// policy says.
+func (s *planetSocket) push(data []byte) {
+	select {
+	case s.send <- data:
+	default:
+		if s.policy.slowConsumer == slowConsumerDrop {
+			atomic.AddInt64(&s.dropped, 1)
+			socketMessagesIncrement(socketDroppedLabelValue)
+			return
+		}
+		s.close(websocket.CloseTryAgainLater, "too slow to keep up")
+	}
+}
+
+// write sends the queued messages and pings the client, until the socket is
+// closed.
+func (s *planetSocket) write() {
+	ping := time.NewTicker(s.policy.pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				s.close(websocket.CloseGoingAway, "")
+				return
+			}
+			atomic.AddInt64(&s.sent, 1)
+			socketMessagesIncrement(socketSentLabelValue)
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+				s.close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+func socketMessagesIncrement(direction string) {
+	_, messages := getSocketMetricsInstance()
+	messages.With(prometheus.Labels{directionLabelKey: direction}).Inc()
+}
+
+func getSocketMetricsInstance() (prometheus.Gauge, *prometheus.CounterVec) {
+	onceSocketMetrics.Do(func() {
+		constLabels := prometheus.Labels{
+			environmentLabelKey: viper.GetString("ENVIRONMENT"),
+			appNameLabelKey:     viper.GetString("APP_NAME"),
+		}
+		promSocketConnectionsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+			Name:        "websocket_connections",
+			Help:        "Number of open planet WebSockets.",
+			ConstLabels: constLabels,
+		})
+		promSocketMessagesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:        "websocket_messages_total",
+			Help:        "Total number of planet WebSocket messages sent, received and dropped.",
+			ConstLabels: constLabels,
+		}, []string{directionLabelKey})
+	})
+
+	return promSocketConnectionsGauge, promSocketMessagesCounter
+}
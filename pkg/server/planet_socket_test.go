@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// socketPair returns both ends of a WebSocket, the server one first.
+func socketPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var upgrader websocket.Upgrader
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() unexpected error %v", err)
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() unexpected error %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return <-accepted, client
+}
+
+func Test_planetSocket_push(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		givenPolicy     string
+		wantDropped     int64
+		wantClosed      bool
+		wantClientError int
+	}{
+		{
+			name:        "when the policy is to drop, then it should skip the events the client has no room for",
+			givenPolicy: slowConsumerDrop,
+			wantDropped: 1,
+		},
+		{
+			name:            "when the policy is to disconnect, then it should close the socket of the client with no room left",
+			givenPolicy:     slowConsumerDisconnect,
+			wantClosed:      true,
+			wantClientError: websocket.CloseTryAgainLater,
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			serverConn, client := socketPair(t)
+			socket := newPlanetSocket(serverConn, socketPolicy{sendBuffer: 1, slowConsumer: tc.givenPolicy}.withDefaults())
+			defer socket.close(websocket.CloseNormalClosure, "")
+
+			socket.push([]byte(`{"type":"event"}`))
+			socket.push([]byte(`{"type":"event"}`))
+
+			if socket.dropped != tc.wantDropped {
+				t.Errorf("push() dropped = %v, want %v", socket.dropped, tc.wantDropped)
+			}
+			select {
+			case <-socket.done:
+				if !tc.wantClosed {
+					t.Errorf("push() should not close the socket")
+				}
+			default:
+				if tc.wantClosed {
+					t.Errorf("push() should close the socket")
+				}
+			}
+			if tc.wantClosed {
+				client.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, _, err := client.ReadMessage(); !websocket.IsCloseError(err, tc.wantClientError) {
+					t.Errorf("push() the client should be told to try again later, got %v", err)
+				}
+			}
+		})
+	}
+}
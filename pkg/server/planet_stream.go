@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// streamHeartbeat is how often an idle event stream sends a comment, so
+// proxies and clients don't take it for a dead connection.
+const streamHeartbeat = 15 * time.Second
+
+// planetEventDTO is the data of a planet event sent on the event stream.
+type planetEventDTO struct {
+	Type       string             `json:"type"`
+	PlanetID   primitive.ObjectID `json:"planet_id"`
+	Version    int64              `json:"version"`
+	OccurredAt time.Time          `json:"occurred_at"`
+	Planet     PlanetDTO          `json:"planet"`
+}
+
+func newPlanetEventDTO(event planet.Event) planetEventDTO {
+	return planetEventDTO{
+		Type:       string(event.Type),
+		PlanetID:   event.PlanetID,
+		Version:    event.Version,
+		OccurredAt: event.OccurredAt,
+		Planet:     newPlanetDTO(event.Planet),
+	}
+}
+
+// streamReset names the event telling a client that the stream could not
+// resume where it asked, and that it should reload the planets.
+const streamReset = "reset"
+
+// writeServerSentEvent writes a planet event in the text/event-stream format,
+// named after its type and identified by its resume token.
+func writeServerSentEvent(w io.Writer, streamed planet.StreamedEvent) error {
+	if streamed.Reset {
+		_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: {\"type\":%q}\n\n", streamed.Token, streamReset, streamReset)
+		return err
+	}
+
+	data, err := json.Marshal(newPlanetEventDTO(streamed.Event))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", streamed.Token, streamed.Event.Type, data)
+	return err
+}
+
+// parsePlanetIDs reads the planet_id parameters, repeated or comma separated,
+// a stream is narrowed down to. No planet id means every planet.
+func parsePlanetIDs(values url.Values) (map[primitive.ObjectID]bool, []map[string]string) {
+	var details []map[string]string
+	ids := map[primitive.ObjectID]bool{}
+	for _, value := range values["planet_id"] {
+		for _, raw := range strings.Split(value, ",") {
+			id, err := primitive.ObjectIDFromHex(strings.TrimSpace(raw))
+			if err != nil {
+				details = append(details, map[string]string{"name": "planet_id", "reason": "must be planet ids"})
+				return nil, details
+			}
+			ids[id] = true
+		}
+	}
+	return ids, details
+}
+
+// streamCloser ends the event streams on shutdown. They never end on their
+// own, so the server would otherwise wait for them until it gives up.
+type streamCloser struct {
+	once sync.Once
+	done chan struct{}
+	held sync.WaitGroup
+}
+
+func newStreamCloser() *streamCloser {
+	return &streamCloser{done: make(chan struct{})}
+}
+
+// Done is closed once the streams must end.
+func (c *streamCloser) Done() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.done
+}
+
+func (c *streamCloser) Close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// hold makes the shutdown wait for a stream until the returned func is
+// called. The server waits for its requests, but not for the connections
+// handed over to WebSockets, so these hold themselves before the upgrade.
+func (c *streamCloser) hold() func() {
+	if c == nil {
+		return func() {}
+	}
+	c.held.Add(1)
+	return c.held.Done
+}
+
+// Wait blocks until every held stream is released, or ctx is done.
+func (c *streamCloser) Wait(ctx context.Context) error {
+	released := make(chan struct{})
+	go func() {
+		c.held.Wait()
+		close(released)
+	}()
+
+	select {
+	case <-released:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"star-wars/pkg/planet"
+	"star-wars/pkg/webhook"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	mongoBackend  = "mongo"
+	memoryBackend = "memory"
+	sqliteBackend = "sqlite"
+)
+
+// webhookCollection is the MongoDB collection of the webhook subscriptions,
+// in the database of the planets.
+const webhookCollection = "webhooks"
+
+// newRepositories builds the planet and webhook storage picked by
+// STORAGE_BACKEND: MongoDB by default, in memory or in an embedded SQLite
+// database. Webhooks are stored next to the planets.
+func (a *App) newRepositories() (planet.Repository, webhook.Repository) {
+	switch backend := viper.GetString("STORAGE_BACKEND"); backend {
+	case "", mongoBackend:
+		collection := mongoCollection()
+		planetRepository := planet.NewMongoRepository(collection, viper.GetDuration("MONGO_TIMEOUT"))
+		webhookRepository := webhook.NewMongoRepository(collection.Database().Collection(webhookCollection))
+		ensureIndexes(planetRepository, webhookRepository)
+		return planetRepository, webhookRepository
+	case memoryBackend:
+		planetRepository, err := a.memoryRepository(viper.GetString("MEMORY_FIXTURE"), viper.GetString("MEMORY_SNAPSHOT"))
+		if err != nil {
+			log.Fatal("Error trying to load the in-memory planets.", err)
+		}
+		return planetRepository, webhook.NewMemoryRepository()
+	case sqliteBackend:
+		planetRepository, webhookRepository, err := a.sqliteRepositories(viper.GetString("SQLITE_PATH"))
+		if err != nil {
+			log.Fatal("Error trying to open the SQLite database.", err)
+		}
+		return planetRepository, webhookRepository
+	default:
+		log.Fatalf("Unknown storage backend %q, use %q, %q or %q.", backend, mongoBackend, memoryBackend, sqliteBackend)
+		return nil, nil
+	}
+}
+
+// memoryRepository keeps planets in memory, for local development without
+// any external dependency. It starts from the snapshot left by the previous
+// run when there is one, or else from the fixture, and writes the snapshot
+// back on shutdown, once the background jobs stopped writing. Both files are
+// optional.
+func (a *App) memoryRepository(fixture, snapshot string) (*planet.MemoryRepository, error) {
+	planetRepository := planet.NewMemoryRepository()
+
+	source := fixture
+	if snapshot != "" {
+		if _, err := os.Stat(snapshot); err == nil {
+			source = snapshot
+		}
+	}
+	if source != "" {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if err := planetRepository.Load(file); err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded the in-memory planets from %s", source)
+	}
+
+	if snapshot != "" {
+		a.onShutdown = append(a.onShutdown, func(ctx context.Context) error {
+			return writeSnapshot(planetRepository, snapshot)
+		})
+	}
+
+	return planetRepository, nil
+}
+
+// sqliteRepositories store planets and webhooks in an embedded SQLite
+// database file, for deployments that cannot run MongoDB. The schema is
+// created on first use and the database is closed on shutdown.
+func (a *App) sqliteRepositories(path string) (*planet.SQLRepository, *webhook.SQLRepository, error) {
+	// WAL lets listings read while a write is in progress, and the busy
+	// timeout makes concurrent writers wait for each other instead of failing.
+	// Immediate transactions take the write lock upfront, as updates read the
+	// planet before writing it.
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
+	defer cancel()
+
+	planetRepository := planet.NewSQLRepository(db).WithTimeout(viper.GetDuration("SQLITE_TIMEOUT"))
+	if err := planetRepository.EnsureSchema(ctx); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	webhookRepository := webhook.NewSQLRepository(db)
+	if err := webhookRepository.EnsureSchema(ctx); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	a.onShutdown = append(a.onShutdown, func(ctx context.Context) error {
+		return db.Close()
+	})
+	return planetRepository, webhookRepository, nil
+}
+
+// writeSnapshot replaces the snapshot file at once, so a failed write never
+// leaves a truncated snapshot behind.
+func writeSnapshot(planetRepository *planet.MemoryRepository, path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := planetRepository.Snapshot(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+
+	log.Printf("Saved the in-memory planets to %s", path)
+	return nil
+}
+
+func mongoCollection() *driver.Collection {
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	client, err := driver.Connect(ctx, options.Client().ApplyURI(viper.GetString("MONGO_URI")))
+
+	if err != nil {
+		log.Fatal("Error trying to connect to the database.", err)
+	}
+
+	return client.Database(viper.GetString("MONGO_DB")).Collection(viper.GetString("MONGO_COLLECTION"))
+}
+
+func ensureIndexes(planetRepository *planet.MongoRepository, webhookRepository *webhook.MongoRepository) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
+	defer cancel()
+
+	if err := planetRepository.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Error trying to create the database indexes.", err)
+	}
+	if err := webhookRepository.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Error trying to create the database indexes.", err)
+	}
+	if err := planetRepository.EnsureVersions(ctx); err != nil {
+		log.Fatal("Error trying to version the stored planets.", err)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"star-wars/pkg/planet"
+	"star-wars/pkg/webhook"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_memoryRepository(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "fixture.json")
+	snapshot := filepath.Join(dir, "snapshot.json")
+	if err := os.WriteFile(fixture, []byte(`[{"name": "Tatooine"}]`), 0o644); err != nil {
+		t.Fatalf("memoryRepository() an error occurred writing the fixture for test")
+	}
+
+	app := App{server: &http.Server{}}
+	planetRepository, err := app.memoryRepository(fixture, snapshot)
+	if err != nil {
+		t.Fatalf("memoryRepository() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Tatooine"}, repositoryPlanetNames(t, planetRepository), "memoryRepository() the fixture should be loaded")
+
+	planetService := planet.NewService(planetRepository)
+	if _, err := planetService.Insert(ctx, planet.Planet{Name: "Hoth"}); err != nil {
+		t.Fatalf("memoryRepository() an error occurred inserting a planet for test")
+	}
+	if err := app.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() unexpected error %v", err)
+	}
+
+	restarted := App{server: &http.Server{}}
+	planetRepository, err = restarted.memoryRepository(fixture, snapshot)
+	if err != nil {
+		t.Fatalf("memoryRepository() unexpected error %v", err)
+	}
+	assert.ElementsMatch(t, []string{"Tatooine", "Hoth"}, repositoryPlanetNames(t, planetRepository), "memoryRepository() the snapshot should win over the fixture")
+
+	if _, err := (&App{}).memoryRepository(filepath.Join(dir, "missing.json"), ""); err == nil {
+		t.Errorf("memoryRepository() a missing fixture should fail")
+	}
+}
+
+func TestApp_memoryRepository_snapshotAfterJobs(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "snapshot.json")
+
+	app := App{server: &http.Server{}}
+	planetRepository, err := app.memoryRepository("", snapshot)
+	if err != nil {
+		t.Fatalf("memoryRepository() unexpected error %v", err)
+	}
+	planetService := planet.NewService(planetRepository)
+
+	jobs := newJobGroup()
+	app.jobs = jobs
+	ctx := context.Background()
+	started := make(chan struct{})
+	jobs.run(ctx, "import", time.Millisecond, func(ctx context.Context) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		// The write of a job still running on shutdown must reach the snapshot.
+		_, err := planetService.Insert(context.Background(), planet.Planet{Name: "Dagobah"})
+		return err
+	})
+	<-started
+
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() unexpected error %v", err)
+	}
+
+	restarted := App{server: &http.Server{}}
+	planetRepository, err = restarted.memoryRepository("", snapshot)
+	if err != nil {
+		t.Fatalf("memoryRepository() unexpected error %v", err)
+	}
+	assert.Equal(t, []string{"Dagobah"}, repositoryPlanetNames(t, planetRepository), "Shutdown() the snapshot should be written after the jobs stopped")
+}
+
+func repositoryPlanetNames(t *testing.T, planetRepository planet.Repository) []string {
+	var names []string
+	err := planetRepository.List(context.Background(), planet.Criteria{}, func(p planet.Planet) error {
+		names = append(names, p.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("an error occurred listing planets for test")
+	}
+	return names
+}
+
+func TestApp_sqliteRepositories(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "planets.db")
+
+	app := App{server: &http.Server{}}
+	planetRepository, webhookRepository, err := app.sqliteRepositories(path)
+	if err != nil {
+		t.Fatalf("sqliteRepositories() unexpected error %v", err)
+	}
+	if _, err := planet.NewService(planetRepository).Insert(ctx, planet.Planet{Name: "Hoth"}); err != nil {
+		t.Fatalf("sqliteRepositories() an error occurred inserting a planet for test: %v", err)
+	}
+	hooks, err := webhook.NewService(webhookRepository, nil).Create(ctx, webhook.Subscription{URL: "https://example.com/hooks"})
+	if err != nil {
+		t.Fatalf("sqliteRepositories() an error occurred creating a webhook for test: %v", err)
+	}
+	if err := app.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() unexpected error %v", err)
+	}
+
+	reopened := App{server: &http.Server{}}
+	planetRepository, webhookRepository, err = reopened.sqliteRepositories(path)
+	if err != nil {
+		t.Fatalf("sqliteRepositories() unexpected error reopening the database %v", err)
+	}
+	defer reopened.Shutdown(ctx)
+	assert.Equal(t, []string{"Hoth"}, repositoryPlanetNames(t, planetRepository), "sqliteRepositories() the planets should be kept in the file")
+	if _, err := webhookRepository.FindSubscription(ctx, hooks.ID); err != nil {
+		t.Errorf("sqliteRepositories() the webhooks should be kept in the file, unexpected error %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"star-wars/pkg/planet"
+	"star-wars/pkg/webhook"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebhookDTO shows a subscription. The secret is only shown when the
+// subscription is created.
+type WebhookDTO struct {
+	ID         primitive.ObjectID `json:"id"`
+	URL        string             `json:"url"`
+	EventTypes []string           `json:"event_types"`
+	Secret     string             `json:"secret,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+}
+
+// webhookRequest is the payload accepted to create or replace a
+// subscription. No event type subscribes to every event, and a secret is
+// generated when none is given.
+type webhookRequest struct {
+	URL        string   `json:"url" validate:"required,max=2048,http_url"`
+	EventTypes []string `json:"event_types" validate:"omitempty,max=3,dive,oneof=PlanetCreated PlanetUpdated PlanetDeleted"`
+	Secret     string   `json:"secret" validate:"omitempty,min=16,max=256"`
+}
+
+type deliveryDTO struct {
+	ID            primitive.ObjectID `json:"id"`
+	EventID       primitive.ObjectID `json:"event_id"`
+	EventType     string             `json:"event_type"`
+	Status        string             `json:"status"`
+	Attempts      []webhook.Attempt  `json:"attempts"`
+	NextAttemptAt *time.Time         `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	Payload       json.RawMessage    `json:"payload"`
+}
+
+func (w webhookRequest) toSubscription(id primitive.ObjectID) webhook.Subscription {
+	eventTypes := make([]planet.EventType, 0, len(w.EventTypes))
+	for _, eventType := range w.EventTypes {
+		eventTypes = append(eventTypes, planet.EventType(eventType))
+	}
+	return webhook.Subscription{
+		ID:         id,
+		URL:        w.URL,
+		EventTypes: eventTypes,
+		Secret:     w.Secret,
+	}
+}
+
+func newWebhookDTO(s webhook.Subscription) WebhookDTO {
+	eventTypes := make([]string, 0, len(s.EventTypes))
+	for _, eventType := range s.EventTypes {
+		eventTypes = append(eventTypes, string(eventType))
+	}
+	return WebhookDTO{
+		ID:         s.ID,
+		URL:        s.URL,
+		EventTypes: eventTypes,
+		CreatedAt:  s.CreatedAt,
+		UpdatedAt:  s.UpdatedAt,
+	}
+}
+
+func newDeliveryDTO(d webhook.Delivery) deliveryDTO {
+	dto := deliveryDTO{
+		ID:        d.ID,
+		EventID:   d.EventID,
+		EventType: string(d.EventType),
+		Status:    d.Status,
+		Attempts:  d.Attempts,
+		CreatedAt: d.CreatedAt,
+		Payload:   d.Payload,
+	}
+	if dto.Attempts == nil {
+		dto.Attempts = []webhook.Attempt{}
+	}
+	if d.Status == webhook.DeliveryPending {
+		nextAttemptAt := d.NextAttemptAt
+		dto.NextAttemptAt = &nextAttemptAt
+	}
+	return dto
+}
+
+// writeWebhookError answers a missing subscription with a 404, and any other
+// failed read with a 500.
+// writeWebhookSaveError writes the failure to save a subscription, a
+// rejected URL being the client's fault.
+func writeWebhookSaveError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, webhook.ErrPrivateURL) {
+		details := []map[string]string{{"name": "URL", "reason": "must not point to a private address"}}
+		writeJsonResponse(rw, http.StatusUnprocessableEntity, errorMessage{ErrorCode: "WA:001", Message: "payload is invalid", Details: details})
+		return
+	}
+	writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:028", Message: "failed to save the webhook"})
+}
+
+func writeWebhookError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+		writeJsonResponse(rw, http.StatusNotFound, errorMessage{ErrorCode: "WA:027", Message: "webhook not found"})
+		return
+	}
+	writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:029", Message: "failed to retrieve the webhooks"})
+}
+
+type WebhookCreator interface {
+	Create(ctx context.Context, subscription webhook.Subscription) (webhook.Subscription, error)
+}
+
+func (a *App) handleCreateWebhook(webhookCreator WebhookCreator) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+
+		var webhookRequest webhookRequest
+		if err := decodeAndValidate(rw, r, &webhookRequest); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		saved, err := webhookCreator.Create(ctx, webhookRequest.toSubscription(primitive.NilObjectID))
+		if err != nil {
+			logger.Error(err.Error())
+			writeWebhookSaveError(rw, err)
+			return
+		}
+
+		dto := newWebhookDTO(saved)
+		dto.Secret = saved.Secret
+		writeJsonResponse(rw, http.StatusCreated, dto)
+	}
+}
+
+type WebhookLister interface {
+	List(ctx context.Context) ([]webhook.Subscription, error)
+}
+
+func (a *App) handleListWebhooks(webhookLister WebhookLister) http.HandlerFunc {
+	type response struct {
+		Webhooks []WebhookDTO `json:"webhooks"`
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		subscriptions, err := webhookLister.List(r.Context())
+		if err != nil {
+			loggerFromRequest(r).Error(err.Error())
+			writeWebhookError(rw, err)
+			return
+		}
+
+		res := response{Webhooks: make([]WebhookDTO, 0, len(subscriptions))}
+		for _, subscription := range subscriptions {
+			res.Webhooks = append(res.Webhooks, newWebhookDTO(subscription))
+		}
+		writeJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+type WebhookGetter interface {
+	Get(ctx context.Context, id string) (webhook.Subscription, error)
+}
+
+func (a *App) handleGetWebhook(webhookGetter WebhookGetter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		subscription, err := webhookGetter.Get(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			loggerFromRequest(r).Error(err.Error())
+			writeWebhookError(rw, err)
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusOK, newWebhookDTO(subscription))
+	}
+}
+
+type WebhookUpdater interface {
+	Update(ctx context.Context, subscription webhook.Subscription) (webhook.Subscription, error)
+}
+
+// handleUpdateWebhook replaces the URL and event types of a subscription,
+// and its secret when one is given.
+func (a *App) handleUpdateWebhook(webhookUpdater WebhookUpdater) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		id, _ := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+
+		var webhookRequest webhookRequest
+		if err := decodeAndValidate(rw, r, &webhookRequest); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		saved, err := webhookUpdater.Update(ctx, webhookRequest.toSubscription(id))
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+				writeWebhookError(rw, err)
+				return
+			}
+			writeWebhookSaveError(rw, err)
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusOK, newWebhookDTO(saved))
+	}
+}
+
+type WebhookDeleter interface {
+	Delete(ctx context.Context, id string) error
+}
+
+func (a *App) handleDeleteWebhook(webhookDeleter WebhookDeleter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		logger := loggerFromRequest(r)
+
+		if err := webhookDeleter.Delete(r.Context(), mux.Vars(r)["id"]); err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+				writeWebhookError(rw, err)
+				return
+			}
+			writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:028", Message: "failed to save the webhook"})
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusNoContent, nil)
+	}
+}
+
+type DeliveryLister interface {
+	Deliveries(ctx context.Context, subscriptionID string, status string, limit int64) ([]webhook.Delivery, error)
+}
+
+// handleListDeliveries shows the delivery log of a subscription, newest
+// first. The status parameter narrows it down to pending, succeeded or dead
+// deliveries.
+func (a *App) handleListDeliveries(deliveryLister DeliveryLister) http.HandlerFunc {
+	return a.listDeliveriesHandler(deliveryLister, "")
+}
+
+// handleListDeadLetters shows the deliveries of a subscription that failed
+// every attempt, newest first.
+func (a *App) handleListDeadLetters(deliveryLister DeliveryLister) http.HandlerFunc {
+	return a.listDeliveriesHandler(deliveryLister, webhook.DeliveryDead)
+}
+
+func (a *App) listDeliveriesHandler(deliveryLister DeliveryLister, status string) http.HandlerFunc {
+	type response struct {
+		Deliveries []deliveryDTO `json:"deliveries"`
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := loggerFromRequest(r)
+		query := r.URL.Query()
+
+		var details []map[string]string
+		var limit int64
+		if raw := query.Get("limit"); raw != "" {
+			parsed, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || parsed < 1 || parsed > webhook.MaxDeliveryLimit {
+				details = append(details, map[string]string{"name": "limit", "reason": "must be an integer between 1 and " + strconv.Itoa(webhook.MaxDeliveryLimit)})
+			}
+			limit = parsed
+		}
+		if status == "" {
+			switch status = query.Get("status"); status {
+			case "", webhook.DeliveryPending, webhook.DeliverySucceeded, webhook.DeliveryDead:
+			default:
+				details = append(details, map[string]string{"name": "status", "reason": "must be pending, succeeded or dead"})
+			}
+		}
+		if len(details) > 0 {
+			writeJsonResponse(rw, http.StatusBadRequest, errorMessage{ErrorCode: "WA:008", Message: "invalid query parameter", Details: details})
+			return
+		}
+
+		deliveries, err := deliveryLister.Deliveries(ctx, mux.Vars(r)["id"], status, limit)
+		if err != nil {
+			logger.Error(err.Error())
+			writeWebhookError(rw, err)
+			return
+		}
+
+		res := response{Deliveries: make([]deliveryDTO, 0, len(deliveries))}
+		for _, delivery := range deliveries {
+			res.Deliveries = append(res.Deliveries, newDeliveryDTO(delivery))
+		}
+		writeJsonResponse(rw, http.StatusOK, res)
+	}
+}
+
+type DeadLetterReplayer interface {
+	Replay(ctx context.Context, subscriptionID, deliveryID string) (webhook.Delivery, error)
+	ReplayAll(ctx context.Context, subscriptionID string) (int, error)
+}
+
+// handleReplayDeadLetter sends a dead delivery again, as soon as the
+// delivery job runs.
+func (a *App) handleReplayDeadLetter(deadLetterReplayer DeadLetterReplayer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		logger := loggerFromRequest(r)
+		vars := mux.Vars(r)
+
+		delivery, err := deadLetterReplayer.Replay(r.Context(), vars["id"], vars["delivery"])
+		if err != nil {
+			logger.Error(err.Error())
+			switch {
+			case errors.Is(err, webhook.ErrSubscriptionNotFound):
+				writeWebhookError(rw, err)
+			case errors.Is(err, webhook.ErrDeliveryNotFound):
+				writeJsonResponse(rw, http.StatusNotFound, errorMessage{ErrorCode: "WA:030", Message: "dead letter not found"})
+			default:
+				writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:031", Message: "failed to replay the dead letters"})
+			}
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusAccepted, newDeliveryDTO(delivery))
+	}
+}
+
+// handleReplayDeadLetters sends every dead delivery of a subscription again.
+func (a *App) handleReplayDeadLetters(deadLetterReplayer DeadLetterReplayer) http.HandlerFunc {
+	type response struct {
+		Replayed int `json:"replayed"`
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		logger := loggerFromRequest(r)
+
+		replayed, err := deadLetterReplayer.ReplayAll(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			logger.Error(err.Error())
+			if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+				writeWebhookError(rw, err)
+				return
+			}
+			writeJsonResponse(rw, http.StatusInternalServerError, errorMessage{ErrorCode: "WA:031", Message: "failed to replay the dead letters"})
+			return
+		}
+
+		writeJsonResponse(rw, http.StatusAccepted, response{Replayed: replayed})
+	}
+}
+
+// webhookTransport is what the webhooks are posted with. Unless private URLs
+// are allowed, it refuses to connect to private addresses, which a public
+// host name could resolve to, and goes without proxy, the proxy being dialed
+// instead of the endpoint.
+func webhookTransport(allowPrivate bool) http.RoundTripper {
+	if allowPrivate {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = webhook.PublicDialer(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	return transport
+}
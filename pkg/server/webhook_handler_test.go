@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"star-wars/pkg/planet"
+	"star-wars/pkg/webhook"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type webhookCreatorMock struct {
+	result webhook.Subscription
+	err    error
+}
+
+func (a webhookCreatorMock) Create(ctx context.Context, subscription webhook.Subscription) (webhook.Subscription, error) {
+	return a.result, a.err
+}
+
+func Test_handleCreateWebhook(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	createdAt := time.Date(2021, 7, 21, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name               string
+		givenBody          string
+		webhookCreatorMock webhookCreatorMock
+		wantStatusCode     int
+		wantResponseBody   string
+	}{
+		{
+			name:      "when the webhook is valid then it should return 201 status with its secret",
+			givenBody: `{"url":"https://example.com/hooks","event_types":["PlanetCreated"]}`,
+			webhookCreatorMock: webhookCreatorMock{result: webhook.Subscription{
+				ID: objectID, URL: "https://example.com/hooks", EventTypes: []planet.EventType{planet.PlanetCreated},
+				Secret: "s3cr3t", CreatedAt: createdAt, UpdatedAt: createdAt,
+			}},
+			wantStatusCode:   201,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","url":"https://example.com/hooks","event_types":["PlanetCreated"],"secret":"s3cr3t","created_at":"2021-07-21T10:00:00Z","updated_at":"2021-07-21T10:00:00Z"}`,
+		},
+		{
+			name:             "when the url cannot be posted to then it should return 422 status",
+			givenBody:        `{"url":"ftp://example.com/hooks"}`,
+			wantStatusCode:   422,
+			wantResponseBody: `{"error_code":"WA:001","message":"payload is invalid","details":[{"name":"URL","reason":"Key: 'webhookRequest.URL' Error:Field validation for 'URL' failed on the 'http_url' tag"}]}`,
+		},
+		{
+			name:             "when an event type is unknown then it should return 422 status",
+			givenBody:        `{"url":"https://example.com/hooks","event_types":["PlanetExploded"]}`,
+			wantStatusCode:   422,
+			wantResponseBody: `{"error_code":"WA:001","message":"payload is invalid","details":[{"name":"EventTypes[0]","reason":"Key: 'webhookRequest.EventTypes[0]' Error:Field validation for 'EventTypes[0]' failed on the 'oneof' tag"}]}`,
+		},
+		{
+			name:               "when the url points to a private address then it should return 422 status",
+			givenBody:          `{"url":"http://10.0.0.1/hooks"}`,
+			webhookCreatorMock: webhookCreatorMock{err: webhook.ErrPrivateURL},
+			wantStatusCode:     422,
+			wantResponseBody:   `{"error_code":"WA:001","message":"payload is invalid","details":[{"name":"URL","reason":"must not point to a private address"}]}`,
+		},
+		{
+			name:               "when got generic error from database then it should return 500 status",
+			givenBody:          `{"url":"https://example.com/hooks"}`,
+			webhookCreatorMock: webhookCreatorMock{err: errors.New("database error")},
+			wantStatusCode:     500,
+			wantResponseBody:   `{"error_code":"WA:028","message":"failed to save the webhook"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{webhookCreator: tc.webhookCreatorMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("POST", "/v1/webhooks", strings.NewReader(tc.givenBody))
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleCreateWebhook() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleCreateWebhook() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+type webhookGetterMock struct {
+	result webhook.Subscription
+	err    error
+}
+
+func (a webhookGetterMock) Get(ctx context.Context, id string) (webhook.Subscription, error) {
+	return a.result, a.err
+}
+
+func Test_handleGetWebhook(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	createdAt := time.Date(2021, 7, 21, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name              string
+		webhookGetterMock webhookGetterMock
+		wantStatusCode    int
+		wantResponseBody  string
+	}{
+		{
+			name: "when the webhook exists then it should return 200 status without its secret",
+			webhookGetterMock: webhookGetterMock{result: webhook.Subscription{
+				ID: objectID, URL: "https://example.com/hooks", Secret: "s3cr3t", CreatedAt: createdAt, UpdatedAt: createdAt,
+			}},
+			wantStatusCode:   200,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","url":"https://example.com/hooks","event_types":[],"created_at":"2021-07-21T10:00:00Z","updated_at":"2021-07-21T10:00:00Z"}`,
+		},
+		{
+			name:              "when the webhook does not exist then it should return 404 status",
+			webhookGetterMock: webhookGetterMock{err: webhook.ErrSubscriptionNotFound},
+			wantStatusCode:    404,
+			wantResponseBody:  `{"error_code":"WA:027","message":"webhook not found"}`,
+		},
+		{
+			name:              "when got generic error from database then it should return 500 status",
+			webhookGetterMock: webhookGetterMock{err: errors.New("database error")},
+			wantStatusCode:    500,
+			wantResponseBody:  `{"error_code":"WA:029","message":"failed to retrieve the webhooks"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{webhookGetter: tc.webhookGetterMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", "/v1/webhooks/5f165e2e4de9b442e60b3904", nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleGetWebhook() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleGetWebhook() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+type deliveryListerMock struct {
+	status string
+	limit  int64
+}
+
+func (a *deliveryListerMock) Deliveries(ctx context.Context, subscriptionID string, status string, limit int64) ([]webhook.Delivery, error) {
+	a.status, a.limit = status, limit
+	return []webhook.Delivery{}, nil
+}
+
+func Test_handleListDeliveries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		givenPath        string
+		wantStatusCode   int
+		wantResponseBody string
+		wantStatus       string
+		wantLimit        int64
+	}{
+		{
+			name:             "when the log is filtered then it should return 200 status with the deliveries",
+			givenPath:        "/v1/webhooks/5f165e2e4de9b442e60b3904/deliveries?status=pending&limit=5",
+			wantStatusCode:   200,
+			wantResponseBody: `{"deliveries":[]}`,
+			wantStatus:       webhook.DeliveryPending,
+			wantLimit:        5,
+		},
+		{
+			name:             "when the dead letters are listed then it should only ask for dead deliveries",
+			givenPath:        "/v1/webhooks/5f165e2e4de9b442e60b3904/dead-letters",
+			wantStatusCode:   200,
+			wantResponseBody: `{"deliveries":[]}`,
+			wantStatus:       webhook.DeliveryDead,
+		},
+		{
+			name:             "when the parameters are invalid then it should return 400 status",
+			givenPath:        "/v1/webhooks/5f165e2e4de9b442e60b3904/deliveries?status=lost&limit=0",
+			wantStatusCode:   400,
+			wantResponseBody: `{"error_code":"WA:008","message":"invalid query parameter","details":[{"name":"limit","reason":"must be an integer between 1 and 100"},{"name":"status","reason":"must be pending, succeeded or dead"}]}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			deliveryLister := &deliveryListerMock{}
+			var app App
+			app.container = &container{deliveryLister: deliveryLister}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("GET", tc.givenPath, nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleListDeliveries() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleListDeliveries() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+			if deliveryLister.status != tc.wantStatus || deliveryLister.limit != tc.wantLimit {
+				t.Errorf("handleListDeliveries() asked for %q limited to %d, want %q limited to %d", deliveryLister.status, deliveryLister.limit, tc.wantStatus, tc.wantLimit)
+			}
+		})
+	}
+}
+
+type deadLetterReplayerMock struct {
+	result webhook.Delivery
+	err    error
+}
+
+func (a deadLetterReplayerMock) Replay(ctx context.Context, subscriptionID, deliveryID string) (webhook.Delivery, error) {
+	return a.result, a.err
+}
+
+func (a deadLetterReplayerMock) ReplayAll(ctx context.Context, subscriptionID string) (int, error) {
+	return 2, a.err
+}
+
+func Test_handleReplayDeadLetter(t *testing.T) {
+	t.Parallel()
+
+	objectID, _ := primitive.ObjectIDFromHex("5f165e2e4de9b442e60b3904")
+	now := time.Date(2021, 7, 21, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name                   string
+		givenPath              string
+		deadLetterReplayerMock deadLetterReplayerMock
+		wantStatusCode         int
+		wantResponseBody       string
+	}{
+		{
+			name:      "when the delivery is dead then it should return 202 status with the pending delivery",
+			givenPath: "/v1/webhooks/5f165e2e4de9b442e60b3904/dead-letters/5f165e2e4de9b442e60b3904:replay",
+			deadLetterReplayerMock: deadLetterReplayerMock{result: webhook.Delivery{
+				ID: objectID, EventID: objectID, EventType: planet.PlanetDeleted, Status: webhook.DeliveryPending,
+				NextAttemptAt: now, CreatedAt: now, Payload: []byte(`{"type":"PlanetDeleted"}`),
+			}},
+			wantStatusCode:   202,
+			wantResponseBody: `{"id":"5f165e2e4de9b442e60b3904","event_id":"5f165e2e4de9b442e60b3904","event_type":"PlanetDeleted","status":"pending","attempts":[],"next_attempt_at":"2021-07-21T10:00:00Z","created_at":"2021-07-21T10:00:00Z","payload":{"type":"PlanetDeleted"}}`,
+		},
+		{
+			name:                   "when the delivery is not dead then it should return 404 status",
+			givenPath:              "/v1/webhooks/5f165e2e4de9b442e60b3904/dead-letters/5f165e2e4de9b442e60b3904:replay",
+			deadLetterReplayerMock: deadLetterReplayerMock{err: webhook.ErrDeliveryNotFound},
+			wantStatusCode:         404,
+			wantResponseBody:       `{"error_code":"WA:030","message":"dead letter not found"}`,
+		},
+		{
+			name:                   "when the webhook does not exist then it should return 404 status",
+			givenPath:              "/v1/webhooks/5f165e2e4de9b442e60b3904/dead-letters:replay",
+			deadLetterReplayerMock: deadLetterReplayerMock{err: webhook.ErrSubscriptionNotFound},
+			wantStatusCode:         404,
+			wantResponseBody:       `{"error_code":"WA:027","message":"webhook not found"}`,
+		},
+		{
+			name:             "when every dead letter is replayed then it should return 202 status with how many",
+			givenPath:        "/v1/webhooks/5f165e2e4de9b442e60b3904/dead-letters:replay",
+			wantStatusCode:   202,
+			wantResponseBody: `{"replayed":2}`,
+		},
+		{
+			name:                   "when got generic error from database then it should return 500 status",
+			givenPath:              "/v1/webhooks/5f165e2e4de9b442e60b3904/dead-letters/5f165e2e4de9b442e60b3904:replay",
+			deadLetterReplayerMock: deadLetterReplayerMock{err: errors.New("database error")},
+			wantStatusCode:         500,
+			wantResponseBody:       `{"error_code":"WA:031","message":"failed to replay the dead letters"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var app App
+			app.container = &container{deadLetterReplayer: tc.deadLetterReplayerMock}
+			app.RegisterRoutes()
+
+			req, _ := http.NewRequest("POST", tc.givenPath, nil)
+			rr := httptest.NewRecorder()
+			app.ServeHTTP(rr, req)
+			if rr.Code != tc.wantStatusCode {
+				t.Errorf("handleReplayDeadLetter() status code = %v, want %v", rr.Code, tc.wantStatusCode)
+			}
+			if got, _ := ioutil.ReadAll(rr.Result().Body); string(got) != tc.wantResponseBody {
+				t.Errorf("handleReplayDeadLetter() body = %v, want %v", string(got), tc.wantResponseBody)
+			}
+		})
+	}
+}
+
+func TestApp_webhooks(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var received []string
+	var bodies []string
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r.Header.Get(webhook.HeaderEvent))
+		bodies = append(bodies, string(body))
+	}))
+	defer endpoint.Close()
+
+	events := planet.NewInProcessPublisher()
+	planetService := planet.NewService(planet.NewMemoryRepository()).WithEventPublisher(events)
+	webhookService := webhook.NewService(webhook.NewMemoryRepository(), endpoint.Client()).
+		WithEncoder(encodeWebhookEvent).
+		WithPrivateURLs(true)
+	events.Subscribe(webhookService.Enqueue)
+	var app App
+	app.container = NewContainer(planetService, webhookService, planet.NewEventHub(1), newPlanetCache(planetService, 10, time.Minute))
+	app.RegisterRoutes()
+
+	req, _ := http.NewRequest("POST", "/v1/webhooks", strings.NewReader(`{"url":"`+endpoint.URL+`","event_types":["PlanetCreated"]}`))
+	rr := httptest.NewRecorder()
+	app.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("unexpected status code creating a webhook for test, got %v", rr.Code)
+	}
+	if _, err := planetService.Insert(ctx, planet.Planet{Name: "Hoth"}); err != nil {
+		t.Fatalf("an error occurred inserting a planet for test: %v", err)
+	}
+
+	if err := app.outboxJob(app.container.eventRelayer)(ctx); err != nil {
+		t.Fatalf("outboxJob() unexpected error %v", err)
+	}
+	if err := app.webhookJob(app.container.webhookDeliverer)(ctx); err != nil {
+		t.Fatalf("webhookJob() unexpected error %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0] != string(planet.PlanetCreated) {
+		t.Fatalf("webhookJob() received %v, want a single %s", received, planet.PlanetCreated)
+	}
+	if !strings.Contains(bodies[0], `"type":"PlanetCreated"`) || !strings.Contains(bodies[0], `"population":"unknown"`) {
+		t.Errorf("webhookJob() body = %v, want the event as the streams send it", bodies[0])
+	}
+	if strings.Contains(bodies[0], "updated_at") {
+		t.Errorf("webhookJob() body = %v, should not carry the stored fields", bodies[0])
+	}
+}
@@ -0,0 +1,99 @@
+package swapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrPlanetNotFound = errors.New("planet not found in swapi")
+)
+
+// Client talks to SWAPI, or any service exposing the same planets resource.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+type Planet struct {
+	Name  string   `json:"name"`
+	Films []string `json:"films"`
+	URL   string   `json:"url"`
+}
+
+type planetsPage struct {
+	Next    string   `json:"next"`
+	Results []Planet `json:"results"`
+}
+
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// FindPlanetByName searches the planets resource and returns the planet whose
+// name matches exactly, ignoring case. Search results are followed across pages.
+func (c *Client) FindPlanetByName(ctx context.Context, name string) (Planet, error) {
+	next := c.baseURL + "/planets/?search=" + url.QueryEscape(name)
+
+	for next != "" {
+		var page planetsPage
+		if err := c.get(ctx, next, &page); err != nil {
+			return Planet{}, err
+		}
+		for _, p := range page.Results {
+			if strings.EqualFold(p.Name, name) {
+				return p, nil
+			}
+		}
+		next = page.Next
+	}
+
+	return Planet{}, ErrPlanetNotFound
+}
+
+// Films returns the URLs of the films a planet appeared in. A planet unknown
+// to SWAPI did not appear in any film, so it is not reported as an error.
+func (c *Client) Films(ctx context.Context, planetName string) ([]string, error) {
+	p, err := c.FindPlanetByName(ctx, planetName)
+	if errors.Is(err, ErrPlanetNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Films == nil {
+		return []string{}, nil
+	}
+	return p.Films, nil
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("swapi responded %d for %s", res.StatusCode, endpoint)
+	}
+
+	return json.NewDecoder(res.Body).Decode(dest)
+}
@@ -0,0 +1,92 @@
+package swapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSwapiServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/planets/" {
+			t.Errorf("unexpected swapi path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("search") {
+		case "tatooine":
+			fmt.Fprint(w, `{"next":null,"results":[{"name":"Tatooine","films":["https://swapi.dev/api/films/1/","https://swapi.dev/api/films/3/"]}]}`)
+		case "Hoth":
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `{"next":null,"results":[{"name":"Hoth","films":["https://swapi.dev/api/films/2/"]}]}`)
+				return
+			}
+			fmt.Fprintf(w, `{"next":"%s/planets/?search=Hoth&page=2","results":[{"name":"Hothine","films":[]}]}`, server.URL)
+		case "Broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			fmt.Fprint(w, `{"next":null,"results":[]}`)
+		}
+	}))
+	return server
+}
+
+func TestClient_Films(t *testing.T) {
+	server := newSwapiServer(t)
+	defer server.Close()
+
+	client := NewClient(server.URL+"/", server.Client())
+
+	tests := []struct {
+		name      string
+		givenName string
+		wantFilms []string
+		wantErr   bool
+	}{
+		{
+			name:      "when the planet exists with a different case, then it should return its films",
+			givenName: "tatooine",
+			wantFilms: []string{"https://swapi.dev/api/films/1/", "https://swapi.dev/api/films/3/"},
+		},
+		{
+			name:      "when the exact match is on another page, then it should follow the next page",
+			givenName: "Hoth",
+			wantFilms: []string{"https://swapi.dev/api/films/2/"},
+		},
+		{
+			name:      "when the planet does not exist, then it should return no films",
+			givenName: "Pluto",
+			wantFilms: []string{},
+		},
+		{
+			name:      "when swapi fails, then it should return an error",
+			givenName: "Broken",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			films, err := client.Films(context.Background(), tt.givenName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.Films() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantFilms, films, "Client.Films() unexpected films")
+		})
+	}
+}
+
+func TestClient_FindPlanetByName(t *testing.T) {
+	server := newSwapiServer(t)
+	defer server.Close()
+
+	client := NewClient(server.URL, server.Client())
+
+	if _, err := client.FindPlanetByName(context.Background(), "Pluto"); !errors.Is(err, ErrPlanetNotFound) {
+		t.Errorf("Client.FindPlanetByName() errorType = %v, wantErrorType %v", err, ErrPlanetNotFound)
+	}
+}
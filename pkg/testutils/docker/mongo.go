@@ -2,6 +2,7 @@ package docker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"testing"
@@ -11,10 +12,15 @@ import (
 
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
+	"go.mongodb.org/mongo-driver/bson"
 	driver "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// replicaSet is the name of the replica set the container runs, with itself
+// as the only member.
+const replicaSet = "rs0"
+
 type dockerMongo struct {
 	*dockerBase
 }
@@ -32,6 +38,8 @@ func (dm *dockerMongo) WithTestPort(t *testing.T) Docker {
 	return dm.WithPort(testutils.FindAvailablePort("localhost"))
 }
 
+// Wait initiates the single member replica set, which transactions need, and
+// waits for the member to become primary.
 func (dm *dockerMongo) Wait() error {
 	host := dm.GetHost()
 
@@ -44,11 +52,31 @@ func (dm *dockerMongo) Wait() error {
 	}
 	defer client.Disconnect(ctx)
 
-	return err
+	admin := client.Database("admin")
+	err = admin.RunCommand(ctx, bson.M{"replSetInitiate": bson.M{
+		"_id":     replicaSet,
+		"members": bson.A{bson.M{"_id": 0, "host": "localhost:27017"}},
+	}}).Err()
+	var commandErr driver.CommandError
+	if err != nil && !(errors.As(err, &commandErr) && commandErr.Name == "AlreadyInitialized") {
+		return err
+	}
+
+	var hello struct {
+		IsMaster bool `bson:"ismaster"`
+	}
+	if err := admin.RunCommand(ctx, bson.M{"isMaster": 1}).Decode(&hello); err != nil {
+		return err
+	}
+	if !hello.IsMaster {
+		return errors.New("replica set member is not primary yet")
+	}
+
+	return nil
 }
 
 func (dm *dockerMongo) GetHost() string {
-	return fmt.Sprintf("mongodb://localhost:%s/planet?readPreference=primary", dm.port)
+	return fmt.Sprintf("mongodb://localhost:%s/planet?readPreference=primary&directConnection=true", dm.port)
 }
 
 func (dm *dockerMongo) CreateImage() (pool *dockertest.Pool, resource *dockertest.Resource, err error) {
@@ -66,6 +94,7 @@ func (dm *dockerMongo) CreateImage() (pool *dockertest.Pool, resource *dockertes
 		},
 		ExposedPorts: []string{dm.port},
 		Env:          []string{},
+		Cmd:          []string{"--replSet", replicaSet},
 	}
 
 	resource, err = pool.RunWithOptions(&opts)
@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository keeps subscriptions and deliveries in memory. It is safe
+// for concurrent use, and is what the in-memory planet storage goes with.
+type MemoryRepository struct {
+	mu            sync.RWMutex
+	subscriptions map[primitive.ObjectID]Subscription
+	deliveries    map[primitive.ObjectID]Delivery
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		subscriptions: map[primitive.ObjectID]Subscription{},
+		deliveries:    map[primitive.ObjectID]Delivery{},
+	}
+}
+
+func (r *MemoryRepository) InsertSubscription(ctx context.Context, subscription Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.subscriptions[subscription.ID] = cloneSubscription(subscription)
+	return nil
+}
+
+func (r *MemoryRepository) FindSubscription(ctx context.Context, id primitive.ObjectID) (Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subscription, ok := r.subscriptions[id]
+	if !ok {
+		return Subscription{}, ErrSubscriptionNotFound
+	}
+	return cloneSubscription(subscription), nil
+}
+
+func (r *MemoryRepository) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subscriptions := make([]Subscription, 0, len(r.subscriptions))
+	for _, subscription := range r.subscriptions {
+		subscriptions = append(subscriptions, cloneSubscription(subscription))
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID.Hex() < subscriptions[j].ID.Hex()
+	})
+	return subscriptions, nil
+}
+
+func (r *MemoryRepository) UpdateSubscription(ctx context.Context, subscription Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.subscriptions[subscription.ID]; !ok {
+		return ErrSubscriptionNotFound
+	}
+	r.subscriptions[subscription.ID] = cloneSubscription(subscription)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteSubscription(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.subscriptions[id]; !ok {
+		return ErrSubscriptionNotFound
+	}
+	delete(r.subscriptions, id)
+	for deliveryID, delivery := range r.deliveries {
+		if delivery.SubscriptionID == id {
+			delete(r.deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) InsertDeliveries(ctx context.Context, deliveries []Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, delivery := range deliveries {
+		if r.enqueued(delivery.SubscriptionID, delivery.EventID) {
+			continue
+		}
+		r.deliveries[delivery.ID] = cloneDelivery(delivery)
+	}
+	return nil
+}
+
+// enqueued tells whether the event already has a delivery for the
+// subscription. The caller must hold the lock.
+func (r *MemoryRepository) enqueued(subscriptionID, eventID primitive.ObjectID) bool {
+	for _, delivery := range r.deliveries {
+		if delivery.SubscriptionID == subscriptionID && delivery.EventID == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryRepository) ClaimDelivery(ctx context.Context, at, until time.Time) (Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var claimed *Delivery
+	for id, delivery := range r.deliveries {
+		if delivery.Status != DeliveryPending || delivery.NextAttemptAt.After(at) {
+			continue
+		}
+		if claimed == nil || delivery.NextAttemptAt.Before(claimed.NextAttemptAt) ||
+			(delivery.NextAttemptAt.Equal(claimed.NextAttemptAt) && id.Hex() < claimed.ID.Hex()) {
+			delivery := delivery
+			claimed = &delivery
+		}
+	}
+	if claimed == nil {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+
+	claimed.NextAttemptAt = until
+	r.deliveries[claimed.ID] = cloneDelivery(*claimed)
+	return cloneDelivery(*claimed), nil
+}
+
+func (r *MemoryRepository) FindDelivery(ctx context.Context, subscriptionID, id primitive.ObjectID) (Delivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	delivery, ok := r.deliveries[id]
+	if !ok || delivery.SubscriptionID != subscriptionID {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+	return cloneDelivery(delivery), nil
+}
+
+func (r *MemoryRepository) ListDeliveries(ctx context.Context, subscriptionID primitive.ObjectID, status string, limit int64) ([]Delivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deliveries := []Delivery{}
+	for _, delivery := range r.deliveries {
+		if delivery.SubscriptionID != subscriptionID || (status != "" && delivery.Status != status) {
+			continue
+		}
+		deliveries = append(deliveries, cloneDelivery(delivery))
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID.Hex() > deliveries[j].ID.Hex()
+	})
+	if limit > 0 && int64(len(deliveries)) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (r *MemoryRepository) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.deliveries[delivery.ID]; !ok {
+		return ErrDeliveryNotFound
+	}
+	r.deliveries[delivery.ID] = cloneDelivery(delivery)
+	return nil
+}
+
+func cloneSubscription(s Subscription) Subscription {
+	s.EventTypes = append(s.EventTypes[:0:0], s.EventTypes...)
+	return s
+}
+
+func cloneDelivery(d Delivery) Delivery {
+	d.Payload = append(d.Payload[:0:0], d.Payload...)
+	d.Attempts = append(d.Attempts[:0:0], d.Attempts...)
+	return d
+}
@@ -0,0 +1,9 @@
+package webhook
+
+import "testing"
+
+func Test_MemoryRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		return NewMemoryRepository()
+	})
+}
@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKey is the MongoDB error code of a unique index violation.
+const duplicateKey = 11000
+
+// MongoRepository stores subscriptions in a MongoDB collection, and their
+// deliveries in a companion collection named after it with a "_deliveries"
+// suffix.
+type MongoRepository struct {
+	db         *driver.Collection
+	deliveries *driver.Collection
+}
+
+func NewMongoRepository(db *driver.Collection) *MongoRepository {
+	return &MongoRepository{
+		db:         db,
+		deliveries: db.Database().Collection(db.Name() + "_deliveries"),
+	}
+}
+
+func (r *MongoRepository) InsertSubscription(ctx context.Context, subscription Subscription) error {
+	_, err := r.db.InsertOne(ctx, subscription)
+	return err
+}
+
+func (r *MongoRepository) FindSubscription(ctx context.Context, id primitive.ObjectID) (Subscription, error) {
+	var subscription Subscription
+
+	err := r.db.FindOne(ctx, bson.M{"_id": id}).Decode(&subscription)
+	if errors.Is(err, driver.ErrNoDocuments) {
+		return subscription, ErrSubscriptionNotFound
+	}
+
+	return subscription, err
+}
+
+func (r *MongoRepository) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	subscriptions := []Subscription{}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.db.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+func (r *MongoRepository) UpdateSubscription(ctx context.Context, subscription Subscription) error {
+	result, err := r.db.ReplaceOne(ctx, bson.M{"_id": subscription.ID}, subscription)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+// DeleteSubscription removes the subscription and its deliveries in a
+// transaction, so no delivery is left behind without its subscription.
+func (r *MongoRepository) DeleteSubscription(ctx context.Context, id primitive.ObjectID) error {
+	return r.transaction(ctx, func(ctx driver.SessionContext) error {
+		if _, err := r.deliveries.DeleteMany(ctx, bson.M{"subscription_id": id}); err != nil {
+			return err
+		}
+		result, err := r.db.DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return ErrSubscriptionNotFound
+		}
+		return nil
+	})
+}
+
+func (r *MongoRepository) InsertDeliveries(ctx context.Context, deliveries []Delivery) error {
+	documents := make([]interface{}, len(deliveries))
+	for i, delivery := range deliveries {
+		documents[i] = delivery
+	}
+
+	// The unique subscription and event index rejects the deliveries already
+	// enqueued, the others are still inserted.
+	_, err := r.deliveries.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	var bulkErr driver.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Code != duplicateKey {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+func (r *MongoRepository) ClaimDelivery(ctx context.Context, at, until time.Time) (Delivery, error) {
+	var delivery Delivery
+
+	filter := bson.M{"status": DeliveryPending, "next_attempt_at": bson.M{"$lte": at}}
+	updateOptions := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+	err := r.deliveries.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"next_attempt_at": until}}, updateOptions).Decode(&delivery)
+	if errors.Is(err, driver.ErrNoDocuments) {
+		return delivery, ErrDeliveryNotFound
+	}
+
+	return delivery, err
+}
+
+func (r *MongoRepository) FindDelivery(ctx context.Context, subscriptionID, id primitive.ObjectID) (Delivery, error) {
+	var delivery Delivery
+
+	err := r.deliveries.FindOne(ctx, bson.M{"_id": id, "subscription_id": subscriptionID}).Decode(&delivery)
+	if errors.Is(err, driver.ErrNoDocuments) {
+		return delivery, ErrDeliveryNotFound
+	}
+
+	return delivery, err
+}
+
+func (r *MongoRepository) ListDeliveries(ctx context.Context, subscriptionID primitive.ObjectID, status string, limit int64) ([]Delivery, error) {
+	filter := bson.M{"subscription_id": subscriptionID}
+	if status != "" {
+		filter["status"] = status
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit)
+	return r.findDeliveries(ctx, filter, findOptions)
+}
+
+func (r *MongoRepository) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	result, err := r.deliveries.ReplaceOne(ctx, bson.M{"_id": delivery.ID}, delivery)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+func (r *MongoRepository) findDeliveries(ctx context.Context, filter bson.M, findOptions *options.FindOptions) ([]Delivery, error) {
+	deliveries := []Delivery{}
+
+	cursor, err := r.deliveries.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// transaction runs fn in a transaction, which MongoDB retries on transient
+// errors. The deliveries collection must exist beforehand, as EnsureIndexes
+// makes sure.
+func (r *MongoRepository) transaction(ctx context.Context, fn func(ctx driver.SessionContext) error) error {
+	session, err := r.db.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx driver.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// EnsureIndexes creates the indexes enqueuing and delivering rely on when
+// missing.
+func (r *MongoRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.deliveries.Indexes().CreateMany(ctx, []driver.IndexModel{
+		{
+			Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "event_id", Value: 1}},
+			Options: options.Index().
+				SetName("subscription_event_unique").
+				SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+			Options: options.Index().SetName("due"),
+		},
+	})
+	return err
+}
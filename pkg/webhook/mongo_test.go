@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"star-wars/pkg/testutils/docker"
+
+	"github.com/ory/dockertest/v3"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func Test_MongoRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping MongoDB test in short mode")
+	}
+	if pool, err := dockertest.NewPool(""); err != nil || pool.Client.Ping() != nil {
+		t.Skip("skipping MongoDB test, Docker is not available")
+	}
+
+	mongoServer := docker.NewMongo()
+	mongoServer.WithTestPort(t).
+		Start(t)
+	t.Cleanup(func() { mongoServer.Stop() })
+
+	testRepository(t, func(t *testing.T) Repository {
+		r := NewMongoRepository(mongoCollection(mongoServer.GetHost(), primitive.NewObjectID().Hex()))
+		if err := r.EnsureIndexes(context.Background()); err != nil {
+			t.Fatalf("repository.EnsureIndexes() unexpected error %v", err)
+		}
+		return r
+	})
+}
+
+func mongoCollection(host, name string) *driver.Collection {
+	ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
+	defer cancel()
+	client, err := driver.Connect(ctx, options.Client().ApplyURI(host))
+
+	if err != nil {
+		log.Fatal("Error trying to connect to the database")
+	}
+
+	return client.Database("planet").Collection(name)
+}
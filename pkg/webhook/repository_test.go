@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testRepository is the conformance suite every Repository must pass.
+// newRepository returns an empty repository.
+func testRepository(t *testing.T, newRepository func(t *testing.T) Repository) {
+	ctx := context.Background()
+
+	t.Run("when subscriptions are changed, then they should be found as stored", func(t *testing.T) {
+		r := newRepository(t)
+		hooks := newSubscription("https://example.com/hooks", planet.PlanetCreated)
+		audit := newSubscription("https://example.com/audit")
+		insertSubscriptions(t, r, hooks, audit)
+
+		got, err := r.FindSubscription(ctx, hooks.ID)
+		if err != nil {
+			t.Fatalf("repository.FindSubscription() unexpected error %v", err)
+		}
+		assert.Equal(t, hooks.URL, got.URL, "repository.FindSubscription() unexpected url")
+		assert.Equal(t, hooks.EventTypes, got.EventTypes, "repository.FindSubscription() unexpected event types")
+		assert.Equal(t, hooks.Secret, got.Secret, "repository.FindSubscription() unexpected secret")
+
+		hooks.URL = "https://example.com/v2/hooks"
+		hooks.EventTypes = []planet.EventType{planet.PlanetDeleted}
+		if err := r.UpdateSubscription(ctx, hooks); err != nil {
+			t.Fatalf("repository.UpdateSubscription() unexpected error %v", err)
+		}
+		subscriptions, err := r.ListSubscriptions(ctx)
+		if err != nil {
+			t.Fatalf("repository.ListSubscriptions() unexpected error %v", err)
+		}
+		if assert.Len(t, subscriptions, 2, "repository.ListSubscriptions() unexpected subscriptions") {
+			assert.Equal(t, hooks.URL, subscriptions[0].URL, "repository.ListSubscriptions() the oldest subscription should come first")
+			assert.Equal(t, hooks.EventTypes, subscriptions[0].EventTypes, "repository.UpdateSubscription() unexpected event types")
+		}
+
+		missing := newSubscription("https://example.com/missing")
+		if err := r.UpdateSubscription(ctx, missing); !errors.Is(err, ErrSubscriptionNotFound) {
+			t.Errorf("repository.UpdateSubscription() errorType = %v, wantErrorType %v", err, ErrSubscriptionNotFound)
+		}
+		if _, err := r.FindSubscription(ctx, missing.ID); !errors.Is(err, ErrSubscriptionNotFound) {
+			t.Errorf("repository.FindSubscription() errorType = %v, wantErrorType %v", err, ErrSubscriptionNotFound)
+		}
+	})
+
+	t.Run("when an event is enqueued twice, then it should be delivered once per subscription", func(t *testing.T) {
+		r := newRepository(t)
+		hooks := newSubscription("https://example.com/hooks")
+		insertSubscriptions(t, r, hooks)
+		eventID := primitive.NewObjectID()
+
+		first := newDelivery(hooks.ID, eventID, time.Now().UTC())
+		if err := r.InsertDeliveries(ctx, []Delivery{first}); err != nil {
+			t.Fatalf("repository.InsertDeliveries() unexpected error %v", err)
+		}
+		again := newDelivery(hooks.ID, eventID, time.Now().UTC())
+		other := newDelivery(hooks.ID, primitive.NewObjectID(), time.Now().UTC())
+		if err := r.InsertDeliveries(ctx, []Delivery{again, other}); err != nil {
+			t.Fatalf("repository.InsertDeliveries() unexpected error %v", err)
+		}
+
+		deliveries, err := r.ListDeliveries(ctx, hooks.ID, "", 0)
+		if err != nil {
+			t.Fatalf("repository.ListDeliveries() unexpected error %v", err)
+		}
+		assert.Equal(t, []primitive.ObjectID{other.ID, first.ID}, deliveryIDs(deliveries), "repository.ListDeliveries() unexpected deliveries, newest first")
+		if len(deliveries) == 2 {
+			assert.JSONEq(t, string(first.Payload), string(deliveries[1].Payload), "repository.ListDeliveries() unexpected payload")
+		}
+	})
+
+	t.Run("when deliveries are due, then they should be claimed the most overdue first", func(t *testing.T) {
+		r := newRepository(t)
+		hooks := newSubscription("https://example.com/hooks")
+		insertSubscriptions(t, r, hooks)
+		now := time.Now().UTC().Truncate(time.Millisecond)
+
+		late := newDelivery(hooks.ID, primitive.NewObjectID(), now.Add(-time.Minute))
+		due := newDelivery(hooks.ID, primitive.NewObjectID(), now.Add(-time.Second))
+		later := newDelivery(hooks.ID, primitive.NewObjectID(), now.Add(time.Minute))
+		dead := newDelivery(hooks.ID, primitive.NewObjectID(), now.Add(-time.Hour))
+		dead.Status = DeliveryDead
+		if err := r.InsertDeliveries(ctx, []Delivery{due, later, late, dead}); err != nil {
+			t.Fatalf("repository.InsertDeliveries() unexpected error %v", err)
+		}
+
+		lease := now.Add(time.Minute)
+		var claimed []primitive.ObjectID
+		for i := 0; i < 3; i++ {
+			got, err := r.ClaimDelivery(ctx, now, lease)
+			if errors.Is(err, ErrDeliveryNotFound) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("repository.ClaimDelivery() unexpected error %v", err)
+			}
+			assert.True(t, lease.Equal(got.NextAttemptAt), "repository.ClaimDelivery() the next attempt should be deferred to the lease")
+			claimed = append(claimed, got.ID)
+		}
+		assert.Equal(t, []primitive.ObjectID{late.ID, due.ID}, claimed, "repository.ClaimDelivery() unexpected deliveries, each claimed once")
+		stored, err := r.FindDelivery(ctx, hooks.ID, due.ID)
+		if err != nil {
+			t.Fatalf("repository.FindDelivery() unexpected error %v", err)
+		}
+		assert.True(t, lease.Equal(stored.NextAttemptAt), "repository.ClaimDelivery() the lease should be stored")
+
+		late.Status = DeliverySucceeded
+		late.Tries = 1
+		late.Attempts = []Attempt{{At: now.Truncate(time.Millisecond), StatusCode: 204}}
+		if err := r.UpdateDelivery(ctx, late); err != nil {
+			t.Fatalf("repository.UpdateDelivery() unexpected error %v", err)
+		}
+		stored, err = r.FindDelivery(ctx, hooks.ID, late.ID)
+		if err != nil {
+			t.Fatalf("repository.FindDelivery() unexpected error %v", err)
+		}
+		assert.Equal(t, DeliverySucceeded, stored.Status, "repository.UpdateDelivery() unexpected status")
+		assert.Equal(t, late.Attempts, stored.Attempts, "repository.UpdateDelivery() unexpected attempts")
+
+		deadLetters, err := r.ListDeliveries(ctx, hooks.ID, DeliveryDead, 0)
+		if err != nil {
+			t.Fatalf("repository.ListDeliveries() unexpected error %v", err)
+		}
+		assert.Equal(t, []primitive.ObjectID{dead.ID}, deliveryIDs(deadLetters), "repository.ListDeliveries() unexpected status filter")
+
+		if _, err := r.FindDelivery(ctx, primitive.NewObjectID(), late.ID); !errors.Is(err, ErrDeliveryNotFound) {
+			t.Errorf("repository.FindDelivery() another subscription, errorType = %v, wantErrorType %v", err, ErrDeliveryNotFound)
+		}
+	})
+
+	t.Run("when a subscription is deleted, then its deliveries should go with it", func(t *testing.T) {
+		r := newRepository(t)
+		hooks := newSubscription("https://example.com/hooks")
+		insertSubscriptions(t, r, hooks)
+		delivery := newDelivery(hooks.ID, primitive.NewObjectID(), time.Now().UTC())
+		if err := r.InsertDeliveries(ctx, []Delivery{delivery}); err != nil {
+			t.Fatalf("repository.InsertDeliveries() unexpected error %v", err)
+		}
+
+		if err := r.DeleteSubscription(ctx, hooks.ID); err != nil {
+			t.Fatalf("repository.DeleteSubscription() unexpected error %v", err)
+		}
+		if _, err := r.FindDelivery(ctx, hooks.ID, delivery.ID); !errors.Is(err, ErrDeliveryNotFound) {
+			t.Errorf("repository.FindDelivery() errorType = %v, wantErrorType %v", err, ErrDeliveryNotFound)
+		}
+		if err := r.DeleteSubscription(ctx, hooks.ID); !errors.Is(err, ErrSubscriptionNotFound) {
+			t.Errorf("repository.DeleteSubscription() errorType = %v, wantErrorType %v", err, ErrSubscriptionNotFound)
+		}
+	})
+}
+
+func newSubscription(url string, eventTypes ...planet.EventType) Subscription {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return Subscription{
+		ID:         primitive.NewObjectID(),
+		URL:        url,
+		EventTypes: eventTypes,
+		Secret:     "s3cr3t",
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+func insertSubscriptions(t *testing.T, r Repository, subscriptions ...Subscription) {
+	for _, subscription := range subscriptions {
+		if err := r.InsertSubscription(context.Background(), subscription); err != nil {
+			t.Fatalf("repository.InsertSubscription() an error occurred inserting a subscription for test: %v", err)
+		}
+	}
+}
+
+func newDelivery(subscriptionID, eventID primitive.ObjectID, nextAttemptAt time.Time) Delivery {
+	return Delivery{
+		ID:             primitive.NewObjectID(),
+		SubscriptionID: subscriptionID,
+		EventID:        eventID,
+		EventType:      planet.PlanetCreated,
+		Payload:        []byte(`{"type": "PlanetCreated"}`),
+		Status:         DeliveryPending,
+		Attempts:       []Attempt{},
+		NextAttemptAt:  nextAttemptAt,
+		CreatedAt:      time.Now().UTC(),
+	}
+}
+
+func deliveryIDs(deliveries []Delivery) []primitive.ObjectID {
+	ids := []primitive.ObjectID{}
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	return ids
+}
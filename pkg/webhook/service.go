@@ -0,0 +1,372 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultDeliveryLimit = 20
+	MaxDeliveryLimit     = 100
+)
+
+const (
+	defaultMaxAttempts = 8
+	defaultBackoff     = 30 * time.Second
+	// maxBackoff caps the wait between two attempts, however many failed.
+	maxBackoff = 6 * time.Hour
+	// claimLease is how long a claimed delivery is left to the instance
+	// posting it. It outlasts any post, so an instance stopping mid-post only
+	// delays the delivery.
+	claimLease = 5 * time.Minute
+)
+
+type Service struct {
+	repo        Repository
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	encode      func(event planet.Event) ([]byte, error)
+	// allowPrivate lets the subscriptions point to private addresses.
+	allowPrivate bool
+}
+
+func NewService(repo Repository, client *http.Client) *Service {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Service{
+		repo:        repo,
+		client:      client,
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		encode:      func(event planet.Event) ([]byte, error) { return json.Marshal(event) },
+	}
+}
+
+// WithRetries sets how many times a delivery is attempted before it is parked
+// in the dead letters, and the wait before the first retry. The wait doubles
+// on every retry.
+func (s *Service) WithRetries(maxAttempts int, backoff time.Duration) *Service {
+	if maxAttempts > 0 {
+		s.maxAttempts = maxAttempts
+	}
+	if backoff > 0 {
+		s.backoff = backoff
+	}
+	return s
+}
+
+// WithEncoder sets how an event is turned into the body posted to the
+// subscriptions, the event as stored by default.
+func (s *Service) WithEncoder(encode func(event planet.Event) ([]byte, error)) *Service {
+	if encode != nil {
+		s.encode = encode
+	}
+	return s
+}
+
+// WithPrivateURLs lets the subscriptions point to loopback, link-local and
+// private addresses, like the services of a local network. They are rejected
+// by default, so a subscription cannot be used to reach internal hosts.
+func (s *Service) WithPrivateURLs(allowed bool) *Service {
+	s.allowPrivate = allowed
+	return s
+}
+
+// Create stores a new subscription. A secret is generated when none is given.
+func (s *Service) Create(ctx context.Context, subscription Subscription) (Subscription, error) {
+	if err := s.checkURL(subscription.URL); err != nil {
+		return Subscription{}, err
+	}
+	if subscription.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			return Subscription{}, err
+		}
+		subscription.Secret = secret
+	}
+	subscription.ID = primitive.NewObjectID()
+	subscription.CreatedAt = time.Now().UTC()
+	subscription.UpdatedAt = subscription.CreatedAt
+
+	if err := s.repo.InsertSubscription(ctx, subscription); err != nil {
+		return Subscription{}, err
+	}
+	return subscription, nil
+}
+
+func (s *Service) Get(ctx context.Context, id string) (Subscription, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	return s.repo.FindSubscription(ctx, objectID)
+}
+
+func (s *Service) List(ctx context.Context) ([]Subscription, error) {
+	return s.repo.ListSubscriptions(ctx)
+}
+
+// Update replaces the URL and event types of a subscription. The secret is
+// kept unless a new one is given.
+func (s *Service) Update(ctx context.Context, subscription Subscription) (Subscription, error) {
+	if err := s.checkURL(subscription.URL); err != nil {
+		return Subscription{}, err
+	}
+	stored, err := s.repo.FindSubscription(ctx, subscription.ID)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	stored.URL = subscription.URL
+	stored.EventTypes = subscription.EventTypes
+	if subscription.Secret != "" {
+		stored.Secret = subscription.Secret
+	}
+	stored.UpdatedAt = time.Now().UTC()
+
+	if err := s.repo.UpdateSubscription(ctx, stored); err != nil {
+		return Subscription{}, err
+	}
+	return stored, nil
+}
+
+// checkURL rejects the private URLs, unless they are allowed.
+func (s *Service) checkURL(raw string) error {
+	if s.allowPrivate {
+		return nil
+	}
+	return checkURL(raw)
+}
+
+// Delete removes a subscription. Its pending deliveries are dropped.
+func (s *Service) Delete(ctx context.Context, id string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	return s.repo.DeleteSubscription(ctx, objectID)
+}
+
+// Enqueue schedules the delivery of an event to every subscription wanting
+// it. It subscribes to the planet events, and may be called more than once
+// for the same event.
+func (s *Service) Enqueue(ctx context.Context, event planet.Event) error {
+	subscriptions, err := s.repo.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := s.encode(event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var deliveries []Delivery
+	for _, subscription := range subscriptions {
+		if !subscription.wants(event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			ID:             primitive.NewObjectID(),
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+			Status:         DeliveryPending,
+			Attempts:       []Attempt{},
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return s.repo.InsertDeliveries(ctx, deliveries)
+}
+
+// Deliver attempts up to limit due deliveries and returns how many succeeded.
+// Each delivery is claimed before it is posted, so the instances delivering
+// side by side never post the same one. A failed delivery is retried later
+// with an exponential backoff, and parked in the dead letters once every
+// attempt failed. Only storage failures are returned, the endpoint failures
+// are recorded in the delivery log. A zero limit means no limit.
+func (s *Service) Deliver(ctx context.Context, limit int64) (int, error) {
+	// The deliveries rescheduled by this round are left to the next one.
+	now := time.Now().UTC()
+
+	delivered := 0
+	for claimed := int64(0); limit <= 0 || claimed < limit; claimed++ {
+		delivery, err := s.repo.ClaimDelivery(ctx, now, time.Now().UTC().Add(claimLease))
+		if errors.Is(err, ErrDeliveryNotFound) {
+			break
+		}
+		if err != nil {
+			return delivered, err
+		}
+
+		subscription, err := s.repo.FindSubscription(ctx, delivery.SubscriptionID)
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			// The subscription was deleted while the delivery was enqueued,
+			// it is parked so it does not stay due ahead of the others.
+			delivery.Attempts = append(delivery.Attempts, Attempt{At: time.Now().UTC(), Error: "subscription deleted"})
+			delivery.Status = DeliveryDead
+			if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+		if err != nil {
+			return delivered, err
+		}
+
+		attempt := s.post(ctx, subscription, delivery)
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Tries++
+		switch {
+		case attempt.Error == "":
+			delivery.Status = DeliverySucceeded
+			delivered++
+		case delivery.Tries >= s.maxAttempts:
+			delivery.Status = DeliveryDead
+		default:
+			delivery.NextAttemptAt = attempt.At.Add(s.retryDelay(delivery.Tries))
+		}
+
+		if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+			return delivered, err
+		}
+	}
+
+	return delivered, nil
+}
+
+// retryDelay is the wait after the given number of failed attempts.
+func (s *Service) retryDelay(tries int) time.Duration {
+	delay := s.backoff
+	for i := 1; i < tries && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// post sends the signed payload of a delivery to the subscription. Any
+// response other than 2xx fails the attempt.
+func (s *Service) post(ctx context.Context, subscription Subscription, delivery Delivery) Attempt {
+	attempt := Attempt{At: time.Now().UTC()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := attempt.At.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, delivery.ID.Hex())
+	req.Header.Set(HeaderEvent, string(delivery.EventType))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, delivery.Payload))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer res.Body.Close()
+	// Draining the body lets the connection be reused.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	attempt.StatusCode = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("endpoint responded %d", res.StatusCode)
+	}
+	return attempt
+}
+
+// Deliveries returns the latest deliveries of a subscription, newest first,
+// only those in the given status unless it is empty. The limit defaults to
+// DefaultDeliveryLimit and is capped at MaxDeliveryLimit.
+func (s *Service) Deliveries(ctx context.Context, subscriptionID string, status string, limit int64) ([]Delivery, error) {
+	subscription, err := s.Get(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultDeliveryLimit
+	}
+	if limit > MaxDeliveryLimit {
+		limit = MaxDeliveryLimit
+	}
+	return s.repo.ListDeliveries(ctx, subscription.ID, status, limit)
+}
+
+// Replay schedules a dead delivery to be attempted again right away, with
+// as many attempts as a new one.
+func (s *Service) Replay(ctx context.Context, subscriptionID, deliveryID string) (Delivery, error) {
+	subscription, err := s.Get(ctx, subscriptionID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	objectID, _ := primitive.ObjectIDFromHex(deliveryID)
+	delivery, err := s.repo.FindDelivery(ctx, subscription.ID, objectID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	if delivery.Status != DeliveryDead {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+
+	return s.replay(ctx, delivery)
+}
+
+// ReplayAll schedules every dead delivery of a subscription to be attempted
+// again, and returns how many were.
+func (s *Service) ReplayAll(ctx context.Context, subscriptionID string) (int, error) {
+	subscription, err := s.Get(ctx, subscriptionID)
+	if err != nil {
+		return 0, err
+	}
+	dead, err := s.repo.ListDeliveries(ctx, subscription.ID, DeliveryDead, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, delivery := range dead {
+		if _, err := s.replay(ctx, delivery); err != nil {
+			return i, err
+		}
+	}
+	return len(dead), nil
+}
+
+func (s *Service) replay(ctx context.Context, delivery Delivery) (Delivery, error) {
+	delivery.Status = DeliveryPending
+	delivery.Tries = 0
+	delivery.NextAttemptAt = time.Now().UTC()
+	if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+		return Delivery{}, err
+	}
+	return delivery, nil
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
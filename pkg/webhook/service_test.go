@@ -0,0 +1,306 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// endpointMock answers the webhook posts with the given statuses in turn,
+// and records the requests.
+type endpointMock struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (e *endpointMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	e.requests = append(e.requests, r)
+	e.bodies = append(e.bodies, body)
+	status := http.StatusNoContent
+	if len(e.statuses) > 0 {
+		status, e.statuses = e.statuses[0], e.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func Test_service_Deliver(t *testing.T) {
+	ctx := context.Background()
+	endpoint := &endpointMock{statuses: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	repo := NewMemoryRepository()
+	s := NewService(repo, server.Client()).WithPrivateURLs(true).WithRetries(3, time.Nanosecond)
+	hooks, err := s.Create(ctx, Subscription{URL: server.URL, EventTypes: []planet.EventType{planet.PlanetCreated}})
+	if err != nil {
+		t.Fatalf("service.Create() unexpected error %v", err)
+	}
+	assert.Len(t, hooks.Secret, 64, "service.Create() a secret should be generated")
+
+	created := planet.Event{ID: primitive.NewObjectID(), Type: planet.PlanetCreated, Planet: planet.Planet{Name: "Hoth"}}
+	for _, event := range []planet.Event{created, created, {ID: primitive.NewObjectID(), Type: planet.PlanetDeleted}} {
+		if err := s.Enqueue(ctx, event); err != nil {
+			t.Fatalf("service.Enqueue() unexpected error %v", err)
+		}
+	}
+
+	delivered, err := s.Deliver(ctx, 10)
+	if err != nil {
+		t.Fatalf("service.Deliver() unexpected error %v", err)
+	}
+	assert.Equal(t, 0, delivered, "service.Deliver() a failed post should not be delivered")
+
+	time.Sleep(time.Millisecond)
+	delivered, err = s.Deliver(ctx, 10)
+	if err != nil {
+		t.Fatalf("service.Deliver() unexpected error %v", err)
+	}
+	assert.Equal(t, 1, delivered, "service.Deliver() the retry should be delivered")
+
+	if assert.Len(t, endpoint.requests, 2, "service.Deliver() an event should be posted once per subscription and try") {
+		req := endpoint.requests[1]
+		assert.Equal(t, string(planet.PlanetCreated), req.Header.Get(HeaderEvent), "service.Deliver() unexpected event header")
+		timestamp, _ := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+		assert.Equal(t, Sign(hooks.Secret, timestamp, endpoint.bodies[1]), req.Header.Get(HeaderSignature), "service.Deliver() unexpected signature")
+		assert.Contains(t, string(endpoint.bodies[1]), `"name":"Hoth"`, "service.Deliver() the payload should carry the planet")
+	}
+
+	deliveries, err := s.Deliveries(ctx, hooks.ID.Hex(), "", 0)
+	if err != nil {
+		t.Fatalf("service.Deliveries() unexpected error %v", err)
+	}
+	if assert.Len(t, deliveries, 1, "service.Deliveries() unexpected deliveries") {
+		assert.Equal(t, DeliverySucceeded, deliveries[0].Status, "service.Deliveries() unexpected status")
+		if assert.Len(t, deliveries[0].Attempts, 2, "service.Deliveries() every attempt should be logged") {
+			assert.Equal(t, http.StatusInternalServerError, deliveries[0].Attempts[0].StatusCode, "service.Deliveries() unexpected status code")
+			assert.NotEmpty(t, deliveries[0].Attempts[0].Error, "service.Deliveries() a failed attempt should be explained")
+		}
+	}
+}
+
+func Test_service_Deliver_deletedSubscription(t *testing.T) {
+	ctx := context.Background()
+	endpoint := &endpointMock{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	repo := NewMemoryRepository()
+	s := NewService(repo, server.Client()).WithPrivateURLs(true)
+	deleted, _ := s.Create(ctx, Subscription{URL: server.URL})
+	if err := s.Enqueue(ctx, planet.Event{ID: primitive.NewObjectID(), Type: planet.PlanetCreated}); err != nil {
+		t.Fatalf("service.Enqueue() unexpected error %v", err)
+	}
+	if err := s.Delete(ctx, deleted.ID.Hex()); err != nil {
+		t.Fatalf("service.Delete() unexpected error %v", err)
+	}
+	if _, err := repo.ClaimDelivery(ctx, time.Now().UTC(), time.Now().UTC()); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("service.Delete() the pending deliveries should be deleted along, errorType = %v, wantErrorType %v", err, ErrDeliveryNotFound)
+	}
+
+	// A delivery enqueued while its subscription was deleted is left behind,
+	// and is the most overdue.
+	orphan := Delivery{
+		ID:             primitive.NewObjectID(),
+		SubscriptionID: deleted.ID,
+		EventID:        primitive.NewObjectID(),
+		EventType:      planet.PlanetCreated,
+		Status:         DeliveryPending,
+		NextAttemptAt:  time.Now().UTC().Add(-time.Hour),
+	}
+	if err := repo.InsertDeliveries(ctx, []Delivery{orphan}); err != nil {
+		t.Fatalf("repository.InsertDeliveries() unexpected error %v", err)
+	}
+	if _, err := s.Create(ctx, Subscription{URL: server.URL + "/live"}); err != nil {
+		t.Fatalf("service.Create() unexpected error %v", err)
+	}
+	if err := s.Enqueue(ctx, planet.Event{ID: primitive.NewObjectID(), Type: planet.PlanetCreated}); err != nil {
+		t.Fatalf("service.Enqueue() unexpected error %v", err)
+	}
+
+	delivered, err := s.Deliver(ctx, 1)
+	if err != nil {
+		t.Fatalf("service.Deliver() unexpected error %v", err)
+	}
+	assert.Equal(t, 0, delivered, "service.Deliver() the orphan delivery should not be delivered")
+	assert.Empty(t, endpoint.requests, "service.Deliver() the orphan delivery should not be posted")
+	parked, err := repo.FindDelivery(ctx, deleted.ID, orphan.ID)
+	if err != nil {
+		t.Fatalf("repository.FindDelivery() unexpected error %v", err)
+	}
+	assert.Equal(t, DeliveryDead, parked.Status, "service.Deliver() the orphan delivery should be parked")
+
+	delivered, err = s.Deliver(ctx, 1)
+	if err != nil {
+		t.Fatalf("service.Deliver() unexpected error %v", err)
+	}
+	assert.Equal(t, 1, delivered, "service.Deliver() the orphan delivery should not hold back the others")
+	if assert.Len(t, endpoint.requests, 1, "service.Deliver() unexpected posts") {
+		assert.Equal(t, "/live", endpoint.requests[0].URL.Path, "service.Deliver() unexpected subscription")
+	}
+}
+
+func Test_service_Deliver_concurrently(t *testing.T) {
+	ctx := context.Background()
+	endpoint := &endpointMock{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	repo := NewMemoryRepository()
+	first := NewService(repo, server.Client()).WithPrivateURLs(true)
+	second := NewService(repo, server.Client())
+	if _, err := first.Create(ctx, Subscription{URL: server.URL}); err != nil {
+		t.Fatalf("service.Create() unexpected error %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := first.Enqueue(ctx, planet.Event{ID: primitive.NewObjectID(), Type: planet.PlanetUpdated}); err != nil {
+			t.Fatalf("service.Enqueue() unexpected error %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	delivered := make([]int, 2)
+	for i, s := range []*Service{first, second} {
+		wg.Add(1)
+		go func(i int, s *Service) {
+			defer wg.Done()
+			delivered[i], _ = s.Deliver(ctx, 0)
+		}(i, s)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 20, delivered[0]+delivered[1], "service.Deliver() every delivery should be delivered")
+	assert.Len(t, endpoint.requests, 20, "service.Deliver() a delivery should be posted by a single instance")
+}
+
+func Test_service_Replay(t *testing.T) {
+	ctx := context.Background()
+	endpoint := &endpointMock{statuses: []int{http.StatusGone, http.StatusGone}}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	s := NewService(NewMemoryRepository(), server.Client()).WithPrivateURLs(true).WithRetries(2, time.Nanosecond)
+	hooks, _ := s.Create(ctx, Subscription{URL: server.URL})
+	if err := s.Enqueue(ctx, planet.Event{ID: primitive.NewObjectID(), Type: planet.PlanetUpdated}); err != nil {
+		t.Fatalf("service.Enqueue() unexpected error %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		if _, err := s.Deliver(ctx, 10); err != nil {
+			t.Fatalf("service.Deliver() unexpected error %v", err)
+		}
+	}
+	assert.Len(t, endpoint.requests, 2, "service.Deliver() a dead delivery should not be attempted again")
+
+	dead, err := s.Deliveries(ctx, hooks.ID.Hex(), DeliveryDead, 0)
+	if err != nil {
+		t.Fatalf("service.Deliveries() unexpected error %v", err)
+	}
+	if !assert.Len(t, dead, 1, "service.Deliver() the delivery should be parked in the dead letters") {
+		return
+	}
+
+	if _, err := s.Replay(ctx, hooks.ID.Hex(), primitive.NewObjectID().Hex()); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("service.Replay() errorType = %v, wantErrorType %v", err, ErrDeliveryNotFound)
+	}
+	replayed, err := s.Replay(ctx, hooks.ID.Hex(), dead[0].ID.Hex())
+	if err != nil {
+		t.Fatalf("service.Replay() unexpected error %v", err)
+	}
+	assert.Equal(t, DeliveryPending, replayed.Status, "service.Replay() unexpected status")
+	if _, err := s.Replay(ctx, hooks.ID.Hex(), dead[0].ID.Hex()); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("service.Replay() a pending delivery, errorType = %v, wantErrorType %v", err, ErrDeliveryNotFound)
+	}
+
+	delivered, err := s.Deliver(ctx, 10)
+	if err != nil {
+		t.Fatalf("service.Deliver() unexpected error %v", err)
+	}
+	assert.Equal(t, 1, delivered, "service.Deliver() the replayed delivery should be attempted again")
+
+	replayedAll, err := s.ReplayAll(ctx, hooks.ID.Hex())
+	if err != nil {
+		t.Fatalf("service.ReplayAll() unexpected error %v", err)
+	}
+	assert.Equal(t, 0, replayedAll, "service.ReplayAll() no dead letter should be left")
+	if _, err := s.ReplayAll(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Errorf("service.ReplayAll() errorType = %v, wantErrorType %v", err, ErrSubscriptionNotFound)
+	}
+}
+
+func Test_service_retryDelay(t *testing.T) {
+	s := NewService(NewMemoryRepository(), nil).WithRetries(0, time.Minute)
+
+	tests := []struct {
+		tries int
+		want  time.Duration
+	}{
+		{tries: 1, want: time.Minute},
+		{tries: 2, want: 2 * time.Minute},
+		{tries: 4, want: 8 * time.Minute},
+		{tries: 20, want: maxBackoff},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, s.retryDelay(tt.tries), "service.retryDelay(%d) unexpected delay", tt.tries)
+	}
+}
+
+func Test_service_Create_privateURL(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		url     string
+		wantErr error
+	}{
+		{url: "http://127.0.0.1:8080/hooks", wantErr: ErrPrivateURL},
+		{url: "http://localhost/hooks", wantErr: ErrPrivateURL},
+		{url: "http://10.1.2.3/hooks", wantErr: ErrPrivateURL},
+		{url: "http://192.168.0.10/hooks", wantErr: ErrPrivateURL},
+		{url: "http://169.254.169.254/latest/meta-data", wantErr: ErrPrivateURL},
+		{url: "http://[::1]/hooks", wantErr: ErrPrivateURL},
+		{url: "https://example.com/hooks"},
+		{url: "https://93.184.216.34/hooks"},
+	}
+	for _, tt := range tests {
+		s := NewService(NewMemoryRepository(), nil)
+		if _, err := s.Create(ctx, Subscription{URL: tt.url}); !errors.Is(err, tt.wantErr) {
+			t.Errorf("service.Create(%s) errorType = %v, wantErrorType %v", tt.url, err, tt.wantErr)
+		}
+		if _, err := s.WithPrivateURLs(true).Create(ctx, Subscription{URL: tt.url}); err != nil {
+			t.Errorf("service.Create(%s) private URLs allowed, unexpected error %v", tt.url, err)
+		}
+	}
+}
+
+func TestPublicDialer(t *testing.T) {
+	server := httptest.NewServer(&endpointMock{})
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: PublicDialer(&net.Dialer{}).DialContext}}
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrPrivateURL) {
+		t.Errorf("PublicDialer() errorType = %v, wantErrorType %v", err, ErrPrivateURL)
+	}
+}
+
+func TestSign(t *testing.T) {
+	got := Sign("s3cr3t", 1700000000, []byte(`{"type":"PlanetCreated"}`))
+	assert.Equal(t, "sha256=363eb8b201b5f8a37ad51d1a7ce1f272fb24657d84e3294f6a842b4636709e27", got, "Sign() unexpected signature")
+}
@@ -0,0 +1,323 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlSchema creates the tables of the subscriptions and their deliveries.
+// Lists are stored as JSON arrays and times as Unix nanoseconds, like the
+// planets. An event is delivered once per subscription.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id          TEXT PRIMARY KEY,
+		url         TEXT NOT NULL,
+		event_types TEXT NOT NULL,
+		secret      TEXT NOT NULL,
+		created_at  INTEGER NOT NULL,
+		updated_at  INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id              TEXT PRIMARY KEY,
+		subscription_id TEXT NOT NULL,
+		event_id        TEXT NOT NULL,
+		event_type      TEXT NOT NULL,
+		payload         TEXT NOT NULL,
+		status          TEXT NOT NULL,
+		tries           INTEGER NOT NULL,
+		attempts        TEXT NOT NULL,
+		next_attempt_at INTEGER NOT NULL,
+		created_at      INTEGER NOT NULL,
+		UNIQUE (subscription_id, event_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at)`,
+}
+
+// sqlSubscriptionColumns are the subscription columns, in the order
+// scanSubscription reads them.
+const sqlSubscriptionColumns = "id, url, event_types, secret, created_at, updated_at"
+
+// sqlDeliveryColumns are the delivery columns, in the order scanDelivery
+// reads them.
+const sqlDeliveryColumns = "id, subscription_id, event_id, event_type, payload, status, tries, attempts, next_attempt_at, created_at"
+
+// SQLRepository stores subscriptions and deliveries in a SQLite database,
+// next to the planets of planet.SQLRepository.
+type SQLRepository struct {
+	db *sql.DB
+}
+
+func NewSQLRepository(db *sql.DB) *SQLRepository {
+	return &SQLRepository{
+		db: db,
+	}
+}
+
+// EnsureSchema creates the subscriptions and deliveries tables and their
+// indexes when missing.
+func (r *SQLRepository) EnsureSchema(ctx context.Context) error {
+	for _, statement := range sqlSchema {
+		if _, err := r.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLRepository) InsertSubscription(ctx context.Context, subscription Subscription) error {
+	eventTypes, err := json.Marshal(subscription.EventTypes)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT INTO webhooks ("+sqlSubscriptionColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		subscription.ID.Hex(), subscription.URL, string(eventTypes), subscription.Secret,
+		subscription.CreatedAt.UnixNano(), subscription.UpdatedAt.UnixNano())
+	return err
+}
+
+func (r *SQLRepository) FindSubscription(ctx context.Context, id primitive.ObjectID) (Subscription, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+sqlSubscriptionColumns+" FROM webhooks WHERE id = ?", id.Hex())
+	subscription, err := scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription, ErrSubscriptionNotFound
+	}
+	return subscription, err
+}
+
+func (r *SQLRepository) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+sqlSubscriptionColumns+" FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+func (r *SQLRepository) UpdateSubscription(ctx context.Context, subscription Subscription) error {
+	eventTypes, err := json.Marshal(subscription.EventTypes)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.ExecContext(ctx, "UPDATE webhooks SET url = ?, event_types = ?, secret = ?, updated_at = ? WHERE id = ?",
+		subscription.URL, string(eventTypes), subscription.Secret, subscription.UpdatedAt.UnixNano(), subscription.ID.Hex())
+	if err != nil {
+		return err
+	}
+	return affected(result, ErrSubscriptionNotFound)
+}
+
+func (r *SQLRepository) DeleteSubscription(ctx context.Context, id primitive.ObjectID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE subscription_id = ?", id.Hex()); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", id.Hex())
+	if err != nil {
+		return err
+	}
+	if err := affected(result, ErrSubscriptionNotFound); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLRepository) InsertDeliveries(ctx context.Context, deliveries []Delivery) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, delivery := range deliveries {
+		args, err := deliveryArgs(delivery)
+		if err != nil {
+			return err
+		}
+		// The unique subscription and event pair skips the deliveries
+		// already enqueued.
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO webhook_deliveries ("+sqlDeliveryColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", args...)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClaimDelivery defers the next attempt of the delivery it picks only if it is
+// still due, and picks another one when an instance sharing the database
+// claimed it first.
+func (r *SQLRepository) ClaimDelivery(ctx context.Context, at, until time.Time) (Delivery, error) {
+	for {
+		row := r.db.QueryRowContext(ctx, "SELECT "+sqlDeliveryColumns+" FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT 1",
+			DeliveryPending, at.UnixNano())
+		delivery, err := scanDelivery(row)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Delivery{}, ErrDeliveryNotFound
+		}
+		if err != nil {
+			return Delivery{}, err
+		}
+
+		result, err := r.db.ExecContext(ctx, "UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ? AND status = ? AND next_attempt_at = ?",
+			until.UnixNano(), delivery.ID.Hex(), DeliveryPending, delivery.NextAttemptAt.UnixNano())
+		if err != nil {
+			return Delivery{}, err
+		}
+		if err := affected(result, ErrDeliveryNotFound); errors.Is(err, ErrDeliveryNotFound) {
+			continue
+		} else if err != nil {
+			return Delivery{}, err
+		}
+
+		delivery.NextAttemptAt = until
+		return delivery, nil
+	}
+}
+
+func (r *SQLRepository) FindDelivery(ctx context.Context, subscriptionID, id primitive.ObjectID) (Delivery, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+sqlDeliveryColumns+" FROM webhook_deliveries WHERE id = ? AND subscription_id = ?", id.Hex(), subscriptionID.Hex())
+	delivery, err := scanDelivery(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return delivery, ErrDeliveryNotFound
+	}
+	return delivery, err
+}
+
+func (r *SQLRepository) ListDeliveries(ctx context.Context, subscriptionID primitive.ObjectID, status string, limit int64) ([]Delivery, error) {
+	query := "SELECT " + sqlDeliveryColumns + " FROM webhook_deliveries WHERE subscription_id = ?"
+	args := []interface{}{subscriptionID.Hex()}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return r.deliveries(ctx, query, args...)
+}
+
+func (r *SQLRepository) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	attempts, err := json.Marshal(delivery.Attempts)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.ExecContext(ctx, "UPDATE webhook_deliveries SET status = ?, tries = ?, attempts = ?, next_attempt_at = ? WHERE id = ?",
+		delivery.Status, delivery.Tries, string(attempts), delivery.NextAttemptAt.UnixNano(), delivery.ID.Hex())
+	if err != nil {
+		return err
+	}
+	return affected(result, ErrDeliveryNotFound)
+}
+
+func (r *SQLRepository) deliveries(ctx context.Context, query string, args ...interface{}) ([]Delivery, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func deliveryArgs(d Delivery) ([]interface{}, error) {
+	attempts, err := json.Marshal(d.Attempts)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		d.ID.Hex(), d.SubscriptionID.Hex(), d.EventID.Hex(), d.EventType, string(d.Payload),
+		d.Status, d.Tries, string(attempts), d.NextAttemptAt.UnixNano(), d.CreatedAt.UnixNano(),
+	}, nil
+}
+
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(row sqlScanner) (Subscription, error) {
+	var s Subscription
+	var id, eventTypes string
+	var createdAt, updatedAt int64
+	if err := row.Scan(&id, &s.URL, &eventTypes, &s.Secret, &createdAt, &updatedAt); err != nil {
+		return Subscription{}, err
+	}
+
+	var err error
+	if s.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return Subscription{}, err
+	}
+	if err := json.Unmarshal([]byte(eventTypes), &s.EventTypes); err != nil {
+		return Subscription{}, err
+	}
+	s.CreatedAt = time.Unix(0, createdAt).UTC()
+	s.UpdatedAt = time.Unix(0, updatedAt).UTC()
+	return s, nil
+}
+
+func scanDelivery(row sqlScanner) (Delivery, error) {
+	var d Delivery
+	var id, subscriptionID, eventID, payload, attempts string
+	var nextAttemptAt, createdAt int64
+	err := row.Scan(&id, &subscriptionID, &eventID, &d.EventType, &payload, &d.Status, &d.Tries, &attempts, &nextAttemptAt, &createdAt)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	if d.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return Delivery{}, err
+	}
+	if d.SubscriptionID, err = primitive.ObjectIDFromHex(subscriptionID); err != nil {
+		return Delivery{}, err
+	}
+	if d.EventID, err = primitive.ObjectIDFromHex(eventID); err != nil {
+		return Delivery{}, err
+	}
+	if err := json.Unmarshal([]byte(attempts), &d.Attempts); err != nil {
+		return Delivery{}, err
+	}
+	d.Payload = json.RawMessage(payload)
+	d.NextAttemptAt = time.Unix(0, nextAttemptAt).UTC()
+	d.CreatedAt = time.Unix(0, createdAt).UTC()
+	return d, nil
+}
+
+// affected returns notFound when the statement changed no row.
+func affected(result sql.Result, notFound error) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return notFound
+	}
+	return nil
+}
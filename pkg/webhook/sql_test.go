@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func Test_SQLRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T) Repository {
+		db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "planets.db")+"?_txlock=immediate")
+		if err != nil {
+			t.Fatalf("sql.Open() unexpected error %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		r := NewSQLRepository(db)
+		if err := r.EnsureSchema(context.Background()); err != nil {
+			t.Fatalf("repository.EnsureSchema() unexpected error %v", err)
+		}
+		return r
+	})
+}
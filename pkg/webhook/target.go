@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// privateNetworks are the ranges not covered by the net.IP predicates that
+// still only reach hosts of the local network.
+var privateNetworks = parseNetworks(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+)
+
+// checkURL rejects the URLs whose host is a loopback, link-local or private
+// address, or localhost. Host names are checked again when posting, by the
+// dialer of PublicDialer, as they may resolve to such an address.
+func checkURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrPrivateURL, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && isPrivate(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateURL, host)
+	}
+	return nil
+}
+
+// PublicDialer makes the dialer refuse to connect to loopback, link-local and
+// private addresses, so a subscription cannot reach the internal hosts, even
+// through a host name resolving to one of them.
+func PublicDialer(dialer *net.Dialer) *net.Dialer {
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+			return fmt.Errorf("%w: %s", ErrPrivateURL, host)
+		}
+		return nil
+	}
+	return dialer
+}
+
+func isPrivate(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"star-wars/pkg/planet"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrDeliveryNotFound     = errors.New("webhook delivery not found")
+	// ErrPrivateURL is returned for a subscription URL that points to a
+	// loopback, link-local or private address.
+	ErrPrivateURL = errors.New("webhook url points to a private address")
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	// DeliveryDead parks a delivery that failed every attempt, until it is
+	// replayed.
+	DeliveryDead = "dead"
+)
+
+// Subscription asks for the planet events of the given types to be posted to
+// URL, signed with Secret. No event type means every event.
+type Subscription struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	URL        string             `bson:"url" json:"url"`
+	EventTypes []planet.EventType `bson:"event_types" json:"event_types"`
+	Secret     string             `bson:"secret" json:"secret"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// wants tells whether the subscription is interested in events of the type.
+func (s Subscription) wants(eventType planet.EventType) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, wanted := range s.EventTypes {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event to post to one subscription, with the log of every
+// attempt made. Tries counts the attempts since it was enqueued or last
+// replayed, and is what the retries are limited on.
+type Delivery struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	SubscriptionID primitive.ObjectID `bson:"subscription_id" json:"subscription_id"`
+	EventID        primitive.ObjectID `bson:"event_id" json:"event_id"`
+	EventType      planet.EventType   `bson:"event_type" json:"event_type"`
+	Payload        json.RawMessage    `bson:"payload" json:"payload"`
+	Status         string             `bson:"status" json:"status"`
+	Tries          int                `bson:"tries" json:"tries"`
+	Attempts       []Attempt          `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Attempt records one post of a delivery. StatusCode is zero when no response
+// was received.
+type Attempt struct {
+	At         time.Time `bson:"at" json:"at"`
+	StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// Repository stores the subscriptions and their deliveries.
+type Repository interface {
+	InsertSubscription(ctx context.Context, subscription Subscription) error
+	FindSubscription(ctx context.Context, id primitive.ObjectID) (Subscription, error)
+	// ListSubscriptions returns every subscription, oldest first.
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	// UpdateSubscription replaces a stored subscription.
+	UpdateSubscription(ctx context.Context, subscription Subscription) error
+	// DeleteSubscription removes a subscription along with its deliveries.
+	DeleteSubscription(ctx context.Context, id primitive.ObjectID) error
+
+	// InsertDeliveries stores new deliveries. A delivery of an event already
+	// enqueued for the same subscription is skipped, so an event published
+	// again is not delivered again.
+	InsertDeliveries(ctx context.Context, deliveries []Delivery) error
+	// ClaimDelivery returns the most overdue pending delivery whose next
+	// attempt is due at the given time, and atomically defers that attempt
+	// until the given lease time, so no other instance attempts it meanwhile.
+	// It returns ErrDeliveryNotFound when none is due.
+	ClaimDelivery(ctx context.Context, at, until time.Time) (Delivery, error)
+	FindDelivery(ctx context.Context, subscriptionID, id primitive.ObjectID) (Delivery, error)
+	// ListDeliveries returns up to limit deliveries of a subscription, newest
+	// first, only those in the given status unless it is empty.
+	ListDeliveries(ctx context.Context, subscriptionID primitive.ObjectID, status string, limit int64) ([]Delivery, error)
+	// UpdateDelivery replaces a stored delivery.
+	UpdateDelivery(ctx context.Context, delivery Delivery) error
+}